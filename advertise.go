@@ -0,0 +1,194 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package airscan
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/brutella/dnssd"
+)
+
+// TXTRecord returns the DNS-SD TXT record entries which describe an eSCL
+// server with the specified capabilities, as clients (e.g. macOS or
+// sane-airscan) expect them: txtvers, ty, rs, vers, uuid, cs, is, pdl, duplex,
+// adminurl and representation. Entries for which caps has no value are
+// omitted.
+func TXTRecord(caps *ScannerCapabilities) map[string]string {
+	text := map[string]string{
+		"txtvers": "1",
+		"rs":      "eSCL",
+	}
+	set := func(k, v string) {
+		if v != "" {
+			text[k] = v
+		}
+	}
+	set("ty", caps.MakeAndModel)
+	if caps.Version > 0 {
+		set("vers", strconv.FormatFloat(caps.Version, 'f', -1, 64))
+	}
+	set("uuid", caps.UUID)
+	set("adminurl", caps.AdminURI)
+	set("representation", caps.IconURI)
+
+	var (
+		inputs  []*InputCaps
+		sources []string
+	)
+	if caps.Platen != nil {
+		sources = append(sources, "platen")
+		inputs = append(inputs, &caps.Platen.PlatenInputCaps)
+	}
+	if caps.Adf != nil {
+		sources = append(sources, "adf")
+		for _, ic := range []*InputCaps{caps.Adf.AdfSimplexInputCaps, caps.Adf.AdfDuplexInputCaps} {
+			if ic != nil {
+				inputs = append(inputs, ic)
+			}
+		}
+		text["duplex"] = "F"
+		if caps.Adf.AdfDuplexInputCaps != nil {
+			text["duplex"] = "T"
+		}
+	}
+	set("is", strings.Join(sources, ","))
+
+	var (
+		formats []string
+		seen    = make(map[string]bool)
+		spaces  = make(map[string]bool)
+	)
+	for _, ic := range inputs {
+		for _, f := range ic.DocumentFormats() {
+			if !seen[f] {
+				seen[f] = true
+				formats = append(formats, f)
+			}
+		}
+		for _, mode := range ic.ColorModes() {
+			switch {
+			case strings.HasPrefix(mode, "BlackAndWhite"):
+				spaces["binary"] = true
+			case strings.HasPrefix(mode, "Grayscale"):
+				spaces["grayscale"] = true
+			case strings.HasPrefix(mode, "RGB"):
+				spaces["color"] = true
+			}
+		}
+	}
+	set("pdl", strings.Join(formats, ","))
+	var cs []string
+	for _, space := range []string{"binary", "grayscale", "color"} {
+		if spaces[space] {
+			cs = append(cs, space)
+		}
+	}
+	set("cs", strings.Join(cs, ","))
+	return text
+}
+
+// AdvertiseConfig configures how Advertise publishes an eSCL server.
+type AdvertiseConfig struct {
+	// Name is the DNS-SD service instance name. The default is the
+	// MakeAndModel of the capabilities.
+	Name string
+
+	// Host is the host name (without the .local domain) under which the
+	// server is reachable. The default is the local host name.
+	Host string
+
+	// Port is the TCP port on which the server serves eSCL requests.
+	Port int
+
+	// Ifaces are the names of the network interfaces on which to advertise
+	// the server. The default is all interfaces.
+	Ifaces []string
+
+	// Text contains TXT record entries which take precedence over those
+	// derived from the capabilities (see TXTRecord), e.g. vendor-specific
+	// entries.
+	Text map[string]string
+}
+
+// An Advertisement is an eSCL server published via DNS-SD.
+type Advertisement struct {
+	canc context.CancelFunc
+	done chan struct{}
+	err  error // valid once done is closed
+}
+
+// Advertise publishes an eSCL server with the specified capabilities as a
+// _uscan._tcp DNS-SD service, so that clients discover it like a physical
+// scanner. The service is advertised until Shutdown is called.
+func Advertise(caps *ScannerCapabilities, cfg AdvertiseConfig) (*Advertisement, error) {
+	text := TXTRecord(caps)
+	for k, v := range cfg.Text {
+		text[k] = v
+	}
+	name := cfg.Name
+	if name == "" {
+		name = caps.MakeAndModel
+	}
+	sv, err := dnssd.NewService(dnssd.Config{
+		Name:   name,
+		Type:   strings.TrimSuffix(ServiceName, ".local."),
+		Host:   cfg.Host,
+		Port:   cfg.Port,
+		Ifaces: cfg.Ifaces,
+		Text:   text,
+	})
+	if err != nil {
+		return nil, err
+	}
+	rp, err := dnssd.NewResponder()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := rp.Add(sv); err != nil {
+		return nil, err
+	}
+	ctx, canc := context.WithCancel(context.Background())
+	a := &Advertisement{
+		canc: canc,
+		done: make(chan struct{}),
+	}
+	go func() {
+		defer close(a.done)
+		a.err = rp.Respond(ctx)
+	}()
+	return a, nil
+}
+
+// Wait blocks until the advertisement stopped, either because Shutdown was
+// called (in which case Wait returns nil) or because the DNS-SD responder
+// failed, e.g. due to a network error.
+func (a *Advertisement) Wait() error {
+	<-a.done
+	if errors.Is(a.err, context.Canceled) {
+		return nil
+	}
+	return a.err
+}
+
+// Shutdown withdraws the advertisement: clients are notified that the service
+// is gone (instead of waiting for their cache to expire). Calling Shutdown more
+// than once is safe.
+func (a *Advertisement) Shutdown() error {
+	a.canc()
+	return a.Wait()
+}
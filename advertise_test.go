@@ -0,0 +1,56 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package airscan_test
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/stapelberg/airscan"
+)
+
+func TestTXTRecord(t *testing.T) {
+	caps := mockCapabilities(t)
+	want := map[string]string{
+		"txtvers":        "1",
+		"ty":             "MF642C/643C/644C",
+		"rs":             "eSCL",
+		"vers":           "2.63",
+		"uuid":           "d11a3092-5fc4-4487-9e35-7a35a1dd72bb",
+		"cs":             "grayscale,color",
+		"is":             "platen,adf",
+		"pdl":            "image/jpeg,application/pdf,application/octet-stream",
+		"duplex":         "F",
+		"adminurl":       "http://Canon0000.local:80/airprint.html",
+		"representation": "http://Canon0000.local/en/media/dev_icon_128x128.png",
+	}
+	if diff := cmp.Diff(want, airscan.TXTRecord(caps)); diff != "" {
+		t.Errorf("unexpected TXT record: diff (-want +got):\n%s", diff)
+	}
+
+	// A platen-only device without optional fields:
+	caps.Adf = nil
+	caps.AdminURI = ""
+	caps.IconURI = ""
+	got := airscan.TXTRecord(caps)
+	for _, k := range []string{"duplex", "adminurl", "representation"} {
+		if v, ok := got[k]; ok {
+			t.Errorf("TXTRecord unexpectedly contains %s=%q", k, v)
+		}
+	}
+	if got, want := got["is"], "platen"; got != want {
+		t.Errorf("unexpected input sources: got %q, want %q", got, want)
+	}
+}
@@ -15,7 +15,9 @@
 package airscan
 
 import (
+	"context"
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -23,8 +25,11 @@ import (
 	"net/http"
 	"net/url"
 	"path"
+	"reflect"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 	"unicode"
 
@@ -35,9 +40,33 @@ import (
 const ServiceName = "_uscan._tcp.local."
 
 type ScannerStatus struct {
-	Version  string `xml:"Version"`
-	State    string `xml:"State"`
-	ADFState string `xml:"AdfState"`
+	Version  string    `xml:"Version"`
+	State    string    `xml:"State"`
+	ADFState string    `xml:"AdfState"`
+	Jobs     []JobInfo `xml:"Jobs>JobInfo"`
+}
+
+// JobInfo describes a scan job which the device knows about, e.g. to find out
+// whether a job was canceled.
+type JobInfo struct {
+	JobURI           string   `xml:"JobUri"`
+	JobUUID          string   `xml:"JobUuid"`
+	Age              int      `xml:"Age"`
+	ImagesCompleted  int      `xml:"ImagesCompleted"`
+	ImagesToTransfer int      `xml:"ImagesToTransfer"`
+	JobState         string   `xml:"JobState"`
+	JobStateReasons  []string `xml:"JobStateReasons>JobStateReason"`
+}
+
+// Job returns the JobInfo for the job with the specified identifier (see
+// ScanState.JobID), or nil if the device does not list the job.
+func (s *ScannerStatus) Job(id string) *JobInfo {
+	for idx, job := range s.Jobs {
+		if path.Base(job.JobURI) == id {
+			return &s.Jobs[idx]
+		}
+	}
+	return nil
 }
 
 // ScanSettings instruct the device how to scan.
@@ -50,13 +79,40 @@ type ScanSettings struct {
 	XmlnsScan      string      `xml:"xmlns:scan,attr"`
 	XmlnsPWG       string      `xml:"xmlns:pwg,attr"`
 	Version        string      `xml:"pwg:Version"`
+	Intent         string      `xml:"scan:Intent,omitempty"`
 	ScanRegions    ScanRegions `xml:"pwg:ScanRegions"`
 	DocumentFormat string      `xml:"pwg:DocumentFormat"`
-	InputSource    string      `xml:"pwg:InputSource"`
-	ColorMode      string      `xml:"scan:ColorMode"`
-	XResolution    int         `xml:"scan:XResolution"`
-	YResolution    int         `xml:"scan:YResolution"`
-	Duplex         bool        `xml:"scan:Duplex"`
+
+	// DocumentFormatExt is the document format for devices which implement
+	// eSCL 2.1 or newer, which might ignore DocumentFormat.
+	DocumentFormatExt string `xml:"scan:DocumentFormatExt,omitempty"`
+
+	ContentType string `xml:"pwg:ContentType,omitempty"`
+	InputSource string `xml:"pwg:InputSource"`
+	ColorMode   string `xml:"scan:ColorMode"`
+	ColorSpace  string `xml:"scan:ColorSpace,omitempty"`
+	CcdChannel  string `xml:"scan:CcdChannel,omitempty"`
+	XResolution int    `xml:"scan:XResolution"`
+	YResolution int    `xml:"scan:YResolution"`
+	Duplex      bool   `xml:"scan:Duplex"`
+
+	// Optional settings, which are only sent to the device when non-nil. See
+	// the corresponding *Support fields of ScannerCapabilities for the ranges
+	// which the device supports.
+	Brightness        *int `xml:"scan:Brightness,omitempty"`
+	CompressionFactor *int `xml:"scan:CompressionFactor,omitempty"`
+	Contrast          *int `xml:"scan:Contrast,omitempty"`
+	Gamma             *int `xml:"scan:Gamma,omitempty"`
+	Highlight         *int `xml:"scan:Highlight,omitempty"`
+	NoiseRemoval      *int `xml:"scan:NoiseRemoval,omitempty"`
+	Shadow            *int `xml:"scan:Shadow,omitempty"`
+	Sharpen           *int `xml:"scan:Sharpen,omitempty"`
+	Threshold         *int `xml:"scan:Threshold,omitempty"`
+
+	BlankPageDetection *bool `xml:"scan:BlankPageDetection,omitempty"`
+
+	// FeedDirection is one of LongEdgeFeed or ShortEdgeFeed (ADF only).
+	FeedDirection string `xml:"scan:FeedDirection,omitempty"`
 }
 
 func (s *ScanSettings) Marshal() (string, error) {
@@ -67,9 +123,110 @@ func (s *ScanSettings) Marshal() (string, error) {
 	return `<?xml version="1.0" encoding="UTF-8" standalone="no"?>` + "\n" + string(b), nil
 }
 
+// UnmarshalScanSettings decodes a ScanSettings document as sent by eSCL
+// clients, which is useful for implementing eSCL servers.
+func UnmarshalScanSettings(b []byte) (*ScanSettings, error) {
+	var s ScanSettings
+	if err := xml.Unmarshal(b, &s); err != nil {
+		return nil, fmt.Errorf("decoding XML: %v", err)
+	}
+	return &s, nil
+}
+
+// prefixedNames maps local names to the prefixed element and attribute names
+// of ScanSettings, e.g. Intent to scan:Intent.
+var prefixedNames = func() map[string]string {
+	names := make(map[string]string)
+	for _, t := range []reflect.Type{
+		reflect.TypeFor[ScanSettings](),
+		reflect.TypeFor[ScanRegions](),
+		reflect.TypeFor[ScanRegion](),
+	} {
+		for i := range t.NumField() {
+			name, _, _ := strings.Cut(t.Field(i).Tag.Get("xml"), ",")
+			if prefix, local, ok := strings.Cut(name, ":"); ok && prefix != "xmlns" {
+				names[local] = name
+			}
+		}
+	}
+	return names
+}()
+
+// UnmarshalXML implements xml.Unmarshaler. encoding/xml matches elements by
+// their local name and namespace, so the prefixed names of ScanSettings
+// (which are required for encoding) never match when decoding. Hence,
+// UnmarshalXML prefixes the names of the document by their local name before
+// decoding, regardless of the namespace prefixes which the client uses.
+func (s *ScanSettings) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	type plain ScanSettings // without the UnmarshalXML method
+	var p plain
+	pr := &prefixer{d: d, start: &start}
+	if err := xml.NewTokenDecoder(pr).Decode(&p); err != nil {
+		return err
+	}
+	*s = ScanSettings(p)
+	// Like in ScanSettings created by programs, leave XMLName empty (the
+	// struct tags determine the names when encoding):
+	s.XMLName = xml.Name{}
+	for _, r := range s.ScanRegions.Regions {
+		r.XMLName = xml.Name{}
+	}
+	s.XmlnsScan = "http://schemas.hp.com/imaging/escl/2011/05/03"
+	s.XmlnsPWG = "http://www.pwg.org/schemas/2010/12/sm"
+	return nil
+}
+
+// prefixer is an xml.TokenReader which returns the element start (and its
+// contents) with prefixed names (see prefixedNames). Namespace declarations
+// are removed.
+type prefixer struct {
+	d     *xml.Decoder
+	start *xml.StartElement // returned first
+	depth int
+}
+
+func prefixed(n xml.Name) xml.Name {
+	if name, ok := prefixedNames[n.Local]; ok {
+		return xml.Name{Local: name}
+	}
+	return xml.Name{Local: n.Local}
+}
+
+func (p *prefixer) Token() (xml.Token, error) {
+	if p.depth == 0 && p.start == nil {
+		return nil, io.EOF // end of the element
+	}
+	var tok xml.Token
+	if p.start != nil {
+		tok, p.start = *p.start, nil
+	} else {
+		var err error
+		if tok, err = p.d.Token(); err != nil {
+			return nil, err
+		}
+	}
+	switch t := xml.CopyToken(tok).(type) {
+	case xml.StartElement:
+		p.depth++
+		attrs := t.Attr[:0]
+		for _, a := range t.Attr {
+			if a.Name.Space == "xmlns" || a.Name.Local == "xmlns" {
+				continue
+			}
+			attrs = append(attrs, xml.Attr{Name: prefixed(a.Name), Value: a.Value})
+		}
+		return xml.StartElement{Name: prefixed(t.Name), Attr: attrs}, nil
+	case xml.EndElement:
+		p.depth--
+		return xml.EndElement{Name: prefixed(t.Name)}, nil
+	default:
+		return t, nil
+	}
+}
+
 type ScanRegions struct {
-	MustHonor bool `xml:"pwg:MustHonor,attr"`
-	Regions   []*ScanRegion
+	MustHonor bool          `xml:"pwg:MustHonor,attr"`
+	Regions   []*ScanRegion `xml:"pwg:ScanRegion"`
 }
 
 type ScanRegion struct {
@@ -92,6 +249,10 @@ type Client struct {
 
 	host  string
 	debug bool
+
+	// jobs are the scan jobs in progress, see Cancel.
+	jobsMu sync.Mutex
+	jobs   map[*ScanState]bool
 }
 
 func isPrintable(s string) bool {
@@ -104,6 +265,37 @@ func isPrintable(s string) bool {
 	return true
 }
 
+// A StatusError is returned when the device responds with an unexpected HTTP
+// status code.
+type StatusError struct {
+	// URL is the URL of the request.
+	URL string
+
+	// StatusCode is the HTTP status code the device responded with, e.g. 409.
+	StatusCode int
+
+	// Status is the HTTP status line, e.g. "409 Conflict".
+	Status string
+
+	// Message is the (printable) response body, if any.
+	Message string
+
+	// Want contains the expected HTTP status codes.
+	Want []int
+}
+
+func (e *StatusError) Error() string {
+	want := fmt.Sprintf("one of %v", e.Want)
+	if len(e.Want) == 1 {
+		want = fmt.Sprint(e.Want[0])
+	}
+	return fmt.Sprintf("%v: unexpected HTTP status: got %v (%s), want %v",
+		e.URL,
+		e.Status,
+		e.Message,
+		want)
+}
+
 // do wraps c.Client.Do, but tries to report a descriptive error, including a
 // server-sent error message, if any (and printable!).
 func (c *Client) do(req *http.Request, okayStatuses ...int) (resp *http.Response, err error) {
@@ -131,27 +323,32 @@ func (c *Client) do(req *http.Request, okayStatuses ...int) (resp *http.Response
 			return resp, nil
 		}
 	}
-	want := fmt.Sprintf("one of %v", okayStatuses)
-	if len(okayStatuses) == 1 {
-		want = fmt.Sprint(okayStatuses[0])
-	}
 	b, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
 	message := strings.TrimSpace(string(b))
 	if !isPrintable(message) {
 		message = "<non-printable body>"
 	}
-	return nil, fmt.Errorf("%v: unexpected HTTP status: got %v (%s), want %v",
-		req.URL,
-		resp.Status,
-		message,
-		want)
+	return nil, &StatusError{
+		URL:        req.URL.String(),
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+		Message:    message,
+		Want:       okayStatuses,
+	}
 }
 
 // ScannerStatus queries the device for its status. This can be used for example
 // to find out whether a document has been inserted into the Automatic Document
 // Feeder (ADF). The Scan method verifies this, too.
 func (c *Client) ScannerStatus() (*ScannerStatus, error) {
-	req, err := http.NewRequest("GET", c.getEndpoint("/eSCL/ScannerStatus"), nil)
+	return c.ScannerStatusContext(context.Background())
+}
+
+// ScannerStatusContext is like ScannerStatus, but the request is aborted once
+// ctx is done, e.g. when the device does not respond.
+func (c *Client) ScannerStatusContext(ctx context.Context) (*ScannerStatus, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.getEndpoint("/eSCL/ScannerStatus"), nil)
 	if err != nil {
 		return nil, err
 	}
@@ -164,6 +361,7 @@ func (c *Client) ScannerStatus() (*ScannerStatus, error) {
 	if err != nil {
 		return nil, err
 	}
+	defer resp.Body.Close()
 	b, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return nil, err
@@ -175,8 +373,40 @@ func (c *Client) ScannerStatus() (*ScannerStatus, error) {
 	return &status, nil
 }
 
-func (c *Client) ScannerCapabilities() (*scannerCapabilities, error) {
-	req, err := http.NewRequest("GET", c.getEndpoint("/eSCL/ScannerCapabilities"), nil)
+// WaitIdle polls the device’s status until it is Idle, e.g. because another
+// client’s scan job is still in progress, or until ctx is done. Use
+// context.WithTimeout to limit how long to wait.
+func (c *Client) WaitIdle(ctx context.Context) (*ScannerStatus, error) {
+	for {
+		status, err := c.ScannerStatusContext(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if status.State == "Idle" {
+			return status, nil
+		}
+		if c.debug {
+			log.Printf("scanner in state %q, waiting for Idle", status.State)
+		}
+		select {
+		case <-ctx.Done():
+			return status, fmt.Errorf("scanner not ready: in state %q, want %q: %v", status.State, "Idle", ctx.Err())
+		case <-time.After(1 * time.Second):
+		}
+	}
+}
+
+// ScannerCapabilitiesXML returns the device’s capabilities document as-is. This
+// is useful for bug reports, as the ScannerCapabilities struct only contains the
+// fields which package airscan understands.
+func (c *Client) ScannerCapabilitiesXML() ([]byte, error) {
+	return c.ScannerCapabilitiesXMLContext(context.Background())
+}
+
+// ScannerCapabilitiesXMLContext is like ScannerCapabilitiesXML, but the request
+// is aborted once ctx is done.
+func (c *Client) ScannerCapabilitiesXMLContext(ctx context.Context) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.getEndpoint("/eSCL/ScannerCapabilities"), nil)
 	if err != nil {
 		return nil, err
 	}
@@ -184,19 +414,32 @@ func (c *Client) ScannerCapabilities() (*scannerCapabilities, error) {
 	if err != nil {
 		return nil, err
 	}
-	b, err := io.ReadAll(resp.Body)
+	defer resp.Body.Close()
+	return io.ReadAll(resp.Body)
+}
+
+// ScannerCapabilities queries the device for its capabilities, e.g. which
+// input sources, color modes and resolutions it supports.
+func (c *Client) ScannerCapabilities() (*ScannerCapabilities, error) {
+	return c.ScannerCapabilitiesContext(context.Background())
+}
+
+// ScannerCapabilitiesContext is like ScannerCapabilities, but the request is
+// aborted once ctx is done.
+func (c *Client) ScannerCapabilitiesContext(ctx context.Context) (*ScannerCapabilities, error) {
+	b, err := c.ScannerCapabilitiesXMLContext(ctx)
 	if err != nil {
 		return nil, err
 	}
-	var capabilities scannerCapabilities
+	var capabilities ScannerCapabilities
 	if err := xml.Unmarshal(b, &capabilities); err != nil {
 		return nil, fmt.Errorf("decoding XML: %v (invalid input? %q)", err, string(b))
 	}
 	return &capabilities, nil
 }
 
-func (c *Client) createScanJob(settings string) (*url.URL, error) {
-	req, err := http.NewRequest("POST", c.getEndpoint("/eSCL/ScanJobs"), strings.NewReader(settings))
+func (c *Client) createScanJob(ctx context.Context, settings string) (*url.URL, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", c.getEndpoint("/eSCL/ScanJobs"), strings.NewReader(settings))
 	if err != nil {
 		return nil, err
 	}
@@ -204,6 +447,7 @@ func (c *Client) createScanJob(settings string) (*url.URL, error) {
 	if err != nil {
 		return nil, err
 	}
+	drainAndClose(resp.Body)
 	loc, err := resp.Location()
 	if err != nil {
 		return nil, err
@@ -212,23 +456,59 @@ func (c *Client) createScanJob(settings string) (*url.URL, error) {
 	return loc, nil
 }
 
-func (c *Client) deleteScanJob(loc *url.URL) error {
-	req, err := http.NewRequest("DELETE", loc.String(), nil)
+func (c *Client) deleteScanJob(ctx context.Context, loc *url.URL) error {
+	req, err := http.NewRequestWithContext(ctx, "DELETE", loc.String(), nil)
 	if err != nil {
 		return err
 	}
-	if _, err := c.do(req, http.StatusNotFound); err != nil {
+	// Devices differ in how they acknowledge the DELETE request: most respond
+	// with 200 or 204, but some respond with 404 once the job is gone.
+	resp, err := c.do(req, http.StatusOK, http.StatusNoContent, http.StatusNotFound)
+	if err != nil {
 		return err
 	}
+	drainAndClose(resp.Body)
 	return nil
 }
 
+// drainAndClose reads the remainder of body (up to a limit) before closing it,
+// so that the underlying connection can be re-used for subsequent requests.
+// Some devices only accept a small number of concurrent connections.
+func drainAndClose(body io.ReadCloser) {
+	io.Copy(io.Discard, io.LimitReader(body, 1<<20))
+	body.Close()
+}
+
 // ScanState represents an in-progress scan job.
 type ScanState struct {
+	ctx     context.Context
 	loc     *url.URL
 	scanner *Client
 	reader  io.Reader
 	err     error
+
+	// body is the response body of the current page, which is closed when
+	// the next page is requested or the ScanState is closed.
+	body io.ReadCloser
+
+	// crop is set when the device does not support the requested number of
+	// scan regions, see ScanEachRegion.
+	crop *cropper
+
+	// canceled is set by Cancel, which might be called from a different
+	// goroutine than ScanPage.
+	canceled atomic.Bool
+
+	// finished is set once the device reported that all pages were
+	// received, at which point the device has already removed the job.
+	finished bool
+
+	// closed is set by Close, which must only delete the job once.
+	closed bool
+
+	// page is the number of documents received so far.
+	page     int
+	progress func(Progress)
 }
 
 // ScanPage requests the next page of this scan job. It returns true if a new
@@ -242,14 +522,34 @@ func (s *ScanState) ScanPage() bool {
 	if s.err != nil {
 		return false // avoid clobbering existing errors
 	}
+	s.closeBody()
+	if s.canceled.Load() {
+		return false
+	}
+	var ok bool
+	if s.crop != nil {
+		ok = s.crop.scanPage(s)
+	} else {
+		ok = s.nextDocument()
+	}
+	if !ok {
+		// The scan job is done (or failed), so Client.Cancel need not
+		// cancel it, even if Close is never called:
+		s.scanner.untrack(s)
+	}
+	return ok
+}
 
+// nextDocument requests the next document from the device and makes it
+// available via s.reader.
+func (s *ScanState) nextDocument() bool {
 	u, err := url.Parse(s.loc.String())
 	if err != nil {
 		s.err = err
 		return false
 	}
 	u.Path = path.Join(u.Path, "NextDocument")
-	req, err := http.NewRequest("GET", u.String(), nil)
+	req, err := http.NewRequestWithContext(s.ctx, "GET", u.String(), nil)
 	if err != nil {
 		s.err = err
 		return false
@@ -258,20 +558,35 @@ func (s *ScanState) ScanPage() bool {
 	for try := 0; try < tries; try++ {
 		resp, err := s.scanner.do(req, http.StatusOK, http.StatusNotFound, http.StatusServiceUnavailable)
 		if resp != nil {
+			if resp.StatusCode != http.StatusOK {
+				drainAndClose(resp.Body)
+			}
 			switch resp.StatusCode {
 			case http.StatusNotFound:
 				if s.scanner.debug {
 					log.Printf("NotFound: all pages received")
 				}
+				s.finished = true
+				s.scanner.untrack(s)
 				return false // all pages received, no error
 			case http.StatusServiceUnavailable:
 				if s.scanner.debug {
 					log.Printf("ServiceUnavailable: will retry (try %d/%d)", try+1, tries)
 				}
-				time.Sleep(1 * time.Second)
+				select {
+				case <-s.ctx.Done():
+					s.err = s.ctx.Err()
+					return false
+				case <-time.After(1 * time.Second):
+				}
 				continue
 			default:
+				s.page++
+				s.body = resp.Body
 				s.reader = resp.Body
+				if s.progress != nil {
+					s.reader = newProgressReader(resp.Body, s.progress, s.page, resp.ContentLength)
+				}
 				return true
 			}
 		}
@@ -284,10 +599,21 @@ func (s *ScanState) ScanPage() bool {
 	return false
 }
 
+// closeBody closes the response body of the current page, if any.
+func (s *ScanState) closeBody() {
+	if s.body == nil {
+		return
+	}
+	drainAndClose(s.body)
+	s.body = nil
+	s.reader = nil
+}
+
 // CurrentPage returns an io.Reader containing the scan data.
 //
 // CurrentPage must only be called after ScanPage() returned true, and will
-// return nil otherwise.
+// return nil otherwise. The returned io.Reader is only valid until the next
+// call to ScanPage or Close.
 //
 // Note: package airscan never interprets scan data, the package only provides
 // the data as-is. If you want to decode scan data, you will need to import
@@ -296,23 +622,75 @@ func (s *ScanState) CurrentPage() io.Reader {
 	return s.reader
 }
 
+// JobID returns the identifier which the device assigned to this scan job,
+// i.e. the last path element of the job URI.
+func (s *ScanState) JobID() string {
+	return path.Base(s.loc.Path)
+}
+
 // Err returns the first error that occurred. If it returns non-nil, the
 // ScanState must no longer be used, except for the Close method.
 func (s *ScanState) Err() error {
 	return s.err
 }
 
-// Close deletes the scan job on the device.
+// Close deletes the scan job on the device, unless all pages were received (in
+// which case the device already removed the job) or the job was canceled.
+// Calling Close more than once is safe: only the first call has an effect.
 //
 // Some devices work just fine if you never call Close. To maximize
 // compatibility, it is recommended to call Close. This mirrors what Apple’s
 // scan program does, which might be required for certain scanners (speculation
 // only).
 func (s *ScanState) Close() error {
+	s.closeBody()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	s.scanner.untrack(s)
+	if s.finished || s.canceled.Load() {
+		return nil
+	}
 	if s.scanner.debug {
 		log.Printf("Deleting ScanJob %s", s.loc)
 	}
-	return s.scanner.deleteScanJob(s.loc)
+	return s.scanner.deleteScanJob(context.Background(), s.loc)
+}
+
+// Cancel aborts the scan job on the device, e.g. when the user interrupts a
+// multi-page Automatic Document Feeder (ADF) scan. Cancel deletes the scan job
+// and waits until the device reports the job as canceled or has returned to the
+// Idle state, so that the device can be used for the next scan job right away.
+//
+// Cancel may be called from a different goroutine than ScanPage. Once Cancel
+// was called, ScanPage returns false.
+func (s *ScanState) Cancel(ctx context.Context) error {
+	s.canceled.Store(true)
+	s.scanner.untrack(s)
+	if s.scanner.debug {
+		log.Printf("Canceling ScanJob %s", s.loc)
+	}
+	if err := s.scanner.deleteScanJob(ctx, s.loc); err != nil {
+		return err
+	}
+	for {
+		status, err := s.scanner.ScannerStatusContext(ctx)
+		if err != nil {
+			return err
+		}
+		if status.State == "Idle" {
+			return nil
+		}
+		if job := status.Job(s.JobID()); job != nil && job.JobState == "Canceled" {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
 }
 
 // Scan starts a new scan job using the specified settings.
@@ -321,13 +699,19 @@ func (s *ScanState) Close() error {
 // verifies a document is inserted before creating a scan job (which would
 // otherwise fail with a less clear error message).
 func (c *Client) Scan(settings *ScanSettings) (*ScanState, error) {
+	return c.scan(context.Background(), settings)
+}
+
+// scan is like Scan, but all requests of the scan job (including those made by
+// ScanPage) use ctx.
+func (c *Client) scan(ctx context.Context, settings *ScanSettings) (*ScanState, error) {
 	// Ensure settings are valid before doing anything else:
 	s, err := settings.Marshal()
 	if err != nil {
 		return nil, err
 	}
 
-	status, err := c.ScannerStatus()
+	status, err := c.ScannerStatusContext(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -344,7 +728,7 @@ func (c *Client) Scan(settings *ScanSettings) (*ScanState, error) {
 	}
 
 	// Check capabilities
-	caps, err := c.ScannerCapabilities()
+	caps, err := c.ScannerCapabilitiesContext(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -363,7 +747,7 @@ func (c *Client) Scan(settings *ScanSettings) (*ScanState, error) {
 		log.Printf("capabilities: %+v", caps)
 	}
 
-	loc, err := c.createScanJob(s)
+	loc, err := c.createScanJob(ctx, s)
 	if err != nil {
 		return nil, err
 	}
@@ -371,10 +755,51 @@ func (c *Client) Scan(settings *ScanSettings) (*ScanState, error) {
 		log.Printf("ScanJob created: %s", loc)
 	}
 
-	return &ScanState{
+	state := &ScanState{
+		ctx:     ctx,
 		loc:     loc,
 		scanner: c,
-	}, nil
+	}
+	c.track(state)
+	return state, nil
+}
+
+// track registers the scan job for Client.Cancel. Scan jobs are untracked once
+// ScanPage returns false, or once they are canceled or closed, i.e. only scan
+// jobs which are abandoned halfway without calling Close remain tracked.
+func (c *Client) track(s *ScanState) {
+	c.jobsMu.Lock()
+	defer c.jobsMu.Unlock()
+	if c.jobs == nil {
+		c.jobs = make(map[*ScanState]bool)
+	}
+	c.jobs[s] = true
+}
+
+func (c *Client) untrack(s *ScanState) {
+	c.jobsMu.Lock()
+	defer c.jobsMu.Unlock()
+	delete(c.jobs, s)
+}
+
+// Cancel aborts all scan jobs of this Client which are in progress (see
+// ScanState.Cancel), e.g. a scan job started by ScanPages in a different
+// goroutine. Iterations over the pages of canceled scan jobs end without an
+// error. Cancel returns nil if no scan job is in progress.
+func (c *Client) Cancel(ctx context.Context) error {
+	c.jobsMu.Lock()
+	jobs := make([]*ScanState, 0, len(c.jobs))
+	for s := range c.jobs {
+		jobs = append(jobs, s)
+	}
+	c.jobsMu.Unlock()
+	var errs []error
+	for _, s := range jobs {
+		if err := s.Cancel(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
 }
 
 func (c *Client) getEndpoint(s string) string {
@@ -15,14 +15,19 @@
 package airscan_test
 
 import (
+	"bytes"
+	"context"
 	crypto_rand "crypto/rand"
+	"errors"
 	"fmt"
 	"io"
+	"iter"
 	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path"
+	"reflect"
 	"strings"
 	"sync"
 	"testing"
@@ -45,17 +50,50 @@ func openEsclMockFile(t *testing.T, name string) io.ReadCloser {
 	return f
 }
 
-func mockScanner(t *testing.T) http.Handler {
-	mux := http.NewServeMux()
+// mockScannerConfig customizes the behavior of mockScannerWith.
+type mockScannerConfig struct {
+	// page is the scan data returned for each page.
+	page []byte
 
-	mux.HandleFunc("/eSCL/ScannerStatus", func(w http.ResponseWriter, r *http.Request) {
-		io.Copy(w, openEsclMockFile(t, "ScannerStatus.xml"))
-	})
+	// pages is the number of pages per scan job.
+	pages int
 
-	mux.HandleFunc("/eSCL/ScannerCapabilities", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		io.Copy(w, openEsclMockFile(t, "ScannerCapabilities.xml"))
+	// settings, if non-nil, is called with each ScanSettings request.
+	settings func(string)
+
+	// status and capabilities, if non-nil, modify the ScannerStatus and
+	// ScannerCapabilities XML, respectively.
+	status       func(string) string
+	capabilities func(string) string
+}
+
+func mockScanner(t *testing.T) http.Handler {
+	return mockScannerWith(t, mockScannerConfig{
+		page:  binaryScanDataStandIn,
+		pages: 2,
 	})
+}
+
+func mockScannerWith(t *testing.T, cfg mockScannerConfig) http.Handler {
+	mux := http.NewServeMux()
+
+	// serveMockFile serves the named file, modified by fn (if non-nil):
+	serveMockFile := func(name string, fn func(string) string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if fn == nil {
+				io.Copy(w, openEsclMockFile(t, name))
+				return
+			}
+			b, err := io.ReadAll(openEsclMockFile(t, name))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			io.WriteString(w, fn(string(b)))
+		}
+	}
+	mux.HandleFunc("/eSCL/ScannerStatus", serveMockFile("ScannerStatus.xml", cfg.status))
+	mux.HandleFunc("/eSCL/ScannerCapabilities", serveMockFile("ScannerCapabilities.xml", cfg.capabilities))
 
 	var (
 		scansMu sync.Mutex
@@ -67,12 +105,20 @@ func mockScanner(t *testing.T) http.Handler {
 			http.Error(w, "bad method", http.StatusBadRequest)
 			return
 		}
+		if cfg.settings != nil {
+			b, err := io.ReadAll(r.Body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			cfg.settings(string(b))
+		}
 		scansMu.Lock()
 		defer scansMu.Unlock()
 		random := make([]byte, 16)
 		crypto_rand.Read(random)
 		key := fmt.Sprintf("%x", random)
-		scans[key] = 2
+		scans[key] = cfg.pages
 		// We intentionally return a never-working URL (port 9 is the discard
 		// protocol) so that we verify the package doesn’t accidentally take the
 		// scanner-provided host (if any): these might be buggy, so let’s stick
@@ -116,7 +162,7 @@ func mockScanner(t *testing.T) http.Handler {
 			http.Error(w, "no such job", http.StatusNotFound)
 			return
 		}
-		w.Write(binaryScanDataStandIn)
+		w.Write(cfg.page)
 	})
 
 	return mux
@@ -124,7 +170,12 @@ func mockScanner(t *testing.T) http.Handler {
 
 func clientForMockScanner(t *testing.T) *airscan.Client {
 	t.Helper()
-	srv := httptest.NewServer(mockScanner(t))
+	return clientFor(t, mockScanner(t))
+}
+
+func clientFor(t *testing.T, h http.Handler) *airscan.Client {
+	t.Helper()
+	srv := httptest.NewServer(h)
 	t.Cleanup(func() { srv.Close() })
 	// round-trip the listener address through net.SplitHostPort and
 	// net.JoinHostPort to verify that it is indeed a host:port address:
@@ -153,6 +204,167 @@ func TestScannerStatus(t *testing.T) {
 	}
 }
 
+func TestScannerCapabilities(t *testing.T) {
+	cl := clientForMockScanner(t)
+	caps, err := cl.ScannerCapabilities()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := caps.MakeAndModel, "MF642C/643C/644C"; got != want {
+		t.Errorf("unexpected MakeAndModel: got %q, want %q", got, want)
+	}
+	if caps.Platen == nil {
+		t.Fatalf("Platen unexpectedly nil")
+	}
+	profiles := caps.Platen.PlatenInputCaps.SettingProfiles.SettingProfile
+	if got, want := len(profiles), 1; got != want {
+		t.Fatalf("unexpected number of setting profiles: got %d, want %d", got, want)
+	}
+	if diff := cmp.Diff([]string{"Grayscale8", "RGB24"}, profiles[0].ColorModes.ColorMode); diff != "" {
+		t.Errorf("unexpected color modes: diff (-want +got):\n%s", diff)
+	}
+	if got, want := len(profiles[0].SupportedResolutions.DiscreteResolutions.DiscreteResolution), 1; got != want {
+		t.Errorf("unexpected number of resolutions: got %d, want %d", got, want)
+	}
+	if caps.Adf == nil || caps.Adf.AdfSimplexInputCaps == nil {
+		t.Fatalf("Adf unexpectedly nil")
+	}
+	if got, want := caps.Adf.FeederCapacity, 100; got != want {
+		t.Errorf("unexpected FeederCapacity: got %d, want %d", got, want)
+	}
+
+	b, err := cl.ScannerCapabilitiesXML()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), "<scan:ScannerCapabilities") {
+		t.Errorf("ScannerCapabilitiesXML() does not contain ScannerCapabilities element")
+	}
+}
+
+func TestScanSettingsOptional(t *testing.T) {
+	settings := preset.GrayscaleA4ADF()
+	sharpen := 4
+	blankPageDetection := true
+	settings.Intent = "Document"
+	settings.DocumentFormatExt = "application/pdf"
+	settings.ContentType = "Text"
+	settings.Sharpen = &sharpen
+	settings.BlankPageDetection = &blankPageDetection
+	settings.FeedDirection = "ShortEdgeFeed"
+	got, err := settings.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"<scan:Intent>Document</scan:Intent>",
+		"<scan:DocumentFormatExt>application/pdf</scan:DocumentFormatExt>",
+		"<pwg:ContentType>Text</pwg:ContentType>",
+		"<scan:Sharpen>4</scan:Sharpen>",
+		"<scan:BlankPageDetection>true</scan:BlankPageDetection>",
+		"<scan:FeedDirection>ShortEdgeFeed</scan:FeedDirection>",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("ScanSettings request does not contain %q:\n%s", want, got)
+		}
+	}
+	for _, unwanted := range []string{"Brightness", "Threshold", "ColorSpace"} {
+		if strings.Contains(got, unwanted) {
+			t.Errorf("ScanSettings request unexpectedly contains unset %q:\n%s", unwanted, got)
+		}
+	}
+}
+
+func TestUnmarshalScanSettings(t *testing.T) {
+	want := preset.GrayscaleA4ADF()
+	num := func(v int) *int { return &v }
+	yes := true
+	want.Intent = "Document"
+	want.DocumentFormatExt = "image/jpeg"
+	want.ContentType = "Text"
+	want.ColorSpace = "YCC"
+	want.CcdChannel = "NTSC"
+	want.Brightness = num(1)
+	want.CompressionFactor = num(2)
+	want.Contrast = num(3)
+	want.Gamma = num(4)
+	want.Highlight = num(5)
+	want.NoiseRemoval = num(6)
+	want.Shadow = num(7)
+	want.Sharpen = num(8)
+	want.Threshold = num(9)
+	want.BlankPageDetection = &yes
+	want.FeedDirection = "LongEdgeFeed"
+	// Ensure the test covers fields which are added later:
+	v := reflect.ValueOf(want).Elem()
+	for i := range v.NumField() {
+		if name := v.Type().Field(i).Name; name != "XMLName" && v.Field(i).IsZero() {
+			t.Fatalf("BUG: field %s not set in test", name)
+		}
+	}
+
+	b, err := want.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := airscan.UnmarshalScanSettings([]byte(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("UnmarshalScanSettings(Marshal()): diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestUnmarshalScanSettingsPrefixes(t *testing.T) {
+	// Clients might use different namespace prefixes, or none at all:
+	const b = `<?xml version="1.0" encoding="UTF-8"?>
+<ScanSettings xmlns="http://schemas.hp.com/imaging/escl/2011/05/03" xmlns:p="http://www.pwg.org/schemas/2010/12/sm">
+  <p:Version>2.63</p:Version>
+  <p:ScanRegions p:MustHonor="true">
+    <p:ScanRegion>
+      <p:ContentRegionUnits>escl:ThreeHundredthsOfInches</p:ContentRegionUnits>
+      <p:Width>2550</p:Width>
+      <p:Height>3300</p:Height>
+      <p:XOffset>0</p:XOffset>
+      <p:YOffset>0</p:YOffset>
+    </p:ScanRegion>
+  </p:ScanRegions>
+  <p:InputSource>Feeder</p:InputSource>
+  <ColorMode>RGB24</ColorMode>
+  <XResolution>300</XResolution>
+  <YResolution>300</YResolution>
+  <Duplex>true</Duplex>
+</ScanSettings>`
+	got, err := airscan.UnmarshalScanSettings([]byte(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &airscan.ScanSettings{
+		XmlnsScan: "http://schemas.hp.com/imaging/escl/2011/05/03",
+		XmlnsPWG:  "http://www.pwg.org/schemas/2010/12/sm",
+		Version:   "2.63",
+		ScanRegions: airscan.ScanRegions{
+			MustHonor: true,
+			Regions: []*airscan.ScanRegion{
+				{
+					ContentRegionUnits: airscan.ThreeHundredthsOfInches,
+					Width:              2550,
+					Height:             3300,
+				},
+			},
+		},
+		InputSource: "Feeder",
+		ColorMode:   "RGB24",
+		XResolution: 300,
+		YResolution: 300,
+		Duplex:      true,
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("UnmarshalScanSettings: diff (-want +got):\n%s", diff)
+	}
+}
+
 func TestScan(t *testing.T) {
 	cl := clientForMockScanner(t)
 	grayscaleA4Platen := preset.GrayscaleA4ADF()
@@ -183,6 +395,453 @@ func TestScan(t *testing.T) {
 	}
 }
 
+func TestPages(t *testing.T) {
+	cl := clientForMockScanner(t)
+	grayscaleA4Platen := preset.GrayscaleA4ADF()
+	grayscaleA4Platen.InputSource = "Platen"
+	job, err := cl.Scan(grayscaleA4Platen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var numbers []int
+	for page, err := range job.Pages(context.Background()) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(binaryScanDataStandIn, page.Data); diff != "" {
+			t.Fatalf("unexpected scan data: diff (-want +got):\n%s", diff)
+		}
+		numbers = append(numbers, page.Number)
+	}
+	if diff := cmp.Diff([]int{1, 2}, numbers); diff != "" {
+		t.Fatalf("unexpected page numbers: diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestPagesBreak(t *testing.T) {
+	cl := clientForMockScanner(t)
+	grayscaleA4Platen := preset.GrayscaleA4ADF()
+	grayscaleA4Platen.InputSource = "Platen"
+	job, err := cl.Scan(grayscaleA4Platen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pages := 0
+	for _, err := range job.Pages(context.Background()) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages++
+		break
+	}
+	if got, want := pages, 1; got != want {
+		t.Fatalf("unexpected number of pages: got %d, want %d", got, want)
+	}
+}
+
+func TestPagesCloseError(t *testing.T) {
+	scanner := mockScannerWith(t, mockScannerConfig{
+		page:  binaryScanDataStandIn,
+		pages: 5,
+	})
+	cl := clientFor(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "DELETE" {
+			http.Error(w, "busy", http.StatusInternalServerError)
+			return
+		}
+		scanner.ServeHTTP(w, r)
+	}))
+	grayscaleA4Platen := preset.GrayscaleA4ADF()
+	grayscaleA4Platen.InputSource = "Platen"
+	job, err := cl.Scan(grayscaleA4Platen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, err := range job.Pages(context.Background()) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		break
+	}
+	// The loop was exited early, so the scan job could not be deleted:
+	if err := job.Err(); err == nil {
+		t.Fatalf("Err unexpectedly returned nil after failing to delete the scan job")
+	}
+}
+
+func TestScanPages(t *testing.T) {
+	cl := clientForMockScanner(t)
+	grayscaleA4Platen := preset.GrayscaleA4ADF()
+	grayscaleA4Platen.InputSource = "Platen"
+	var scanner airscan.Scanner = cl
+	var numbers []int
+	for page, err := range scanner.ScanPages(context.Background(), grayscaleA4Platen) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		numbers = append(numbers, page.Number)
+	}
+	if diff := cmp.Diff([]int{1, 2}, numbers); diff != "" {
+		t.Fatalf("unexpected page numbers: diff (-want +got):\n%s", diff)
+	}
+
+	// Errors creating the scan job are yielded:
+	invalid := preset.GrayscaleA4ADF()
+	invalid.InputSource = "Feeder"
+	invalid.Duplex = true
+	var errs int
+	for _, err := range scanner.ScanPages(context.Background(), invalid) {
+		if err == nil {
+			t.Fatalf("ScanPages unexpectedly yielded a page")
+		}
+		errs++
+	}
+	if got, want := errs, 1; got != want {
+		t.Fatalf("unexpected number of errors: got %d, want %d", got, want)
+	}
+}
+
+func TestConnectionReuse(t *testing.T) {
+	const pages = 5
+	srv := httptest.NewUnstartedServer(mockScannerWith(t, mockScannerConfig{
+		page:  bytes.Repeat(binaryScanDataStandIn, 64*1024),
+		pages: pages,
+	}))
+	var (
+		connsMu sync.Mutex
+		conns   int
+	)
+	srv.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			connsMu.Lock()
+			defer connsMu.Unlock()
+			conns++
+		}
+	}
+	srv.Start()
+	t.Cleanup(func() { srv.Close() })
+	cl := airscan.NewClient(srv.Listener.Addr().String())
+	cl.HTTPClient = srv.Client()
+
+	grayscaleA4Platen := preset.GrayscaleA4ADF()
+	grayscaleA4Platen.InputSource = "Platen"
+	job, err := cl.Scan(grayscaleA4Platen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scanned := 0
+	for job.ScanPage() {
+		// Only read part of the page, leaving the remainder to ScanState:
+		if _, err := job.CurrentPage().Read(make([]byte, 1)); err != nil {
+			t.Fatal(err)
+		}
+		scanned++
+	}
+	if err := job.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if err := job.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := scanned, pages; got != want {
+		t.Fatalf("unexpected number of pages: got %d, want %d", got, want)
+	}
+	connsMu.Lock()
+	defer connsMu.Unlock()
+	if got, want := conns, 1; got != want {
+		t.Errorf("unexpected number of connections: got %d, want %d", got, want)
+	}
+}
+
+func TestCancel(t *testing.T) {
+	scanner := mockScannerWith(t, mockScannerConfig{
+		page:  binaryScanDataStandIn,
+		pages: 5,
+	})
+	var (
+		mu      sync.Mutex
+		deletes int
+		polls   int
+		jobURI  string
+	)
+	cl := clientFor(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case r.Method == "DELETE":
+			deletes++
+			jobURI = r.URL.Path
+			w.WriteHeader(http.StatusNoContent)
+
+		case r.URL.Path == "/eSCL/ScannerStatus" && deletes > 0:
+			// The device takes a moment to cancel the job:
+			polls++
+			jobState := "Processing"
+			if polls > 1 {
+				jobState = "Canceled"
+			}
+			fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?>
+<scan:ScannerStatus xmlns:pwg="http://www.pwg.org/schemas/2010/12/sm" xmlns:scan="http://schemas.hp.com/imaging/escl/2011/05/03">
+  <pwg:Version>2.63</pwg:Version>
+  <pwg:State>Processing</pwg:State>
+  <scan:Jobs>
+    <scan:JobInfo>
+      <pwg:JobUri>%s</pwg:JobUri>
+      <pwg:JobState>%s</pwg:JobState>
+      <pwg:JobStateReasons>
+        <pwg:JobStateReason>JobCanceledByUser</pwg:JobStateReason>
+      </pwg:JobStateReasons>
+    </scan:JobInfo>
+  </scan:Jobs>
+</scan:ScannerStatus>`, jobURI, jobState)
+
+		default:
+			scanner.ServeHTTP(w, r)
+		}
+	}))
+
+	grayscaleA4Platen := preset.GrayscaleA4ADF()
+	grayscaleA4Platen.InputSource = "Platen"
+	job, err := cl.Scan(grayscaleA4Platen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !job.ScanPage() {
+		t.Fatalf("ScanPage: %v", job.Err())
+	}
+	if err := job.Cancel(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if job.ScanPage() {
+		t.Errorf("ScanPage unexpectedly returned true after Cancel")
+	}
+	if err := job.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if err := job.Close(); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if got, want := deletes, 1; got != want {
+		t.Errorf("unexpected number of DELETE requests: got %d, want %d", got, want)
+	}
+	if got, want := polls, 2; got != want {
+		t.Errorf("unexpected number of ScannerStatus polls: got %d, want %d", got, want)
+	}
+}
+
+func TestClientCancel(t *testing.T) {
+	scanner := mockScannerWith(t, mockScannerConfig{
+		page:  binaryScanDataStandIn,
+		pages: 5,
+	})
+	var (
+		mu      sync.Mutex
+		deletes int
+	)
+	cl := clientFor(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "DELETE" {
+			mu.Lock()
+			deletes++
+			mu.Unlock()
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		scanner.ServeHTTP(w, r)
+	}))
+
+	// Without a scan job in progress, Cancel is a no-op:
+	if err := cl.Cancel(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	grayscaleA4Platen := preset.GrayscaleA4ADF()
+	grayscaleA4Platen.InputSource = "Platen"
+	pages := 0
+	for _, err := range cl.ScanPages(context.Background(), grayscaleA4Platen) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages++
+		if err := cl.Cancel(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, want := pages, 1; got != want {
+		t.Errorf("unexpected number of pages: got %d, want %d", got, want)
+	}
+
+	// Scan jobs which ran to completion are not canceled, even if they were
+	// never closed:
+	job, err := cl.Scan(grayscaleA4Platen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for job.ScanPage() {
+	}
+	if err := job.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if err := cl.Cancel(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if got, want := deletes, 1; got != want {
+		t.Errorf("unexpected number of DELETE requests: got %d, want %d", got, want)
+	}
+}
+
+func TestValidating(t *testing.T) {
+	var scanned bool
+	scanner := airscan.Validating(&airscan.ScannerFuncs{
+		CapabilitiesFunc: clientForMockScanner(t).ScannerCapabilitiesContext,
+		ScanPagesFunc: func(ctx context.Context, settings *airscan.ScanSettings) iter.Seq2[*airscan.Page, error] {
+			return func(yield func(*airscan.Page, error) bool) {
+				scanned = true
+			}
+		},
+	})
+	settings := preset.GrayscaleA4ADF()
+	settings.InputSource = "Platen"
+	settings.ColorMode = "RGB48"
+	for _, err := range scanner.ScanPages(context.Background(), settings) {
+		if err == nil || !strings.Contains(err.Error(), "RGB48") {
+			t.Fatalf("ScanPages: got err %v, want color mode error", err)
+		}
+	}
+	if scanned {
+		t.Fatalf("ScanPages unexpectedly started a scan job with invalid settings")
+	}
+	if _, err := scanner.ScannerStatusContext(context.Background()); err == nil {
+		t.Fatalf("ScannerStatusContext unexpectedly succeeded without StatusFunc")
+	}
+}
+
+func TestClose(t *testing.T) {
+	for _, tt := range []struct {
+		desc         string
+		deleteStatus int  // how the device responds to DELETE
+		scanAll      bool // whether to receive all pages before Close
+		wantDeletes  int
+		wantStatus   int // StatusError.StatusCode, or 0 if no error is expected
+	}{
+		{desc: "200 OK", deleteStatus: http.StatusOK, wantDeletes: 1},
+		{desc: "204 No Content", deleteStatus: http.StatusNoContent, wantDeletes: 1},
+		{desc: "404 Not Found", deleteStatus: http.StatusNotFound, wantDeletes: 1},
+		{
+			desc:         "500 Internal Server Error",
+			deleteStatus: http.StatusInternalServerError,
+			wantDeletes:  1,
+			wantStatus:   http.StatusInternalServerError,
+		},
+		{
+			desc:         "finished",
+			deleteStatus: http.StatusInternalServerError,
+			scanAll:      true,
+			wantDeletes:  0,
+		},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			scanner := mockScanner(t)
+			var (
+				mu      sync.Mutex
+				deletes int
+			)
+			cl := clientFor(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != "DELETE" {
+					scanner.ServeHTTP(w, r)
+					return
+				}
+				mu.Lock()
+				defer mu.Unlock()
+				deletes++
+				w.WriteHeader(tt.deleteStatus)
+			}))
+
+			grayscaleA4Platen := preset.GrayscaleA4ADF()
+			grayscaleA4Platen.InputSource = "Platen"
+			job, err := cl.Scan(grayscaleA4Platen)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for job.ScanPage() {
+				if !tt.scanAll {
+					break
+				}
+			}
+			if err := job.Err(); err != nil {
+				t.Fatal(err)
+			}
+			err = job.Close()
+			if tt.wantStatus == 0 {
+				if err != nil {
+					t.Fatalf("Close: %v", err)
+				}
+			} else {
+				var se *airscan.StatusError
+				if !errors.As(err, &se) {
+					t.Fatalf("Close: got %v, want *airscan.StatusError", err)
+				}
+				if got, want := se.StatusCode, tt.wantStatus; got != want {
+					t.Fatalf("unexpected StatusCode: got %d, want %d", got, want)
+				}
+			}
+			// Close must be idempotent:
+			if err := job.Close(); err != nil {
+				t.Fatalf("second Close: %v", err)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if got, want := deletes, tt.wantDeletes; got != want {
+				t.Errorf("unexpected number of DELETE requests: got %d, want %d", got, want)
+			}
+		})
+	}
+}
+
+func TestWaitIdle(t *testing.T) {
+	scanner := mockScanner(t)
+	var (
+		mu    sync.Mutex
+		polls int
+	)
+	cl := clientFor(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/eSCL/ScannerStatus" {
+			scanner.ServeHTTP(w, r)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		polls++
+		if polls == 1 {
+			// A different client’s scan job is in progress:
+			fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?>
+<scan:ScannerStatus xmlns:pwg="http://www.pwg.org/schemas/2010/12/sm" xmlns:scan="http://schemas.hp.com/imaging/escl/2011/05/03">
+  <pwg:Version>2.63</pwg:Version>
+  <pwg:State>Processing</pwg:State>
+</scan:ScannerStatus>`)
+			return
+		}
+		scanner.ServeHTTP(w, r)
+	}))
+
+	status, err := cl.WaitIdle(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := status.State, "Idle"; got != want {
+		t.Errorf("unexpected state: got %q, want %q", got, want)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if got, want := polls, 2; got != want {
+		t.Errorf("unexpected number of ScannerStatus polls: got %d, want %d", got, want)
+	}
+}
+
 var discoveredService *dnssd.BrowseEntry // descriptive name for ExampleClient_Scan
 
 func ExampleClient_Scan() {
@@ -213,3 +872,24 @@ func ExampleClient_Scan() {
 
 	// Scan succeeded!
 }
+
+func ExampleScanState_Pages() {
+	cl := airscan.NewClientForService(discoveredService)
+
+	grayscaleA4Platen := preset.GrayscaleA4ADF()
+	grayscaleA4Platen.InputSource = "Platen"
+	job, err := cl.Scan(grayscaleA4Platen)
+	if err != nil {
+		panic(err)
+	}
+
+	// The scan job is deleted from the device once the loop ends:
+	for page, err := range job.Pages(context.Background()) {
+		if err != nil {
+			panic(err)
+		}
+		// This is where you would typically save the page to a file, send it
+		// via the net, display or process it, etc.:
+		fmt.Printf("page %d: %d bytes\n", page.Number, len(page.Data))
+	}
+}
@@ -0,0 +1,148 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package airscantest provides an in-memory airscan.Scanner for testing
+// programs which depend on the airscan.Scanner interface.
+//
+// To test against a scanner which speaks eSCL via HTTP instead, see
+// https://pkg.go.dev/github.com/stapelberg/airscan/virtual.
+package airscantest
+
+import (
+	"context"
+	"iter"
+	"sync"
+	"sync/atomic"
+
+	"github.com/stapelberg/airscan"
+	"github.com/stapelberg/airscan/virtual"
+)
+
+// Fake is an in-memory airscan.Scanner, which returns the configured pages for
+// each scan job and records the scan jobs, so that tests can verify which
+// settings were used.
+//
+// It is safe to update its struct fields before first using the Fake.
+type Fake struct {
+	// Status is returned by ScannerStatusContext. If nil, the Fake reports
+	// an Idle scanner.
+	Status *airscan.ScannerStatus
+
+	// Capabilities are returned by ScannerCapabilitiesContext. If nil, the
+	// Fake reports the capabilities of a virtual.Scanner.
+	Capabilities *airscan.ScannerCapabilities
+
+	// Pages are the scan data of the pages which each scan job returns.
+	Pages [][]byte
+
+	// Err, if non-nil, is returned by ScannerStatusContext and
+	// ScannerCapabilitiesContext, and yielded by ScanPages after all Pages
+	// were yielded.
+	Err error
+
+	mu       sync.Mutex
+	jobs     []*airscan.ScanSettings
+	active   map[*atomic.Bool]bool
+	canceled int
+}
+
+var _ airscan.Scanner = (*Fake)(nil)
+
+// ScannerStatusContext returns f.Status.
+func (f *Fake) ScannerStatusContext(ctx context.Context) (*airscan.ScannerStatus, error) {
+	if f.Err != nil {
+		return nil, f.Err
+	}
+	if f.Status != nil {
+		return f.Status, nil
+	}
+	return &airscan.ScannerStatus{State: "Idle"}, nil
+}
+
+// ScannerCapabilitiesContext returns f.Capabilities.
+func (f *Fake) ScannerCapabilitiesContext(ctx context.Context) (*airscan.ScannerCapabilities, error) {
+	if f.Err != nil {
+		return nil, f.Err
+	}
+	if f.Capabilities != nil {
+		return f.Capabilities, nil
+	}
+	return (&virtual.Scanner{}).Capabilities(), nil
+}
+
+// ScanPages records a scan job with the specified settings and returns an
+// iterator over f.Pages.
+func (f *Fake) ScanPages(ctx context.Context, settings *airscan.ScanSettings) iter.Seq2[*airscan.Page, error] {
+	return func(yield func(*airscan.Page, error) bool) {
+		canceled := new(atomic.Bool)
+		f.mu.Lock()
+		f.jobs = append(f.jobs, settings)
+		if f.active == nil {
+			f.active = make(map[*atomic.Bool]bool)
+		}
+		f.active[canceled] = true
+		f.mu.Unlock()
+		defer func() {
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			delete(f.active, canceled)
+		}()
+
+		for idx, data := range f.Pages {
+			if canceled.Load() {
+				return
+			}
+			if err := ctx.Err(); err != nil {
+				yield(nil, err)
+				return
+			}
+			page := &airscan.Page{
+				Number: idx + 1,
+				Data:   data,
+			}
+			if !yield(page, nil) {
+				return
+			}
+		}
+		if f.Err != nil && !canceled.Load() {
+			yield(nil, f.Err)
+		}
+	}
+}
+
+// Cancel ends the iterations over the pages of all scan jobs in progress.
+func (f *Fake) Cancel(ctx context.Context) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for canceled := range f.active {
+		canceled.Store(true)
+		f.canceled++
+	}
+	return nil
+}
+
+// Jobs returns the settings of all scan jobs, in the order in which they were
+// started.
+func (f *Fake) Jobs() []*airscan.ScanSettings {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]*airscan.ScanSettings(nil), f.jobs...)
+}
+
+// Canceled returns the number of scan jobs which were canceled.
+func (f *Fake) Canceled() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.canceled
+}
@@ -0,0 +1,110 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package airscantest_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/stapelberg/airscan"
+	"github.com/stapelberg/airscan/airscantest"
+	"github.com/stapelberg/airscan/preset"
+)
+
+// scanAll scans all pages from scanner, as a program under test would.
+func scanAll(scanner airscan.Scanner, settings *airscan.ScanSettings) ([]string, error) {
+	var pages []string
+	for page, err := range scanner.ScanPages(context.Background(), settings) {
+		if err != nil {
+			return pages, err
+		}
+		pages = append(pages, string(page.Data))
+	}
+	return pages, nil
+}
+
+func TestFake(t *testing.T) {
+	fake := &airscantest.Fake{
+		Pages: [][]byte{[]byte("page 1"), []byte("page 2")},
+	}
+	status, err := fake.ScannerStatusContext(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := status.State, "Idle"; got != want {
+		t.Errorf("ScannerStatus: got state %q, want %q", got, want)
+	}
+	caps, err := fake.ScannerCapabilitiesContext(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	settings := preset.GrayscaleA4ADF()
+	if err := settings.Validate(caps); err != nil {
+		t.Errorf("default capabilities do not support preset: %v", err)
+	}
+
+	pages, err := scanAll(fake, settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{"page 1", "page 2"}, pages); diff != "" {
+		t.Fatalf("unexpected pages: diff (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]*airscan.ScanSettings{settings}, fake.Jobs()); diff != "" {
+		t.Fatalf("unexpected jobs: diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestFakeErr(t *testing.T) {
+	errJammed := errors.New("paper jam")
+	fake := &airscantest.Fake{
+		Pages: [][]byte{[]byte("page 1")},
+		Err:   errJammed,
+	}
+	pages, err := scanAll(fake, preset.GrayscaleA4ADF())
+	if !errors.Is(err, errJammed) {
+		t.Fatalf("ScanPages: got err %v, want %v", err, errJammed)
+	}
+	if diff := cmp.Diff([]string{"page 1"}, pages); diff != "" {
+		t.Fatalf("unexpected pages: diff (-want +got):\n%s", diff)
+	}
+	if _, err := fake.ScannerStatusContext(context.Background()); !errors.Is(err, errJammed) {
+		t.Fatalf("ScannerStatus: got err %v, want %v", err, errJammed)
+	}
+}
+
+func TestFakeCancel(t *testing.T) {
+	fake := &airscantest.Fake{
+		Pages: [][]byte{[]byte("page 1"), []byte("page 2"), []byte("page 3")},
+	}
+	var pages int
+	for _, err := range fake.ScanPages(context.Background(), preset.GrayscaleA4ADF()) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages++
+		if err := fake.Cancel(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, want := pages, 1; got != want {
+		t.Errorf("unexpected number of pages: got %d, want %d", got, want)
+	}
+	if got, want := fake.Canceled(), 1; got != want {
+		t.Errorf("unexpected number of canceled jobs: got %d, want %d", got, want)
+	}
+}
@@ -0,0 +1,176 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Program airscan-proxy shares an AirScan-compatible scanner with a different
+// network, e.g. when the scanner sits in a printer VLAN which clients cannot
+// reach via mDNS: airscan-proxy forwards eSCL requests to the scanner and
+// advertises itself via DNS-SD, so that clients (macOS, iOS, sane-airscan, …)
+// discover the scanner natively.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/brutella/dnssd"
+	"github.com/stapelberg/airscan"
+	"github.com/stapelberg/airscan/internal/daemon"
+)
+
+func humanDeviceName(srv dnssd.BrowseEntry) string {
+	if ty := srv.Text["ty"]; ty != "" {
+		return ty
+	}
+
+	// miekg/dns escapes characters in DNS labels: as per RFC1034 and
+	// RFC1035, labels do not actually permit whitespace. The purpose of
+	// escaping originally appears to be to use these labels in a DNS
+	// master file, but for our UI, backslashes look just wrong:
+	return strings.ReplaceAll(srv.Name, "\\", "")
+}
+
+// find discovers the scanner with the specified host name.
+func find(ctx context.Context, host string) (*dnssd.BrowseEntry, error) {
+	ctx, canc := context.WithCancel(ctx)
+	defer canc()
+	var found *dnssd.BrowseEntry
+	addFn := func(service dnssd.BrowseEntry) {
+		if service.Host == host {
+			found = &service
+			canc()
+			return
+		}
+		log.Printf("device %q discovered (use -host=%q)", humanDeviceName(service), service.Host)
+	}
+	rmvFn := func(dnssd.BrowseEntry) {}
+	if err := dnssd.LookupType(ctx, airscan.ServiceName, addFn, rmvFn); err != nil &&
+		err != context.Canceled &&
+		err != context.DeadlineExceeded {
+		return nil, err
+	}
+	if found == nil {
+		return nil, fmt.Errorf("scanner %q not found", host)
+	}
+	return found, nil
+}
+
+// proxyText returns the TXT records to advertise for the proxy: those of the
+// scanner, with URLs pointing to the proxy (host:port).
+func proxyText(service *dnssd.BrowseEntry, host string) map[string]string {
+	text := make(map[string]string, len(service.Text))
+	for k, v := range service.Text {
+		text[k] = v
+	}
+	for _, k := range []string{"adminurl", "representation"} {
+		if v, ok := text[k]; ok {
+			text[k] = rewriteURL(v, host)
+		}
+	}
+	return text
+}
+
+func airscanProxy() error {
+	var (
+		host = flag.String("host",
+			"",
+			"host name of the scanner to share (see airscan1 for discovering scanners)")
+
+		listen = flag.String("listen",
+			":8091",
+			"[host]:port on which to serve eSCL requests")
+
+		name = flag.String("name",
+			"",
+			"DNS-SD service name to advertise. If empty, the scanner’s service name is used")
+
+		ifaces = flag.String("ifaces",
+			"",
+			"comma-separated list of network interfaces on which to advertise the scanner (e.g. the one facing the clients). If empty, all interfaces are used")
+
+		timeout = flag.Duration("timeout",
+			5*time.Second,
+			"if non-zero, limit time for finding the scanner")
+
+		debug = flag.Bool("debug",
+			false,
+			"if true, print extra debug output")
+	)
+	flag.Parse()
+	if *host == "" {
+		return fmt.Errorf("-host is required")
+	}
+
+	ctx := context.Background()
+	if *timeout > 0 {
+		var canc context.CancelFunc
+		ctx, canc = context.WithTimeout(ctx, *timeout)
+		defer canc()
+	}
+	log.Printf("finding device %q for %v (use -timeout=0 for unlimited)", *host, *timeout)
+	service, err := find(ctx, *host)
+	if err != nil {
+		return err
+	}
+	log.Printf("device %q found", humanDeviceName(*service))
+
+	ln, err := net.Listen("tcp", *listen)
+	if err != nil {
+		return err
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		return err
+	}
+	proxyHost := net.JoinHostPort(hostname+".local", strconv.Itoa(port))
+	caps, err := airscan.NewClientForService(service).ScannerCapabilities()
+	if err != nil {
+		return err
+	}
+	caps.AdminURI = rewriteURL(caps.AdminURI, proxyHost)
+	caps.IconURI = rewriteURL(caps.IconURI, proxyHost)
+	cfg := airscan.AdvertiseConfig{
+		Name: *name,
+		Port: port,
+		// Keep vendor-specific entries of the scanner:
+		Text: proxyText(service, proxyHost),
+	}
+	if cfg.Name == "" {
+		cfg.Name = strings.ReplaceAll(service.Name, "\\", "")
+	}
+	if *ifaces != "" {
+		cfg.Ifaces = strings.Split(*ifaces, ",")
+	}
+	adv, err := airscan.Advertise(caps, cfg)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("sharing %q as %q on %s", humanDeviceName(*service), cfg.Name, ln.Addr())
+	return daemon.Serve(ln, newProxy(service, clientTransport(service), *debug), adv)
+}
+
+func main() {
+	if err := airscanProxy(); err != nil {
+		log.Fatal(err)
+	}
+}
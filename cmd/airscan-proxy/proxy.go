@@ -0,0 +1,109 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/brutella/dnssd"
+	"github.com/stapelberg/airscan"
+)
+
+// rewriteURL points the absolute URL u to host (host:port), keeping its path.
+// Relative URLs are returned unchanged, as they work via the proxy as-is.
+func rewriteURL(u, host string) string {
+	parsed, err := url.Parse(u)
+	if err != nil || parsed.Host == "" {
+		return u
+	}
+	parsed.Scheme = "http"
+	parsed.Host = host
+	return parsed.String()
+}
+
+// uriElementRe matches the capabilities elements which contain absolute URLs
+// of the scanner, e.g. <scan:AdminURI>http://192.168.1.5/</scan:AdminURI>.
+var uriElementRe = regexp.MustCompile(`(<(?:[\w-]+:)?(?:AdminURI|IconURI)>)([^<]*)(</)`)
+
+// rewriteCapabilities points the URLs in the ScannerCapabilities document to
+// host, so that clients access e.g. the admin page via the proxy, too.
+func rewriteCapabilities(b []byte, host string) []byte {
+	return uriElementRe.ReplaceAllFunc(b, func(m []byte) []byte {
+		parts := uriElementRe.FindSubmatch(m)
+		return []byte(string(parts[1]) + rewriteURL(string(parts[2]), host) + string(parts[3]))
+	})
+}
+
+// newProxy returns a reverse proxy to the scanner, which is reached like
+// airscan.Client reaches it (trying all addresses of the service).
+func newProxy(service *dnssd.BrowseEntry, transport http.RoundTripper, debug bool) *httputil.ReverseProxy {
+	target := &url.URL{
+		Scheme: "http",
+		Host:   net.JoinHostPort(service.Host, strconv.Itoa(service.Port)),
+	}
+	return &httputil.ReverseProxy{
+		Transport: transport,
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetURL(target)
+			// Make the host (as requested by the client) available to
+			// ModifyResponse via the X-Forwarded-Host header:
+			pr.SetXForwarded()
+			if strings.HasSuffix(pr.Out.URL.Path, "/ScannerCapabilities") {
+				// Let http.Transport handle compression so that the document
+				// can be rewritten:
+				pr.Out.Header.Del("Accept-Encoding")
+			}
+			if debug {
+				log.Printf("%s %s", pr.In.Method, pr.In.URL)
+			}
+		},
+		ModifyResponse: func(resp *http.Response) error {
+			host := resp.Request.Header.Get("X-Forwarded-Host")
+			if loc := resp.Header.Get("Location"); loc != "" {
+				resp.Header.Set("Location", rewriteURL(loc, host))
+			}
+			if !strings.HasSuffix(resp.Request.URL.Path, "/ScannerCapabilities") ||
+				resp.StatusCode != http.StatusOK {
+				return nil
+			}
+			b, err := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if err != nil {
+				return err
+			}
+			b = rewriteCapabilities(b, host)
+			resp.Body = io.NopCloser(bytes.NewReader(b))
+			resp.ContentLength = int64(len(b))
+			resp.Header.Set("Content-Length", strconv.Itoa(len(b)))
+			return nil
+		},
+	}
+}
+
+// clientTransport returns the http.RoundTripper of an airscan.Client for the
+// service, which tries all addresses of the service.
+func clientTransport(service *dnssd.BrowseEntry) http.RoundTripper {
+	cl := airscan.NewClientForService(service)
+	return cl.HTTPClient.(*http.Client).Transport
+}
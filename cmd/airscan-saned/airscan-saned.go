@@ -0,0 +1,104 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Program airscan-saned speaks the SANE network protocol (like saned), so that
+// SANE clients (scanimage, simple-scan, …) can use AirScan-compatible scanners
+// via SANE’s net backend, without installing sane-airscan.
+//
+// Add the host on which airscan-saned runs to /etc/sane.d/net.conf, then
+// discovered scanners are available as net:<host>:<scanner host name>.
+//
+// Note that airscan-saned does not restrict access (unlike saned, which uses
+// saned.conf), so only listen on trusted networks.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"net"
+	"time"
+
+	"github.com/brutella/dnssd"
+	"github.com/davecgh/go-spew/spew"
+	"github.com/stapelberg/airscan"
+	"github.com/stapelberg/airscan/queue"
+)
+
+func airscanSaned() error {
+	var (
+		listen = flag.String("listen",
+			":6566",
+			"[host]:port on which to serve the SANE network protocol (6566 is the registered sane-port)")
+
+		host = flag.String("host",
+			"",
+			"if non-empty, only offer the scanner with this host name (see airscan1 for discovering scanners). If empty, all discovered scanners are offered")
+
+		debug = flag.Bool("debug",
+			false,
+			"if true, print extra debug output")
+
+		lockDir = flag.String("lock_dir",
+			queue.DefaultLockDir,
+			"directory for lock files, which serialize scan jobs with other programs (e.g. airscan1) on this host. If empty, scan jobs are only serialized within airscan-saned")
+
+		waitIdle = flag.Duration("wait_idle",
+			1*time.Minute,
+			"how long a scan waits for a busy scanner (e.g. used by a different host) to become idle")
+	)
+	flag.Parse()
+
+	reg := newRegistry()
+	addFn := func(service dnssd.BrowseEntry) {
+		if *debug {
+			log.Printf("DNSSD service discovered: %v", spew.Sdump(service))
+		}
+		dev := newDevice(service, *debug)
+		if *host != "" && dev.name != *host {
+			return
+		}
+		reg.add(dev)
+		log.Printf("device %q discovered (SANE device name %q)", humanDeviceName(service), dev.name)
+	}
+	rmvFn := func(service dnssd.BrowseEntry) {
+		reg.remove(deviceName(service))
+	}
+	go func() {
+		// LookupType only returns when its context is canceled, or when
+		// discovery fails entirely:
+		if err := dnssd.LookupType(context.Background(), airscan.ServiceName, addFn, rmvFn); err != nil {
+			log.Printf("discovery failed: %v", err)
+		}
+	}()
+
+	ln, err := net.Listen("tcp", *listen)
+	if err != nil {
+		return err
+	}
+	srv := &server{
+		reg:      reg,
+		debug:    *debug,
+		queue:    queue.New(*lockDir),
+		waitIdle: *waitIdle,
+	}
+	log.Printf("serving SANE network protocol on %s", ln.Addr())
+	return srv.serve(ln)
+}
+
+func main() {
+	if err := airscanSaned(); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/brutella/dnssd"
+	"github.com/stapelberg/airscan"
+)
+
+func humanDeviceName(srv dnssd.BrowseEntry) string {
+	if ty := srv.Text["ty"]; ty != "" {
+		return ty
+	}
+
+	// miekg/dns escapes characters in DNS labels: as per RFC1034 and
+	// RFC1035, labels do not actually permit whitespace. The purpose of
+	// escaping originally appears to be to use these labels in a DNS
+	// master file, but for our UI, backslashes look just wrong:
+	return strings.ReplaceAll(srv.Name, "\\", "")
+}
+
+// deviceName returns the SANE device name of the discovered scanner, which is
+// its host name (as in airscan1’s -host flag). SANE clients refer to it as
+// net:<airscan-saned host>:<device name>.
+func deviceName(srv dnssd.BrowseEntry) string {
+	return strings.TrimSuffix(strings.TrimSuffix(srv.Host, "."), ".local")
+}
+
+// A device is a scanner which SANE clients can open.
+type device struct {
+	name   string
+	vendor string
+	model  string
+
+	// client returns a Client for the scanner.
+	client func() *airscan.Client
+}
+
+// newDevice returns the device for the discovered service. The vendor is the
+// first word of the human-readable name, as eSCL does not announce it
+// separately.
+func newDevice(service dnssd.BrowseEntry, debug bool) *device {
+	dev := &device{
+		name:   deviceName(service),
+		vendor: "AirScan",
+		model:  humanDeviceName(service),
+		client: func() *airscan.Client {
+			cl := airscan.NewClientForService(&service)
+			cl.SetDebug(debug)
+			return cl
+		},
+	}
+	if vendor, model, ok := strings.Cut(dev.model, " "); ok {
+		dev.vendor, dev.model = vendor, model
+	}
+	return dev
+}
+
+// registry contains the devices which are currently available.
+type registry struct {
+	mu      sync.Mutex
+	devices map[string]*device
+}
+
+func newRegistry() *registry {
+	return &registry{devices: make(map[string]*device)}
+}
+
+func (r *registry) add(dev *device) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.devices[dev.name] = dev
+}
+
+func (r *registry) remove(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.devices, name)
+}
+
+// get returns the device with the specified name, or the first device if name
+// is empty (the default device in SANE), or nil.
+func (r *registry) get(name string) *device {
+	if name == "" {
+		if devices := r.list(); len(devices) > 0 {
+			return devices[0]
+		}
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.devices[name]
+}
+
+// list returns all devices, sorted by name.
+func (r *registry) list() []*device {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	devices := make([]*device, 0, len(r.devices))
+	for _, dev := range r.devices {
+		devices = append(devices, dev)
+	}
+	sort.Slice(devices, func(i, j int) bool {
+		return devices[i].name < devices[j].name
+	})
+	return devices
+}
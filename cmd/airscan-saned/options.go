@@ -0,0 +1,525 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/stapelberg/airscan"
+	"github.com/stapelberg/airscan/preset"
+)
+
+// SANE value types (SANE_Value_Type).
+const (
+	typeBool   = 0
+	typeInt    = 1
+	typeFixed  = 2
+	typeString = 3
+	typeButton = 4
+	typeGroup  = 5
+)
+
+// SANE units (SANE_Unit).
+const (
+	unitNone = 0
+	unitMM   = 3
+	unitDPI  = 4
+)
+
+// SANE option capabilities (SANE_CAP_*).
+const (
+	capSoftSelect = 1 << 0
+	capSoftDetect = 1 << 2
+)
+
+// SANE constraint types (SANE_Constraint_Type).
+const (
+	constraintNone       = 0
+	constraintRange      = 1
+	constraintWordList   = 2
+	constraintStringList = 3
+)
+
+// SANE option actions (SANE_Action).
+const (
+	actionGetValue = 0
+	actionSetValue = 1
+	actionSetAuto  = 2
+)
+
+// Bits of the info word which SANE_NET_CONTROL_OPTION returns.
+const (
+	infoInexact       = 1 << 0
+	infoReloadOptions = 1 << 1
+	infoReloadParams  = 1 << 2
+)
+
+// Option indices. Option 0 is always the number of options.
+const (
+	optNumOptions = iota
+	optModeGroup
+	optMode
+	optResolution
+	optSource
+	optGeometryGroup
+	optTLX
+	optTLY
+	optBRX
+	optBRY
+	numOptions
+)
+
+// Scan modes and sources, named like in other SANE backends (see also
+// saneopts.h), so that frontends recognize them.
+const (
+	modeLineart = "Lineart"
+	modeGray    = "Gray"
+	modeColor   = "Color"
+
+	sourceFlatbed   = "Flatbed"
+	sourceADF       = "ADF"
+	sourceADFDuplex = "ADF Duplex"
+)
+
+// fixed converts v into SANE_Fixed, a fixed-point number with 16 fractional
+// bits.
+func fixed(v float64) int32 {
+	return int32(math.Round(v * (1 << 16)))
+}
+
+func unfixed(v int32) float64 {
+	return float64(v) / (1 << 16)
+}
+
+// A rangeConstraint is a SANE_Range.
+type rangeConstraint struct {
+	min, max, quant int32
+}
+
+// An optionDescriptor is a SANE_Option_Descriptor.
+type optionDescriptor struct {
+	name  string
+	title string
+	desc  string
+	typ   int32
+	unit  int32
+	size  int32
+	cap   int32
+
+	// At most one of the constraints is set.
+	rng        *rangeConstraint
+	wordList   []int32
+	stringList []string
+}
+
+func (d *optionDescriptor) encode(w *wire) {
+	w.string(d.name)
+	w.string(d.title)
+	w.string(d.desc)
+	w.word(d.typ)
+	w.word(d.unit)
+	w.word(d.size)
+	w.word(d.cap)
+	switch {
+	case d.rng != nil:
+		w.word(constraintRange)
+		w.pointer(false)
+		w.word(d.rng.min)
+		w.word(d.rng.max)
+		w.word(d.rng.quant)
+
+	case d.wordList != nil:
+		// The first word of a word list is the number of words:
+		w.word(constraintWordList)
+		w.words(append([]int32{int32(len(d.wordList))}, d.wordList...))
+
+	case d.stringList != nil:
+		// The string list is terminated by a NULL string, which is
+		// encoded with length 0:
+		w.word(constraintStringList)
+		w.word(int32(len(d.stringList) + 1))
+		for _, s := range d.stringList {
+			w.string(s)
+		}
+		w.word(0)
+
+	default:
+		w.word(constraintNone)
+	}
+}
+
+// stringSize returns the size of a string option which can hold all values.
+func stringSize(values []string) int32 {
+	size := 0
+	for _, v := range values {
+		if len(v) > size {
+			size = len(v)
+		}
+	}
+	return int32(size + 1) // terminating NUL byte
+}
+
+// options are the values of the SANE options of a handle.
+type options struct {
+	caps *airscan.ScannerCapabilities
+
+	mode       string
+	resolution int32
+	source     string
+
+	// Scan area in mm (SANE_Fixed).
+	tlx, tly, brx, bry int32
+}
+
+func newOptions(caps *airscan.ScannerCapabilities) *options {
+	o := &options{caps: caps}
+	o.source = o.sources()[0]
+	o.mode = modeGray
+	if contains(o.modes(), modeColor) {
+		o.mode = modeColor
+	}
+	o.resolution = closest(o.resolutions(), 300)
+	o.brx, o.bry = o.area()
+	return o
+}
+
+// inputSource returns the eSCL input source and whether to scan duplex.
+func (o *options) inputSource() (string, bool) {
+	switch o.source {
+	case sourceADF:
+		return "Feeder", false
+	case sourceADFDuplex:
+		return "Feeder", true
+	}
+	return "Platen", false
+}
+
+func (o *options) inputCaps() *airscan.InputCaps {
+	ic := o.caps.InputCaps(o.inputSource())
+	if ic == nil {
+		return &airscan.InputCaps{}
+	}
+	return ic
+}
+
+func (o *options) sources() []string {
+	var sources []string
+	if o.caps.Platen != nil {
+		sources = append(sources, sourceFlatbed)
+	}
+	if o.caps.Adf != nil {
+		sources = append(sources, sourceADF)
+		if o.caps.Adf.AdfDuplexInputCaps != nil {
+			sources = append(sources, sourceADFDuplex)
+		}
+	}
+	if len(sources) == 0 {
+		// Devices are expected to have at least one input source, but
+		// SANE requires a non-empty list:
+		sources = append(sources, sourceFlatbed)
+	}
+	return sources
+}
+
+// modes returns the supported scan modes. Lineart is always supported:
+// grayscale scans are converted.
+func (o *options) modes() []string {
+	modes := []string{modeLineart, modeGray}
+	if contains(o.inputCaps().ColorModes(), "RGB24") {
+		modes = append(modes, modeColor)
+	}
+	return modes
+}
+
+// resolutions returns the resolutions (with equal X and Y resolution) which
+// the input source supports, in increasing order. Resolution ranges are
+// represented by common resolutions within the range.
+func (o *options) resolutions() []int32 {
+	var resolutions []int32
+	add := func(r int32) {
+		if !contains32(resolutions, r) {
+			resolutions = append(resolutions, r)
+		}
+	}
+	for _, r := range o.inputCaps().Resolutions() {
+		var x, y int32
+		if n, _ := fmt.Sscanf(r, "%dx%d", &x, &y); n == 2 {
+			if x == y {
+				add(x)
+			}
+			continue
+		}
+		var lo, hi int32
+		if n, _ := fmt.Sscanf(r, "%d-%d", &lo, &hi); n == 2 {
+			for _, common := range []int32{75, 100, 150, 200, 300, 600, 1200} {
+				if lo <= common && common <= hi {
+					add(common)
+				}
+			}
+		}
+	}
+	if len(resolutions) == 0 {
+		resolutions = []int32{300}
+	}
+	sort.Slice(resolutions, func(i, j int) bool { return resolutions[i] < resolutions[j] })
+	return resolutions
+}
+
+// area returns the maximum width and height of the scan area of the input
+// source in mm (SANE_Fixed).
+func (o *options) area() (maxX, maxY int32) {
+	ic := o.inputCaps()
+	width, height := ic.MaxWidth, ic.MaxHeight
+	if width == 0 || height == 0 {
+		// Fall back to A4 for devices which do not specify their
+		// dimensions:
+		width, height = 2480, 3508
+	}
+	return fixed(airscan.UnitsToMillimeters(width)), fixed(airscan.UnitsToMillimeters(height))
+}
+
+func (o *options) descriptors() []*optionDescriptor {
+	maxX, maxY := o.area()
+	geometry := func(name, title, desc string, max int32) *optionDescriptor {
+		return &optionDescriptor{
+			name:  name,
+			title: title,
+			desc:  desc,
+			typ:   typeFixed,
+			unit:  unitMM,
+			size:  4,
+			cap:   capSoftSelect | capSoftDetect,
+			rng:   &rangeConstraint{min: 0, max: max},
+		}
+	}
+	return []*optionDescriptor{
+		optNumOptions: {
+			title: "Number of options",
+			desc:  "Read-only option that specifies how many options a specific device supports.",
+			typ:   typeInt,
+			unit:  unitNone,
+			size:  4,
+			cap:   capSoftDetect,
+		},
+		optModeGroup: {
+			title: "Scan Mode",
+			typ:   typeGroup,
+		},
+		optMode: {
+			name:       "mode",
+			title:      "Scan mode",
+			desc:       "Selects the scan mode (e.g., lineart, monochrome, or color).",
+			typ:        typeString,
+			size:       stringSize([]string{modeLineart, modeGray, modeColor}),
+			cap:        capSoftSelect | capSoftDetect,
+			stringList: o.modes(),
+		},
+		optResolution: {
+			name:     "resolution",
+			title:    "Scan resolution",
+			desc:     "Sets the resolution of the scanned image.",
+			typ:      typeInt,
+			unit:     unitDPI,
+			size:     4,
+			cap:      capSoftSelect | capSoftDetect,
+			wordList: o.resolutions(),
+		},
+		optSource: {
+			name:       "source",
+			title:      "Scan source",
+			desc:       "Selects the scan source (such as a document-feeder).",
+			typ:        typeString,
+			size:       stringSize([]string{sourceFlatbed, sourceADF, sourceADFDuplex}),
+			cap:        capSoftSelect | capSoftDetect,
+			stringList: o.sources(),
+		},
+		optGeometryGroup: {
+			title: "Geometry",
+			typ:   typeGroup,
+		},
+		optTLX: geometry("tl-x", "Top-left x", "Top-left x position of scan area.", maxX),
+		optTLY: geometry("tl-y", "Top-left y", "Top-left y position of scan area.", maxY),
+		optBRX: geometry("br-x", "Bottom-right x", "Bottom-right x position of scan area.", maxX),
+		optBRY: geometry("br-y", "Bottom-right y", "Bottom-right y position of scan area.", maxY),
+	}
+}
+
+// get returns the value of option idx: a string for string options, or a
+// word otherwise.
+func (o *options) get(idx int) (word int32, str string) {
+	switch idx {
+	case optNumOptions:
+		return numOptions, ""
+	case optMode:
+		return 0, o.mode
+	case optResolution:
+		return o.resolution, ""
+	case optSource:
+		return 0, o.source
+	case optTLX:
+		return o.tlx, ""
+	case optTLY:
+		return o.tly, ""
+	case optBRX:
+		return o.brx, ""
+	case optBRY:
+		return o.bry, ""
+	}
+	return 0, ""
+}
+
+// set sets option idx to word or str (depending on the option type) and
+// returns the SANE info bits, or a SANE status code if the value is invalid.
+func (o *options) set(idx int, word int32, str string) (info int32, status int32) {
+	switch idx {
+	case optMode:
+		if !contains(o.modes(), str) {
+			return 0, statusInval
+		}
+		o.mode = str
+		return infoReloadParams, statusGood
+
+	case optResolution:
+		o.resolution = closest(o.resolutions(), word)
+		if o.resolution != word {
+			info |= infoInexact
+		}
+		return info | infoReloadParams, statusGood
+
+	case optSource:
+		if !contains(o.sources(), str) {
+			return 0, statusInval
+		}
+		o.source = str
+		// The input sources might differ in their capabilities:
+		if !contains(o.modes(), o.mode) {
+			o.mode = modeGray
+		}
+		o.resolution = closest(o.resolutions(), o.resolution)
+		maxX, maxY := o.area()
+		for _, v := range []*int32{&o.tlx, &o.brx} {
+			*v = min(*v, maxX)
+		}
+		for _, v := range []*int32{&o.tly, &o.bry} {
+			*v = min(*v, maxY)
+		}
+		return infoReloadOptions | infoReloadParams, statusGood
+
+	case optTLX, optTLY, optBRX, optBRY:
+		maxX, maxY := o.area()
+		limit := maxX
+		if idx == optTLY || idx == optBRY {
+			limit = maxY
+		}
+		clamped := max(0, min(word, limit))
+		if clamped != word {
+			info |= infoInexact
+		}
+		switch idx {
+		case optTLX:
+			o.tlx = clamped
+		case optTLY:
+			o.tly = clamped
+		case optBRX:
+			o.brx = clamped
+		case optBRY:
+			o.bry = clamped
+		}
+		return info | infoReloadParams, statusGood
+	}
+	return 0, statusInval
+}
+
+// region returns the scan area in escl:ThreeHundredthsOfInches. Frontends
+// might set the corners in any order.
+func (o *options) region() *airscan.ScanRegion {
+	x0, x1 := min(o.tlx, o.brx), max(o.tlx, o.brx)
+	y0, y1 := min(o.tly, o.bry), max(o.tly, o.bry)
+	units := func(v int32) int { return airscan.MillimetersToUnits(unfixed(v)) }
+	return &airscan.ScanRegion{
+		ContentRegionUnits: airscan.ThreeHundredthsOfInches,
+		XOffset:            units(x0),
+		YOffset:            units(y0),
+		Width:              units(x1) - units(x0),
+		Height:             units(y1) - units(y0),
+	}
+}
+
+// settings returns the eSCL scan settings for the current option values.
+func (o *options) settings() *airscan.ScanSettings {
+	settings := preset.GrayscaleA4ADF()
+	settings.InputSource, settings.Duplex = o.inputSource()
+	// Lineart scans are converted from grayscale, as not all devices
+	// support BlackAndWhite1:
+	if o.mode == modeColor {
+		settings.ColorMode = "RGB24"
+	}
+	// Prefer a lossless document format:
+	if contains(o.inputCaps().DocumentFormats(), "image/png") {
+		settings.DocumentFormat = "image/png"
+	}
+	settings.XResolution = int(o.resolution)
+	settings.YResolution = int(o.resolution)
+	settings.ScanRegions.Regions = []*airscan.ScanRegion{o.region()}
+	return settings
+}
+
+// parameters returns the expected SANE_Parameters for the current option
+// values. The actual parameters are only known once the device delivered the
+// page.
+func (o *options) parameters() *parameters {
+	r := o.region()
+	return newParameters(o.mode,
+		airscan.UnitsToPixels(r.Width, int(o.resolution)),
+		airscan.UnitsToPixels(r.Height, int(o.resolution)))
+}
+
+func contains(values []string, v string) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
+
+func contains32(values []int32, v int32) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
+
+// closest returns the value in values which is closest to v.
+func closest(values []int32, v int32) int32 {
+	best := values[0]
+	for _, value := range values[1:] {
+		if abs(value-v) < abs(best-v) {
+			best = value
+		}
+	}
+	return best
+}
+
+func abs(v int32) int32 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
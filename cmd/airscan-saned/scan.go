@@ -0,0 +1,159 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"image"
+	"image/draw"
+	"net"
+	"time"
+)
+
+// SANE frame formats (SANE_Frame).
+const (
+	frameGray = 0
+	frameRGB  = 1
+)
+
+// byteOrderBigEndian announces the byte order of image data with a depth of
+// 16 bits (airscan-saned only sends 1 and 8 bit data).
+const byteOrderBigEndian = 0x4321
+
+// dataRecordSize is the maximum size of a record on the data connection.
+const dataRecordSize = 32 * 1024
+
+// acceptTimeout limits how long to wait for the client to open the data
+// connection after SANE_NET_START.
+const acceptTimeout = 1 * time.Minute
+
+// parameters are SANE_Parameters.
+type parameters struct {
+	format        int32
+	lastFrame     bool
+	bytesPerLine  int32
+	pixelsPerLine int32
+	lines         int32
+	depth         int32
+}
+
+func newParameters(mode string, width, height int) *parameters {
+	p := &parameters{
+		format:        frameGray,
+		lastFrame:     true,
+		bytesPerLine:  int32(width),
+		pixelsPerLine: int32(width),
+		lines:         int32(height),
+		depth:         8,
+	}
+	switch mode {
+	case modeColor:
+		p.format = frameRGB
+		p.bytesPerLine = int32(3 * width)
+	case modeLineart:
+		p.depth = 1
+		p.bytesPerLine = int32((width + 7) / 8)
+	}
+	return p
+}
+
+func (p *parameters) encode(w *wire) {
+	w.word(p.format)
+	w.bool(p.lastFrame)
+	w.word(p.bytesPerLine)
+	w.word(p.pixelsPerLine)
+	w.word(p.lines)
+	w.word(p.depth)
+}
+
+// frame converts img into SANE image data in the specified mode: RGB or gray
+// pixels with 8 bits per sample, or (for lineart) 1 bit per pixel, where 1 is
+// black.
+func frame(img image.Image, mode string) (*parameters, []byte) {
+	b := img.Bounds()
+	p := newParameters(mode, b.Dx(), b.Dy())
+	data := make([]byte, 0, int(p.bytesPerLine)*b.Dy())
+	if mode == modeColor {
+		rgba, ok := img.(*image.RGBA)
+		if !ok {
+			rgba = image.NewRGBA(b)
+			draw.Draw(rgba, b, img, b.Min, draw.Src)
+		}
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				c := rgba.RGBAAt(x, y)
+				data = append(data, c.R, c.G, c.B)
+			}
+		}
+		return p, data
+	}
+
+	gray, ok := img.(*image.Gray)
+	if !ok {
+		gray = image.NewGray(b)
+		draw.Draw(gray, b, img, b.Min, draw.Src)
+	}
+	if mode == modeGray {
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			off := gray.PixOffset(b.Min.X, y)
+			data = append(data, gray.Pix[off:off+b.Dx()]...)
+		}
+		return p, data
+	}
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		line := make([]byte, p.bytesPerLine)
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if gray.GrayAt(x, y).Y < 0x80 {
+				i := x - b.Min.X
+				line[i/8] |= 0x80 >> (i % 8)
+			}
+		}
+		data = append(data, line...)
+	}
+	return p, data
+}
+
+// sendFrame accepts the client’s data connection on ln and sends data in
+// records, each prefixed with its length, followed by the end marker
+// (0xffffffff) and the status byte SANE_STATUS_EOF.
+func sendFrame(ctx context.Context, ln *net.TCPListener, data []byte) error {
+	ln.SetDeadline(time.Now().Add(acceptTimeout))
+	stop := context.AfterFunc(ctx, func() { ln.Close() })
+	defer stop()
+	conn, err := ln.Accept()
+	ln.Close()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	stopConn := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stopConn()
+
+	var length [4]byte
+	for len(data) > 0 {
+		n := min(len(data), dataRecordSize)
+		binary.BigEndian.PutUint32(length[:], uint32(n))
+		if _, err := conn.Write(length[:]); err != nil {
+			return err
+		}
+		if _, err := conn.Write(data[:n]); err != nil {
+			return err
+		}
+		data = data[n:]
+	}
+	_, err = conn.Write([]byte{0xff, 0xff, 0xff, 0xff, statusEOF})
+	return err
+}
@@ -0,0 +1,98 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestFrame(t *testing.T) {
+	// A 10x2 image, whose first line is black except for pixels 1 and 9,
+	// and whose second line is white except for pixel 8:
+	img := image.NewGray(image.Rect(0, 0, 10, 2))
+	for x := range 10 {
+		img.SetGray(x, 0, color.Gray{0x00})
+		img.SetGray(x, 1, color.Gray{0xff})
+	}
+	img.SetGray(1, 0, color.Gray{0xff})
+	img.SetGray(9, 0, color.Gray{0x80}) // threshold: white
+	img.SetGray(8, 1, color.Gray{0x7f}) // threshold: black
+
+	for _, tt := range []struct {
+		mode       string
+		wantParams *parameters
+		wantData   []byte
+	}{
+		{
+			mode: modeLineart,
+			wantParams: &parameters{
+				format:        frameGray,
+				lastFrame:     true,
+				bytesPerLine:  2,
+				pixelsPerLine: 10,
+				lines:         2,
+				depth:         1,
+			},
+			// 1 is black, the most significant bit is the first pixel,
+			// and each line is padded to a full byte:
+			wantData: []byte{
+				0b1011_1111, 0b1000_0000,
+				0b0000_0000, 0b1000_0000,
+			},
+		},
+		{
+			mode: modeGray,
+			wantParams: &parameters{
+				format:        frameGray,
+				lastFrame:     true,
+				bytesPerLine:  10,
+				pixelsPerLine: 10,
+				lines:         2,
+				depth:         8,
+			},
+			wantData: append(
+				[]byte{0, 0xff, 0, 0, 0, 0, 0, 0, 0, 0x80},
+				0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x7f, 0xff),
+		},
+	} {
+		t.Run(tt.mode, func(t *testing.T) {
+			params, data := frame(img, tt.mode)
+			if diff := cmp.Diff(tt.wantParams, params, cmp.AllowUnexported(parameters{})); diff != "" {
+				t.Errorf("unexpected parameters: diff (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tt.wantData, data); diff != "" {
+				t.Errorf("unexpected data: diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestFrameColor(t *testing.T) {
+	// Images are converted, and their bounds need not start at 0:
+	img := image.NewNRGBA(image.Rect(5, 5, 7, 6))
+	img.Set(5, 5, color.NRGBA{0xff, 0, 0, 0xff})
+	img.Set(6, 5, color.NRGBA{0, 0, 0xff, 0xff})
+	params, data := frame(img, modeColor)
+	if got, want := params.bytesPerLine, int32(6); got != want {
+		t.Errorf("unexpected bytes per line: got %d, want %d", got, want)
+	}
+	if diff := cmp.Diff([]byte{0xff, 0, 0, 0, 0, 0xff}, data); diff != "" {
+		t.Errorf("unexpected data: diff (-want +got):\n%s", diff)
+	}
+}
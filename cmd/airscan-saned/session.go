@@ -0,0 +1,442 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"log"
+	"net"
+	"time"
+
+	"github.com/stapelberg/airscan"
+	"github.com/stapelberg/airscan/queue"
+)
+
+// cancelTimeout limits how long canceling a scan job may take.
+const cancelTimeout = 30 * time.Second
+
+// A server accepts SANE network protocol connections.
+type server struct {
+	reg   *registry
+	debug bool
+
+	// queue serializes scan jobs with other programs (e.g. airscan1) on
+	// the same host.
+	queue *queue.Queue
+
+	// waitIdle limits how long to wait for the device to become Idle, e.g.
+	// when a different host is scanning.
+	waitIdle time.Duration
+}
+
+func (s *server) serve(ln net.Listener) error {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			defer conn.Close()
+			sess := &session{
+				srv:     s,
+				conn:    conn,
+				w:       newWire(conn),
+				handles: make(map[int32]*handle),
+			}
+			if err := sess.serve(); err != nil {
+				log.Printf("%s: %v", conn.RemoteAddr(), err)
+			}
+		}()
+	}
+}
+
+// A session is a connection of a SANE client (i.e. the net backend).
+type session struct {
+	srv     *server
+	conn    net.Conn
+	w       *wire
+	handles map[int32]*handle
+	last    int32 // last handle
+}
+
+// A handle is an opened device.
+type handle struct {
+	dev    *device
+	client *airscan.Client
+	opts   *options
+
+	// The following fields are set while a scan job is in progress.
+	scan   *airscan.ScanState
+	lock   *queue.Lock
+	params *parameters        // of the current page
+	stop   context.CancelFunc // aborts the data connection
+}
+
+func (s *session) serve() error {
+	defer func() {
+		for _, h := range s.handles {
+			s.cancel(h)
+		}
+	}()
+	w := s.w
+	for {
+		proc := w.readWord()
+		if w.err != nil {
+			if errors.Is(w.err, io.EOF) {
+				return nil
+			}
+			return w.err
+		}
+		switch proc {
+		case procInit:
+			version := w.readWord()
+			w.readString() // user name
+			if w.err != nil {
+				return w.err
+			}
+			if major, build := version>>24, version&0xffff; major != 1 || build != protocolVersion {
+				w.word(statusInval)
+				w.word(versionCode(1, 0, protocolVersion))
+				w.flush()
+				return fmt.Errorf("unsupported protocol version %#x (want major 1, build %d)", version, protocolVersion)
+			}
+			w.word(statusGood)
+			w.word(versionCode(1, 0, protocolVersion))
+
+		case procGetDevices:
+			devices := s.srv.reg.list()
+			w.word(statusGood)
+			// The device list is terminated by a NULL pointer:
+			w.word(int32(len(devices) + 1))
+			for _, dev := range devices {
+				w.pointer(false)
+				w.string(dev.name)
+				w.string(dev.vendor)
+				w.string(dev.model)
+				w.string("scanner")
+			}
+			w.pointer(true)
+
+		case procOpen:
+			name := w.readString()
+			if w.err != nil {
+				return w.err
+			}
+			id, status := s.open(name)
+			w.word(status)
+			w.word(id)
+			w.word(0) // resource to authorize: NULL
+
+		case procClose:
+			id := w.readWord()
+			if h, ok := s.handle(id); ok {
+				s.cancel(h)
+				delete(s.handles, id)
+			}
+			w.word(0)
+
+		case procGetOptionDescriptors:
+			h, ok := s.handle(w.readWord())
+			if !ok {
+				w.word(0)
+				break
+			}
+			descs := h.opts.descriptors()
+			w.word(int32(len(descs)))
+			for _, d := range descs {
+				w.pointer(false)
+				d.encode(w)
+			}
+
+		case procControlOption:
+			if err := s.controlOption(); err != nil {
+				return err
+			}
+
+		case procGetParameters:
+			h, ok := s.handle(w.readWord())
+			if !ok {
+				w.word(statusInval)
+				(&parameters{}).encode(w)
+				break
+			}
+			params := h.params
+			if params == nil {
+				params = h.opts.parameters()
+			}
+			w.word(statusGood)
+			params.encode(w)
+
+		case procStart:
+			h, ok := s.handle(w.readWord())
+			status, port := int32(statusInval), 0
+			if ok {
+				status, port = s.start(h)
+			}
+			w.word(status)
+			w.word(int32(port))
+			w.word(byteOrderBigEndian)
+			w.word(0) // resource to authorize: NULL
+
+		case procCancel:
+			if h, ok := s.handle(w.readWord()); ok {
+				s.cancel(h)
+			}
+			w.word(0)
+
+		case procExit:
+			return nil
+
+		default:
+			return fmt.Errorf("unsupported procedure %d", proc)
+		}
+		if err := w.flush(); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *session) handle(id int32) (*handle, bool) {
+	h, ok := s.handles[id]
+	return h, ok
+}
+
+// open opens the device with the specified name and returns its handle and a
+// SANE status.
+func (s *session) open(name string) (int32, int32) {
+	dev := s.srv.reg.get(name)
+	if dev == nil {
+		return 0, statusInval
+	}
+	cl := dev.client()
+	caps, err := cl.ScannerCapabilities()
+	if err != nil {
+		log.Printf("%s: %v", dev.name, err)
+		return 0, statusIOError
+	}
+	s.last++
+	s.handles[s.last] = &handle{
+		dev:    dev,
+		client: cl,
+		opts:   newOptions(caps),
+	}
+	return s.last, statusGood
+}
+
+func (s *session) controlOption() error {
+	w := s.w
+	id := w.readWord()
+	idx := int(w.readWord())
+	action := w.readWord()
+	var (
+		valueType int32
+		word      int32
+		str       string
+	)
+	if action != actionSetAuto {
+		valueType = w.readWord()
+		w.readWord() // value size
+		switch valueType {
+		case typeString:
+			b := w.readBytes()
+			for i, c := range b {
+				if c == 0 {
+					b = b[:i]
+					break
+				}
+			}
+			str = string(b)
+		default:
+			// Words (bool, int, fixed) or, for buttons and groups,
+			// an empty array:
+			if words := w.readWords(); len(words) > 0 {
+				word = words[0]
+			}
+		}
+	}
+	if w.err != nil {
+		return w.err
+	}
+
+	h, ok := s.handle(id)
+	status, info := int32(statusGood), int32(0)
+	switch {
+	case !ok || idx < 0 || idx >= numOptions:
+		status = statusInval
+	case action == actionSetValue && h.scan != nil:
+		// The settings of the scan job in progress cannot be changed, and
+		// GetParameters would no longer describe its frames:
+		status = statusDeviceBusy
+	case action == actionSetValue:
+		info, status = h.opts.set(idx, word, str)
+	case action != actionGetValue:
+		status = statusUnsupported
+	}
+	if status != statusGood {
+		w.word(status)
+		w.word(0)         // info
+		w.word(valueType) // value type
+		w.word(0)         // value size
+		w.word(0)         // value: empty array
+		w.word(0)         // resource to authorize: NULL
+		return nil
+	}
+
+	desc := h.opts.descriptors()[idx]
+	word, str = h.opts.get(idx)
+	w.word(statusGood)
+	w.word(info)
+	w.word(desc.typ)
+	w.word(desc.size)
+	switch desc.typ {
+	case typeString:
+		b := make([]byte, desc.size)
+		copy(b, str)
+		w.bytes(b)
+	case typeGroup, typeButton:
+		w.word(0)
+	default:
+		w.words([]int32{word})
+	}
+	w.word(0) // resource to authorize: NULL
+	return nil
+}
+
+// start starts scanning the next page: if no scan job is in progress, a new
+// one is created. The page is transferred via a separate data connection,
+// whose port start returns along with a SANE status.
+func (s *session) start(h *handle) (int32, int) {
+	if h.stop != nil {
+		h.stop() // previous page
+		h.stop = nil
+	}
+	if h.scan == nil {
+		if status := s.startJob(h); status != statusGood {
+			return status, 0
+		}
+	}
+	if !h.scan.ScanPage() {
+		status := int32(statusNoDocs) // all pages received
+		if err := h.scan.Err(); err != nil {
+			log.Printf("%s: %v", h.dev.name, err)
+			status = statusIOError
+		}
+		s.finishJob(h)
+		return status, 0
+	}
+	img, _, err := image.Decode(h.scan.CurrentPage())
+	if err != nil {
+		log.Printf("%s: decoding page: %v", h.dev.name, err)
+		s.cancel(h)
+		return statusIOError, 0
+	}
+	params, data := frame(img, h.opts.mode)
+	h.params = params
+
+	// Listen on the address on which the client reached us:
+	host, _, err := net.SplitHostPort(s.conn.LocalAddr().String())
+	if err != nil {
+		log.Printf("%s: %v", h.dev.name, err)
+		s.cancel(h)
+		return statusIOError, 0
+	}
+	ln, err := net.Listen("tcp", net.JoinHostPort(host, "0"))
+	if err != nil {
+		log.Printf("%s: %v", h.dev.name, err)
+		s.cancel(h)
+		return statusIOError, 0
+	}
+	ctx, canc := context.WithCancel(context.Background())
+	h.stop = canc
+	go func() {
+		if err := sendFrame(ctx, ln.(*net.TCPListener), data); err != nil && ctx.Err() == nil {
+			log.Printf("%s: sending image data: %v", h.dev.name, err)
+		}
+	}()
+	return statusGood, ln.Addr().(*net.TCPAddr).Port
+}
+
+// startJob waits until the device is available and creates a scan job.
+func (s *session) startJob(h *handle) int32 {
+	ctx, canc := context.WithTimeout(context.Background(), s.srv.waitIdle)
+	defer canc()
+	lock, err := s.srv.queue.Acquire(ctx, h.dev.name, nil)
+	if err != nil {
+		return statusDeviceBusy
+	}
+	status, err := h.client.WaitIdle(ctx)
+	if err != nil {
+		lock.Release()
+		if status != nil {
+			return statusDeviceBusy
+		}
+		log.Printf("%s: %v", h.dev.name, err)
+		return statusIOError
+	}
+	settings := h.opts.settings()
+	if settings.InputSource == "Feeder" &&
+		status.ADFState != "" &&
+		status.ADFState != "ScannerAdfLoaded" {
+		lock.Release()
+		return statusNoDocs
+	}
+	if s.srv.debug {
+		log.Printf("%s: scanning with settings %+v", h.dev.name, settings)
+	}
+	scan, err := h.client.Scan(settings)
+	if err != nil {
+		lock.Release()
+		log.Printf("%s: %v", h.dev.name, err)
+		return statusIOError
+	}
+	h.scan = scan
+	h.lock = lock
+	return statusGood
+}
+
+// finishJob cleans up after all pages of the scan job were received.
+func (s *session) finishJob(h *handle) {
+	if err := h.scan.Close(); err != nil {
+		log.Printf("%s: deleting scan job: %v", h.dev.name, err)
+	}
+	h.lock.Release()
+	h.scan = nil
+	h.lock = nil
+	h.params = nil
+}
+
+// cancel aborts the data connection and the scan job (if any). SANE clients
+// call cancel after each scan, also when all pages were received.
+func (s *session) cancel(h *handle) {
+	if h.stop != nil {
+		h.stop()
+		h.stop = nil
+	}
+	if h.scan == nil {
+		return
+	}
+	ctx, canc := context.WithTimeout(context.Background(), cancelTimeout)
+	defer canc()
+	if err := h.scan.Cancel(ctx); err != nil {
+		log.Printf("%s: canceling scan job: %v", h.dev.name, err)
+	}
+	s.finishJob(h)
+}
@@ -0,0 +1,302 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/binary"
+	"image"
+	"io"
+	"net"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/brutella/dnssd"
+	"github.com/stapelberg/airscan/queue"
+	"github.com/stapelberg/airscan/virtual"
+)
+
+// newTestServer starts airscan-saned with one device, a virtual scanner whose
+// platen contains a white page, and returns a connection to it.
+func newTestServer(t *testing.T) (*server, *wire, *device) {
+	t.Helper()
+	scanner := httptest.NewServer(&virtual.Scanner{
+		Resolution: 10,
+		Pages: func() ([]virtual.Page, error) {
+			page := func() (image.Image, error) {
+				img := image.NewGray(image.Rect(0, 0, 85, 110))
+				for i := range img.Pix {
+					img.Pix[i] = 0xff
+				}
+				return img, nil
+			}
+			return []virtual.Page{page}, nil
+		},
+	})
+	t.Cleanup(scanner.Close)
+	host, portStr, err := net.SplitHostPort(scanner.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dev := newDevice(dnssd.BrowseEntry{
+		Host: host,
+		Port: port,
+		Text: map[string]string{"ty": "Virtual Scanner 3000"},
+	}, false)
+	reg := newRegistry()
+	reg.add(dev)
+
+	srv := &server{
+		reg:      reg,
+		queue:    queue.New(""),
+		waitIdle: 10 * time.Second,
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go srv.serve(ln)
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(30 * time.Second))
+	return srv, newWire(conn), dev
+}
+
+// call sends the procedure call and its arguments, which fn writes.
+func call(t *testing.T, w *wire, proc int32, fn func()) {
+	t.Helper()
+	w.word(proc)
+	if fn != nil {
+		fn()
+	}
+	if err := w.flush(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func expectWord(t *testing.T, w *wire, what string, want int32) {
+	t.Helper()
+	if got := w.readWord(); got != want {
+		t.Fatalf("unexpected %s: got %d, want %d (err: %v)", what, got, want, w.err)
+	}
+}
+
+func expectString(t *testing.T, w *wire, what string, want string) {
+	t.Helper()
+	if got := w.readString(); got != want {
+		t.Fatalf("unexpected %s: got %q, want %q (err: %v)", what, got, want, w.err)
+	}
+}
+
+// readFrame reads the image data from the data connection on port.
+func readFrame(t *testing.T, port int32) []byte {
+	t.Helper()
+	conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(int(port))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(30 * time.Second))
+	var data []byte
+	for {
+		var length [4]byte
+		if _, err := io.ReadFull(conn, length[:]); err != nil {
+			t.Fatal(err)
+		}
+		n := binary.BigEndian.Uint32(length[:])
+		if n == 0xffffffff {
+			break
+		}
+		record := make([]byte, n)
+		if _, err := io.ReadFull(conn, record); err != nil {
+			t.Fatal(err)
+		}
+		data = append(data, record...)
+	}
+	var status [1]byte
+	if _, err := io.ReadFull(conn, status[:]); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := status[0], byte(statusEOF); got != want {
+		t.Errorf("unexpected status after frame: got %d, want %d", got, want)
+	}
+	return data
+}
+
+func TestSession(t *testing.T) {
+	srv, w, dev := newTestServer(t)
+
+	call(t, w, procInit, func() {
+		w.word(versionCode(1, 0, protocolVersion))
+		w.string("test")
+	})
+	expectWord(t, w, "init status", statusGood)
+	expectWord(t, w, "version code", versionCode(1, 0, protocolVersion))
+
+	call(t, w, procGetDevices, nil)
+	expectWord(t, w, "get devices status", statusGood)
+	expectWord(t, w, "device list length", 2)
+	expectWord(t, w, "device pointer", 0)
+	expectString(t, w, "device name", dev.name)
+	expectString(t, w, "vendor", "Virtual")
+	expectString(t, w, "model", "Scanner 3000")
+	expectString(t, w, "type", "scanner")
+	expectWord(t, w, "terminating pointer", 1)
+
+	call(t, w, procOpen, func() { w.string(dev.name) })
+	expectWord(t, w, "open status", statusGood)
+	h := w.readWord()
+	expectWord(t, w, "resource", 0)
+
+	call(t, w, procGetOptionDescriptors, func() { w.word(h) })
+	if got, want := w.readWord(), int32(numOptions); got != want {
+		t.Fatalf("unexpected number of option descriptors: got %d, want %d", got, want)
+	}
+	for range numOptions {
+		w.readWord() // pointer
+		w.readString()
+		w.readString()
+		w.readString()
+		w.readWord() // type
+		w.readWord() // unit
+		w.readWord() // size
+		w.readWord() // cap
+		switch w.readWord() {
+		case constraintRange:
+			w.readWord() // pointer
+			w.readWord() // min
+			w.readWord() // max
+			w.readWord() // quant
+		case constraintWordList:
+			w.readWords()
+		case constraintStringList:
+			for range w.readWord() {
+				w.readString()
+			}
+		}
+	}
+	if w.err != nil {
+		t.Fatal(w.err)
+	}
+
+	call(t, w, procControlOption, func() {
+		w.word(h)
+		w.word(optMode)
+		w.word(actionSetValue)
+		w.word(typeString)
+		w.word(stringSize([]string{modeLineart, modeGray, modeColor}))
+		w.string(modeLineart)
+	})
+	expectWord(t, w, "set mode status", statusGood)
+	expectWord(t, w, "info", infoReloadParams)
+	expectWord(t, w, "value type", typeString)
+	expectWord(t, w, "value size", 8)
+	expectString(t, w, "mode", modeLineart)
+	expectWord(t, w, "resource", 0)
+
+	call(t, w, procControlOption, func() {
+		w.word(h)
+		w.word(optResolution)
+		w.word(actionSetValue)
+		w.word(typeInt)
+		w.word(4)
+		w.words([]int32{80})
+	})
+	expectWord(t, w, "set resolution status", statusGood)
+	expectWord(t, w, "info", infoInexact|infoReloadParams)
+	expectWord(t, w, "value type", typeInt)
+	expectWord(t, w, "value size", 4)
+	expectWord(t, w, "value length", 1)
+	expectWord(t, w, "resolution", 75)
+	expectWord(t, w, "resource", 0)
+
+	call(t, w, procStart, func() { w.word(h) })
+	expectWord(t, w, "start status", statusGood)
+	port := w.readWord()
+	expectWord(t, w, "byte order", byteOrderBigEndian)
+	expectWord(t, w, "resource", 0)
+	data := readFrame(t, port)
+
+	call(t, w, procGetParameters, func() { w.word(h) })
+	expectWord(t, w, "get parameters status", statusGood)
+	expectWord(t, w, "format", frameGray)
+	expectWord(t, w, "last frame", 1)
+	bytesPerLine := w.readWord()
+	pixelsPerLine := w.readWord()
+	lines := w.readWord()
+	expectWord(t, w, "depth", 1)
+	if got, want := bytesPerLine, (pixelsPerLine+7)/8; got != want {
+		t.Errorf("unexpected bytes per line: got %d, want %d", got, want)
+	}
+	if got, want := len(data), int(bytesPerLine*lines); got != want {
+		t.Errorf("unexpected image data size: got %d, want %d", got, want)
+	}
+	for idx, b := range data {
+		if b != 0 {
+			t.Fatalf("unexpected black pixels in white page at byte %d: %08b", idx, b)
+		}
+	}
+
+	// Options cannot be changed while the scan job is in progress:
+	call(t, w, procControlOption, func() {
+		w.word(h)
+		w.word(optMode)
+		w.word(actionSetValue)
+		w.word(typeString)
+		w.word(stringSize([]string{modeLineart, modeGray, modeColor}))
+		w.string(modeColor)
+	})
+	expectWord(t, w, "set mode status", statusDeviceBusy)
+	expectWord(t, w, "info", 0)
+	expectWord(t, w, "value type", typeString)
+	expectWord(t, w, "value size", 0)
+	expectWord(t, w, "value length", 0)
+	expectWord(t, w, "resource", 0)
+
+	// The platen contains only one page:
+	call(t, w, procStart, func() { w.word(h) })
+	expectWord(t, w, "start status", statusNoDocs)
+	w.readWord() // port
+	w.readWord() // byte order
+	expectWord(t, w, "resource", 0)
+	if got := srv.queue.Waiting(dev.name); got != 0 {
+		t.Errorf("device still locked after the scan job: %d waiting", got)
+	}
+
+	call(t, w, procCancel, func() { w.word(h) })
+	expectWord(t, w, "cancel", 0)
+	call(t, w, procClose, func() { w.word(h) })
+	expectWord(t, w, "close", 0)
+	call(t, w, procExit, nil)
+}
+
+func TestSessionUnsupportedVersion(t *testing.T) {
+	_, w, _ := newTestServer(t)
+	call(t, w, procInit, func() {
+		w.word(versionCode(1, 0, 2))
+		w.string("test")
+	})
+	expectWord(t, w, "init status", statusInval)
+}
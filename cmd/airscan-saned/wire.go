@@ -0,0 +1,193 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// protocolVersion is the SANE network protocol version (the build number of
+// the version code) implemented by airscan-saned.
+const protocolVersion = 3
+
+// versionCode returns a SANE version code, see SANE_VERSION_CODE.
+func versionCode(major, minor, build int32) int32 {
+	return major<<24 | minor<<16 | build
+}
+
+// Remote procedure call numbers, see
+// https://sane-project.gitlab.io/standard/net.html#remote-procedure-call-requests
+const (
+	procInit = iota
+	procGetDevices
+	procOpen
+	procClose
+	procGetOptionDescriptors
+	procControlOption
+	procGetParameters
+	procStart
+	procCancel
+	procAuthorize
+	procExit
+)
+
+// SANE status codes (SANE_Status).
+const (
+	statusGood         = 0
+	statusUnsupported  = 1
+	statusCancelled    = 2
+	statusDeviceBusy   = 3
+	statusInval        = 4
+	statusEOF          = 5
+	statusJammed       = 6
+	statusNoDocs       = 7
+	statusCoverOpen    = 8
+	statusIOError      = 9
+	statusNoMem        = 10
+	statusAccessDenied = 11
+)
+
+// wire encodes and decodes the SANE network protocol’s primitive types: all
+// words are 4-byte big-endian integers, characters are single bytes, strings
+// and arrays are prefixed with their length, and pointers with a word which
+// is 1 for NULL pointers.
+//
+// Errors are sticky: once reading or writing failed, all further operations
+// are no-ops and err returns the first error.
+type wire struct {
+	r   *bufio.Reader
+	w   *bufio.Writer
+	err error
+}
+
+func newWire(rw io.ReadWriter) *wire {
+	return &wire{
+		r: bufio.NewReader(rw),
+		w: bufio.NewWriter(rw),
+	}
+}
+
+func (w *wire) readWord() int32 {
+	if w.err != nil {
+		return 0
+	}
+	var b [4]byte
+	if _, err := io.ReadFull(w.r, b[:]); err != nil {
+		w.err = err
+		return 0
+	}
+	return int32(binary.BigEndian.Uint32(b[:]))
+}
+
+// maxArrayLen limits the length of arrays (and strings) sent by clients.
+const maxArrayLen = 1 << 20
+
+func (w *wire) readArrayLen() int {
+	n := w.readWord()
+	if n < 0 || n > maxArrayLen {
+		if w.err == nil {
+			w.err = fmt.Errorf("invalid array length %d", n)
+		}
+		return 0
+	}
+	return int(n)
+}
+
+func (w *wire) readBytes() []byte {
+	n := w.readArrayLen()
+	if w.err != nil {
+		return nil
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(w.r, b); err != nil {
+		w.err = err
+		return nil
+	}
+	return b
+}
+
+// readString reads a string, which is sent including its terminating NUL
+// byte (or with length 0 for NULL strings).
+func (w *wire) readString() string {
+	b := w.readBytes()
+	for idx, c := range b {
+		if c == 0 {
+			return string(b[:idx])
+		}
+	}
+	return string(b)
+}
+
+func (w *wire) readWords() []int32 {
+	n := w.readArrayLen()
+	words := make([]int32, 0, n)
+	for i := 0; i < n && w.err == nil; i++ {
+		words = append(words, w.readWord())
+	}
+	return words
+}
+
+func (w *wire) word(v int32) {
+	if w.err != nil {
+		return
+	}
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], uint32(v))
+	_, w.err = w.w.Write(b[:])
+}
+
+func (w *wire) bool(v bool) {
+	if v {
+		w.word(1)
+	} else {
+		w.word(0)
+	}
+}
+
+func (w *wire) bytes(b []byte) {
+	w.word(int32(len(b)))
+	if w.err != nil {
+		return
+	}
+	_, w.err = w.w.Write(b)
+}
+
+func (w *wire) string(s string) {
+	w.bytes(append([]byte(s), 0))
+}
+
+func (w *wire) words(v []int32) {
+	w.word(int32(len(v)))
+	for _, word := range v {
+		w.word(word)
+	}
+}
+
+// pointer writes whether the pointer is NULL. A non-NULL pointer must be
+// followed by the value.
+func (w *wire) pointer(null bool) {
+	w.bool(null)
+}
+
+func (w *wire) flush() error {
+	if w.err != nil {
+		return w.err
+	}
+	w.err = w.w.Flush()
+	return w.err
+}
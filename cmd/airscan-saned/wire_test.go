@@ -0,0 +1,154 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// encode returns the bytes which fn writes.
+func encode(t *testing.T, fn func(w *wire)) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := newWire(&buf)
+	fn(w)
+	if err := w.flush(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestWireEncoding(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		fn   func(w *wire)
+		want []byte
+	}{
+		{
+			name: "word",
+			fn:   func(w *wire) { w.word(-2) },
+			want: []byte{0xff, 0xff, 0xff, 0xfe},
+		},
+		{
+			name: "NULL pointer",
+			fn:   func(w *wire) { w.pointer(true) },
+			want: []byte{0, 0, 0, 1},
+		},
+		{
+			name: "non-NULL pointer",
+			fn:   func(w *wire) { w.pointer(false) },
+			want: []byte{0, 0, 0, 0},
+		},
+		{
+			name: "string includes NUL terminator",
+			fn:   func(w *wire) { w.string("ab") },
+			want: []byte{0, 0, 0, 3, 'a', 'b', 0},
+		},
+		{
+			name: "word list",
+			fn: func(w *wire) {
+				(&optionDescriptor{wordList: []int32{75, 150}}).encode(w)
+			},
+			want: []byte{
+				0, 0, 0, 1, 0, // name: empty string
+				0, 0, 0, 1, 0, // title
+				0, 0, 0, 1, 0, // desc
+				0, 0, 0, 0, // type
+				0, 0, 0, 0, // unit
+				0, 0, 0, 0, // size
+				0, 0, 0, 0, // cap
+				0, 0, 0, constraintWordList,
+				0, 0, 0, 3, // array length
+				0, 0, 0, 2, // number of words (first word of the list)
+				0, 0, 0, 75,
+				0, 0, 0, 150,
+			},
+		},
+		{
+			name: "string list",
+			fn: func(w *wire) {
+				(&optionDescriptor{stringList: []string{"A"}}).encode(w)
+			},
+			want: []byte{
+				0, 0, 0, 1, 0, // name: empty string
+				0, 0, 0, 1, 0, // title
+				0, 0, 0, 1, 0, // desc
+				0, 0, 0, 0, // type
+				0, 0, 0, 0, // unit
+				0, 0, 0, 0, // size
+				0, 0, 0, 0, // cap
+				0, 0, 0, constraintStringList,
+				0, 0, 0, 2, // array length, including the terminator
+				0, 0, 0, 2, 'A', 0,
+				0, 0, 0, 0, // NULL string terminates the list
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if diff := cmp.Diff(tt.want, encode(t, tt.fn)); diff != "" {
+				t.Errorf("unexpected encoding: diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestWireRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	w := newWire(&buf)
+	w.word(42)
+	w.string("net:scanner")
+	w.string("")
+	w.word(0) // NULL string
+	w.words([]int32{1, -1, 300})
+	if err := w.flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := w.readWord(), int32(42); got != want {
+		t.Errorf("readWord: got %d, want %d", got, want)
+	}
+	for _, want := range []string{"net:scanner", "", ""} {
+		if got := w.readString(); got != want {
+			t.Errorf("readString: got %q, want %q", got, want)
+		}
+	}
+	if diff := cmp.Diff([]int32{1, -1, 300}, w.readWords()); diff != "" {
+		t.Errorf("readWords: diff (-want +got):\n%s", diff)
+	}
+	if w.err != nil {
+		t.Fatal(w.err)
+	}
+
+	// Errors are sticky:
+	w.readWord()
+	if w.err == nil {
+		t.Fatalf("reading beyond the end unexpectedly succeeded")
+	}
+	if got := w.readString(); got != "" {
+		t.Errorf("readString after error: got %q, want empty string", got)
+	}
+}
+
+func TestWireInvalidLength(t *testing.T) {
+	for _, length := range []int32{-1, maxArrayLen + 1} {
+		w := newWire(bytes.NewBuffer(encode(t, func(w *wire) { w.word(length) })))
+		if b := w.readBytes(); w.err == nil {
+			t.Errorf("readBytes with length %d unexpectedly succeeded: %q", length, b)
+		}
+	}
+}
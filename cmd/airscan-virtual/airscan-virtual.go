@@ -0,0 +1,101 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Program airscan-virtual is a virtual AirScan scanner, which serves the
+// images in a directory as if they were a stack of paper in its document
+// feeder. It advertises itself via DNS-SD, so that clients discover it like a
+// physical scanner. This is useful for demos and for testing scan workflows.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/stapelberg/airscan"
+	"github.com/stapelberg/airscan/internal/daemon"
+	"github.com/stapelberg/airscan/virtual"
+)
+
+func airscanVirtual() error {
+	var (
+		dir = flag.String("dir",
+			"",
+			"directory containing the pages to scan: JPEG, PNG and GIF files, and PDF files containing JPEG images (e.g. scans), sorted by file name")
+
+		listen = flag.String("listen",
+			":8092",
+			"[host]:port on which to serve eSCL requests")
+
+		name = flag.String("name",
+			"airscan virtual scanner",
+			"DNS-SD service name and model name to advertise")
+
+		resolution = flag.Int("resolution",
+			300,
+			"resolution (in dpi) of the page images, which determines their physical size")
+
+		ifaces = flag.String("ifaces",
+			"",
+			"comma-separated list of network interfaces on which to advertise the scanner. If empty, all interfaces are used")
+
+		debug = flag.Bool("debug",
+			false,
+			"if true, print extra debug output")
+	)
+	flag.Parse()
+	if *dir == "" {
+		return fmt.Errorf("-dir is required")
+	}
+	if _, err := os.Stat(*dir); err != nil {
+		return err
+	}
+
+	scanner := &virtual.Scanner{
+		MakeAndModel: *name,
+		Resolution:   *resolution,
+		Pages:        virtual.Dir(*dir),
+		Debug:        *debug,
+	}
+
+	ln, err := net.Listen("tcp", *listen)
+	if err != nil {
+		return err
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+
+	cfg := airscan.AdvertiseConfig{
+		Name: *name,
+		Port: port,
+	}
+	if *ifaces != "" {
+		cfg.Ifaces = strings.Split(*ifaces, ",")
+	}
+	adv, err := airscan.Advertise(scanner.Capabilities(), cfg)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("serving %s as %q on %s", *dir, *name, ln.Addr())
+	return daemon.Serve(ln, scanner, adv)
+}
+
+func main() {
+	if err := airscanVirtual(); err != nil {
+		log.Fatal(err)
+	}
+}
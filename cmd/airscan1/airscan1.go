@@ -18,15 +18,19 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"crypto/tls"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
@@ -34,7 +38,8 @@ import (
 	"github.com/davecgh/go-spew/spew"
 	"github.com/google/renameio/v2"
 	"github.com/stapelberg/airscan"
-	"github.com/stapelberg/airscan/preset"
+	"github.com/stapelberg/airscan/internal/scanopts"
+	"github.com/stapelberg/airscan/queue"
 )
 
 func humanDeviceName(srv dnssd.BrowseEntry) string {
@@ -50,6 +55,18 @@ func humanDeviceName(srv dnssd.BrowseEntry) string {
 }
 
 func airscan1() error {
+	// An optional verb precedes the flags, e.g. airscan1 caps -host=…
+	verb := "scan"
+	args := os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		verb, args = args[0], args[1:]
+	}
+	switch verb {
+	case "scan", "caps", "watch":
+	default:
+		return fmt.Errorf("unknown verb %q, want one of scan, caps or watch", verb)
+	}
+
 	var sc airscanner
 
 	flag.BoolVar(
@@ -62,7 +79,7 @@ func airscan1() error {
 		&sc.host,
 		"host",
 		"",
-		"if specified, locate the scanner to use based on its Hostname")
+		"if specified, locate the scanner to use based on its Hostname. Defaults to the host from -config; specify -host= (empty) to discover all devices instead")
 
 	flag.BoolVar(
 		&sc.skipCertVerify,
@@ -76,6 +93,18 @@ func airscan1() error {
 		"/tmp",
 		"Directory in which to store the scanned page(s). Will be created if it does not exist")
 
+	flag.StringVar(
+		&sc.output,
+		"output",
+		"page{page}.{ext}",
+		"File name template (relative to -scan_dir) for the scanned page(s). Fields: {date}, {time}, {timestamp}, {device}, {job}, {page} (or e.g. {page:03}), {side}, {source}, {ext}. Directories will be created if they do not exist")
+
+	flag.StringVar(
+		&sc.collision,
+		"collision",
+		collisionIncrement,
+		"What to do when an output file already exists. One of increment (increase the page number, or add a suffix), overwrite or fail")
+
 	flag.StringVar(
 		&sc.source,
 		"source",
@@ -86,19 +115,65 @@ func airscan1() error {
 		&sc.size,
 		"size",
 		"A4",
-		"Page size. One of A4 or letter")
+		"Page size. Either a name (e.g. A4, A5, letter, legal, business-card, photo-4x6) or width x height with unit, e.g. 210x297mm or 8.5x11in")
+
+	flag.StringVar(
+		&sc.xOffset,
+		"x_offset",
+		"",
+		"Horizontal offset of the scan region, with unit, e.g. 10mm or 0.5in. If empty, the region is placed according to the ADF’s justification (if any), otherwise at 0")
+
+	flag.StringVar(
+		&sc.yOffset,
+		"y_offset",
+		"",
+		"Vertical offset of the scan region, with unit, e.g. 10mm or 0.5in. If empty, the region is placed according to the ADF’s justification (if any), otherwise at 0")
+
+	flag.IntVar(
+		&sc.resolution,
+		"resolution",
+		300,
+		"Resolution in dpi (see the caps verb for supported resolutions)")
+
+	flag.StringVar(
+		&sc.intent,
+		"intent",
+		"",
+		"if non-empty, scan intent to request from the scanner, e.g. Document, Photo, TextAndGraphic or Preview")
+
+	flag.StringVar(
+		&sc.contentType,
+		"content_type",
+		"",
+		"if non-empty, content type to request from the scanner, e.g. Text, Photo or TextAndPhoto")
+
+	flag.Var(
+		&sc.brightness,
+		"brightness",
+		"if specified, brightness to request from the scanner (see the caps verb for the supported range)")
+
+	flag.Var(
+		&sc.contrast,
+		"contrast",
+		"if specified, contrast to request from the scanner (see the caps verb for the supported range)")
+
+	flag.BoolVar(
+		&sc.validate,
+		"validate",
+		true,
+		"if true, verify the scan settings against the scanner capabilities before scanning. Disable for devices which misreport their capabilities")
 
 	flag.StringVar(
 		&sc.format,
 		"format",
 		"image/jpeg",
-		"File format to request from the scanner")
+		"File format to request from the scanner, e.g. image/jpeg or application/pdf")
 
 	flag.StringVar(
 		&sc.color,
 		"color",
 		"Grayscale8",
-		"Color mode to request from the scanner (Grayscale8, RGB24)")
+		"Color mode to request from the scanner, e.g. Grayscale8 or RGB24")
 
 	flag.BoolVar(
 		&sc.duplex,
@@ -106,7 +181,93 @@ func airscan1() error {
 		true,
 		"if false, scan only the front side of the page")
 
+	flag.BoolVar(
+		&sc.splitPhotos,
+		"split_photos",
+		false,
+		"if true, detect separate photos (or other rectangular objects) in each scanned page, and write each photo (cropped and deskewed) as its own page. Requires -format=image/jpeg or -format=image/png; use with -source=platen and the full platen size, e.g. -size=legal")
+
+	flag.IntVar(
+		&sc.splitMinSize,
+		"split_min_size",
+		0,
+		"-split_photos: minimum width and height of a photo in pixels. Smaller objects are ignored. If 0, 1/20 of the scanned page")
+
+	flag.IntVar(
+		&sc.splitThreshold,
+		"split_threshold",
+		0,
+		"-split_photos: luminance (1-255) below which pixels are considered part of a photo instead of the background. If 0, a default for white backgrounds is used")
+
+	flag.StringVar(
+		&sc.pageHookCmd,
+		"page_hook",
+		"",
+		"if non-empty, shell command to run for each written page. Metadata is passed in AIRSCAN_* environment variables (e.g. AIRSCAN_FILE) and as JSON on stdin")
+
+	flag.StringVar(
+		&sc.jobHookCmd,
+		"job_hook",
+		"",
+		"if non-empty, shell command to run once all pages of the scan job were written. Metadata is passed in AIRSCAN_* environment variables (e.g. AIRSCAN_FILES, newline-separated) and as JSON on stdin")
+
+	flag.StringVar(
+		&sc.lockDir,
+		"lock_dir",
+		queue.DefaultLockDir,
+		"directory for lock files, which serialize scan jobs with other programs (e.g. airscand or airscan1 cron jobs) on this host. If empty, no lock file is used")
+
+	flag.DurationVar(
+		&sc.waitLock,
+		"wait_lock",
+		10*time.Minute,
+		"how long to wait for other scan jobs on this host (see -lock_dir) to finish. If 0, fail immediately")
+
+	flag.DurationVar(
+		&sc.waitIdle,
+		"wait_idle",
+		1*time.Minute,
+		"how long to wait for a busy scanner (e.g. used by a different host) to become idle. If 0, fail immediately")
+
+	flag.DurationVar(
+		&sc.watchInterval,
+		"watch_interval",
+		airscan.DefaultWatchInterval,
+		"watch verb only: how often to poll the scanner status for a loaded ADF")
+
+	flag.DurationVar(
+		&sc.watchDebounce,
+		"watch_debounce",
+		3*time.Second,
+		"watch verb only: how long the ADF must be loaded before scanning, so that the stack can be inserted completely")
+
+	flag.StringVar(
+		&sc.quietHours,
+		"quiet_hours",
+		"",
+		"watch verb only: if non-empty, daily time range (local time) during which no scan jobs are started, e.g. 22:00-07:00. Stacks inserted during quiet hours are scanned once they end")
+
+	flag.IntVar(
+		&sc.maxFailures,
+		"max_failures",
+		airscan.DefaultMaxFailures,
+		"watch verb only: number of consecutive failures (of status polls or scan jobs) after which to give up")
+
+	flag.StringVar(
+		&sc.capsXML,
+		"caps_xml",
+		"",
+		"caps verb only: if non-empty, path to which to save the raw ScannerCapabilities XML (useful for bug reports)")
+
 	var (
+		configPath = flag.String("config",
+			defaultConfigPath(),
+			"path to the configuration file defining the default -host and named scan profiles (JSON)")
+
+		profile = flag.String("profile",
+			"",
+			"if non-empty, name of the scan profile (from -config) whose flags to use. Flags specified on the command line take precedence")
+
 		timeout = flag.Duration("timeout",
 			5*time.Second,
 			"if non-zero, limit time for finding the device")
@@ -115,7 +276,40 @@ func airscan1() error {
 			false,
 			"if true, report which ways of connecting to the discovered device work")
 	)
-	flag.Parse()
+	flag.CommandLine.Parse(args)
+
+	configSpecified := false
+	flag.Visit(func(f *flag.Flag) { configSpecified = configSpecified || f.Name == "config" })
+	cfg, err := loadConfig(*configPath, configSpecified || *profile != "")
+	if err != nil {
+		return err
+	}
+	if err := cfg.apply(flag.CommandLine, *profile); err != nil {
+		return err
+	}
+
+	switch sc.collision {
+	case collisionIncrement, collisionOverwrite, collisionFail:
+	default:
+		return fmt.Errorf("unexpected -collision: got %q, want one of %s, %s or %s", sc.collision, collisionIncrement, collisionOverwrite, collisionFail)
+	}
+	if sc.splitThreshold < 0 || sc.splitThreshold > 255 {
+		return fmt.Errorf("-split_threshold=%d out of range [0, 255]", sc.splitThreshold)
+	}
+	// Verify the template before discovering and scanning:
+	if _, err := expandOutput(sc.output, exampleFields); err != nil {
+		return err
+	}
+	if verb == "watch" {
+		if sc.source != "adf" {
+			return fmt.Errorf("the watch verb requires -source=adf, got %q", sc.source)
+		}
+		if sc.quietHours != "" {
+			if _, err := airscan.ParseQuietHours(sc.quietHours); err != nil {
+				return err
+			}
+		}
+	}
 
 	ctx, canc := context.WithCancel(context.Background())
 	defer canc()
@@ -181,6 +375,17 @@ func airscan1() error {
 		return nil
 	}
 
+	if verb == "caps" {
+		return sc.caps()
+	}
+
+	if verb == "watch" {
+		if err := os.MkdirAll(sc.scanDir, 0700); err != nil {
+			return err
+		}
+		return sc.watch()
+	}
+
 	start := time.Now()
 
 	if err := os.MkdirAll(sc.scanDir, 0700); err != nil {
@@ -201,100 +406,342 @@ type airscanner struct {
 	host           string
 	skipCertVerify bool
 	scanDir        string
+	output         string
+	collision      string
 	source         string
 	size           string
+	xOffset        string
+	yOffset        string
+	resolution     int
+	intent         string
+	contentType    string
+	brightness     optionalInt
+	contrast       optionalInt
+	validate       bool
 	format         string
 	color          string
 	duplex         bool
+	splitPhotos    bool
+	splitMinSize   int
+	splitThreshold int
+	pageHookCmd    string
+	jobHookCmd     string
+	capsXML        string
+	lockDir        string
+	waitLock       time.Duration
+	waitIdle       time.Duration
+	watchInterval  time.Duration
+	watchDebounce  time.Duration
+	quietHours     string
+	maxFailures    int
 	service        *dnssd.BrowseEntry
 }
 
-func (sc *airscanner) scan1() error {
+func (sc *airscanner) client() *airscan.Client {
 	cl := airscan.NewClientForService(sc.service)
 	transport := cl.HTTPClient.(*http.Client).Transport.(*http.Transport)
 	transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: sc.skipCertVerify}
+	return cl
+}
 
-	settings := preset.GrayscaleA4ADF()
-	switch sc.source {
-	case "platen":
-		settings.InputSource = "Platen"
-	case "adf":
-	default:
-		return fmt.Errorf("unexpected source: got %q, want one of platen or adf", sc.source)
-	}
-	switch sc.size {
-	case "A4":
-	case "letter":
-		settings.ScanRegions.Regions[0].Width = 2550
-		settings.ScanRegions.Regions[0].Height = 3300
-	default:
-		return fmt.Errorf("unexpected page size: got %q, want one of A4 or letter", sc.size)
-	}
-	suffix := "jpg"
+// scanSettings returns the scan settings specified by the flags (with the
+// region clamped to the device’s scan area) and the file name extension of
+// the scanned pages.
+func (sc *airscanner) scanSettings(cl *airscan.Client) (*airscan.ScanSettings, string, error) {
+	suffix := "bin"
 	switch sc.format {
 	case "image/jpeg":
+		suffix = "jpg"
+	case "image/png":
+		suffix = "png"
+	case "image/tiff":
+		suffix = "tif"
 	case "application/pdf":
 		suffix = "pdf"
-		settings.DocumentFormat = "application/pdf"
 	}
-	switch sc.color {
-	case "Grayscale8":
-	case "RGB24":
-		settings.ColorMode = "RGB24"
+	if sc.splitPhotos && suffix != "jpg" && suffix != "png" {
+		return nil, "", fmt.Errorf("-split_photos requires -format=image/jpeg or -format=image/png, got %q", sc.format)
+	}
+
+	caps, err := cl.ScannerCapabilities()
+	if err != nil {
+		return nil, "", err
+	}
+	opts := scanopts.Options{
+		Source:      sc.source,
+		Size:        sc.size,
+		XOffset:     sc.xOffset,
+		YOffset:     sc.yOffset,
+		Resolution:  sc.resolution,
+		Intent:      sc.intent,
+		ContentType: sc.contentType,
+		Brightness:  sc.brightness.value,
+		Contrast:    sc.contrast.value,
+		Format:      sc.format,
+		Color:       sc.color,
+		Duplex:      sc.duplex,
+	}
+	settings, err := opts.ScanSettings(caps)
+	if err != nil {
+		return nil, "", err
+	}
+	if sc.debug {
+		log.Printf("scan region: %+v", settings.ScanRegions.Regions[0])
+	}
+
+	if sc.validate {
+		if err := settings.Validate(caps); err != nil {
+			return nil, "", fmt.Errorf("%v (use the caps verb to list capabilities, or -validate=false to skip this check)", err)
+		}
 	}
-	settings.Duplex = sc.duplex
+	return settings, suffix, nil
+}
+
+// waitTurn waits for other scan jobs on this host, then for the scanner to
+// finish scan jobs of other hosts. The returned function releases the lock.
+func (sc *airscanner) waitTurn(ctx context.Context, cl *airscan.Client) (release func(), _ error) {
+	release = func() {}
+	if sc.lockDir != "" {
+		waitStart := time.Now()
+		lockCtx, canc := context.WithTimeout(ctx, sc.waitLock)
+		defer canc()
+		lock, err := queue.New(sc.lockDir).Acquire(lockCtx, sc.service.Host, nil)
+		if err != nil {
+			if errors.Is(err, context.DeadlineExceeded) {
+				return nil, fmt.Errorf("other scan jobs on this host did not finish within -wait_lock=%v", sc.waitLock)
+			}
+			return nil, err
+		}
+		release = func() { lock.Release() }
+		if waited := time.Since(waitStart); waited > time.Second {
+			log.Printf("waited %v for other scan jobs on this host", waited.Round(time.Second))
+		}
+	}
+	if sc.waitIdle > 0 {
+		ctx, canc := context.WithTimeout(ctx, sc.waitIdle)
+		defer canc()
+		if _, err := cl.WaitIdle(ctx); err != nil {
+			release()
+			return nil, err
+		}
+	}
+	return release, nil
+}
+
+// cancelTimeout limits how long to wait for the device to cancel an
+// interrupted scan job.
+const cancelTimeout = 30 * time.Second
+
+func (sc *airscanner) scan1() error {
+	cl := sc.client()
+
+	settings, suffix, err := sc.scanSettings(cl)
+	if err != nil {
+		return err
+	}
+
+	// Ctrl-C aborts waiting and cancels the scan job, so that the scanner
+	// does not stay busy with a job nobody is waiting for. A second Ctrl-C
+	// exits immediately.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	context.AfterFunc(ctx, stop)
+
+	release, err := sc.waitTurn(ctx, cl)
+	if err != nil {
+		return err
+	}
+	defer release()
 
 	scan, err := cl.Scan(settings)
 	if err != nil {
 		return err
 	}
-	defer scan.Close()
+	if isTerminal(os.Stderr) {
+		scan.SetProgressFunc(progressPrinter(os.Stderr))
+	}
 
-	pagenum := 1
-	for scan.ScanPage() {
-		if sc.debug {
-			log.Printf("receiving page %d", pagenum)
-		}
-		var fn string
-		for {
-			fn = filepath.Join(sc.scanDir, fmt.Sprintf("page%d.%s", pagenum, suffix))
-			_, err := os.Stat(fn)
-			if err == nil /* file exists */ {
-				pagenum++
-				continue
+	pw := sc.newPageWriter(settings, scan.JobID(), suffix)
+	for page, err := range scan.Pages(ctx) {
+		if err != nil {
+			if ctx.Err() == nil {
+				return err
+			}
+			// The -job_hook is not run for the incomplete scan job, but
+			// -page_hook failures of the pages written so far are reported:
+			if err := pw.hookErr(); err != nil {
+				log.Print(err)
 			}
-			if os.IsNotExist(err) {
-				break
+			cctx, canc := context.WithTimeout(context.Background(), cancelTimeout)
+			defer canc()
+			if err := scan.Cancel(cctx); err != nil {
+				return fmt.Errorf("scan job %s interrupted, canceling: %v", scan.JobID(), err)
 			}
+			return fmt.Errorf("scan job %s interrupted and canceled", scan.JobID())
+		}
+		if err := pw.write(bytes.NewReader(page.Data)); err != nil {
+			return err
 		}
+	}
+	return pw.finish()
+}
+
+// pageWriter writes the pages of one scan job to files (as specified by
+// -output) and runs the hooks.
+type pageWriter struct {
+	sc      *airscanner
+	namer   *outputNamer
+	job     *hookJob
+	duplex  bool
+	pagenum int // of the written pages, i.e. incremented for each photo
+
+	// scanned is the number of pages received from the device, which
+	// determines the side of the sheet.
+	scanned int
+
+	// Hook failures do not abort the scan job: the remaining pages are still
+	// written (the paper is already in the feeder), but reported at the end.
+	hookErrs []error
+}
+
+func (sc *airscanner) newPageWriter(settings *airscan.ScanSettings, jobID, suffix string) *pageWriter {
+	namer := &outputNamer{
+		dir:       sc.scanDir,
+		tmpl:      sc.output,
+		collision: sc.collision,
+		fields: outputFields{
+			Time:   time.Now(),
+			Device: humanDeviceName(*sc.service),
+			Job:    jobID,
+			Source: sc.source,
+			Ext:    suffix,
+		},
+	}
+	return &pageWriter{
+		sc:    sc,
+		namer: namer,
+		job: &hookJob{
+			Device: namer.fields.Device,
+			Host:   sc.service.Host,
+			Job:    jobID,
+			Source: sc.source,
+			Format: settings.DocumentFormat,
+		},
+		duplex:  settings.Duplex && settings.InputSource == "Feeder",
+		pagenum: 1,
+	}
+}
 
-		o, err := renameio.TempFile("", fn)
+// write writes the scanned page from r (or, with -split_photos, each photo
+// on it) and runs the -page_hook.
+func (pw *pageWriter) write(r io.Reader) error {
+	sc := pw.sc
+	pw.scanned++
+	if sc.debug {
+		log.Printf("receiving page %d", pw.scanned)
+	}
+	// All photos on a page are on the same side of the sheet:
+	side := side(pw.scanned, pw.duplex)
+	pages := []io.Reader{r}
+	if sc.splitPhotos {
+		var err error
+		pages, err = sc.split(r, pw.job.Format)
 		if err != nil {
 			return err
 		}
-		defer o.Cleanup()
-
-		if _, err := io.Copy(o, scan.CurrentPage()); err != nil {
+	}
+	for _, r := range pages {
+		fn, err := pw.namer.name(pw.pagenum, side)
+		if err != nil {
 			return err
 		}
-
-		size, err := o.Seek(0, io.SeekCurrent)
+		size, err := writePage(fn, r)
 		if err != nil {
 			return err
 		}
+		log.Printf("wrote %s (%d bytes)", fn, size)
 
-		if err := o.CloseAtomicallyReplace(); err != nil {
-			return err
+		page := hookPage{
+			File: fn,
+			Page: pw.pagenum,
+			Side: side,
+			Size: size,
+		}
+		pw.job.Pages = append(pw.job.Pages, page)
+		if err := sc.pageHook(pw.job, page); err != nil {
+			log.Print(err)
+			pw.hookErrs = append(pw.hookErrs, err)
 		}
 
-		log.Printf("wrote %s (%d bytes)", fn, size)
+		pw.pagenum++
+	}
+	return nil
+}
 
-		pagenum++
+// finish runs the -job_hook once all pages were written and reports hook
+// failures.
+func (pw *pageWriter) finish() error {
+	if err := pw.sc.jobHook(pw.job); err != nil {
+		pw.hookErrs = append(pw.hookErrs, err)
 	}
-	if err := scan.Err(); err != nil {
-		return err
+	return pw.hookErr()
+}
+
+// hookErr reports hook failures, if any.
+func (pw *pageWriter) hookErr() error {
+	if len(pw.hookErrs) > 0 {
+		return fmt.Errorf("%d hook(s) failed, first error: %v", len(pw.hookErrs), pw.hookErrs[0])
 	}
+	return nil
+}
 
+// writePage atomically writes the scan data from r to fn, creating its
+// directory if needed, and returns the number of bytes written.
+func writePage(fn string, r io.Reader) (int64, error) {
+	if err := os.MkdirAll(filepath.Dir(fn), 0700); err != nil {
+		return 0, err
+	}
+
+	o, err := renameio.TempFile("", fn)
+	if err != nil {
+		return 0, err
+	}
+	defer o.Cleanup()
+
+	if _, err := io.Copy(o, r); err != nil {
+		return 0, err
+	}
+
+	size, err := o.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := o.CloseAtomicallyReplace(); err != nil {
+		return 0, err
+	}
+	return size, nil
+}
+
+// optionalInt is a flag.Value for integer flags which are only used when
+// explicitly specified.
+type optionalInt struct {
+	value *int
+}
+
+func (o *optionalInt) String() string {
+	if o == nil || o.value == nil {
+		return ""
+	}
+	return strconv.Itoa(*o.value)
+}
+
+func (o *optionalInt) Set(s string) error {
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		return err
+	}
+	o.value = &i
 	return nil
 }
 
@@ -0,0 +1,121 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/brutella/dnssd"
+	"github.com/google/go-cmp/cmp"
+	"github.com/stapelberg/airscan"
+)
+
+// twoPhotos returns a PNG scan of two photos on a white background.
+func twoPhotos(t *testing.T) []byte {
+	t.Helper()
+	img := image.NewGray(image.Rect(0, 0, 300, 200))
+	draw.Draw(img, img.Bounds(), image.White, image.Point{}, draw.Src)
+	black := image.NewUniform(color.Black)
+	draw.Draw(img, image.Rect(20, 20, 120, 120), black, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(160, 40, 280, 160), black, image.Point{}, draw.Src)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestPageWriterSplitDuplex(t *testing.T) {
+	sc := &airscanner{
+		scanDir:     t.TempDir(),
+		output:      "{page}-{side}.{ext}",
+		collision:   collisionIncrement,
+		source:      "adf",
+		splitPhotos: true,
+		service:     &dnssd.BrowseEntry{Name: "test", Host: "test"},
+	}
+	settings := &airscan.ScanSettings{
+		InputSource:    "Feeder",
+		DocumentFormat: "image/png",
+		Duplex:         true,
+	}
+	pw := sc.newPageWriter(settings, "1", "png")
+	// The front and back of one sheet, each with two photos:
+	scan := twoPhotos(t)
+	for range 2 {
+		if err := pw.write(bytes.NewReader(scan)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := pw.finish(); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, p := range pw.job.Pages {
+		got = append(got, filepath.Base(p.File)+" "+p.Side)
+	}
+	want := []string{
+		"1-front.png front",
+		"2-front.png front",
+		"3-back.png back",
+		"4-back.png back",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected pages: diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestPageWriterSplitNoPhotos(t *testing.T) {
+	sc := &airscanner{
+		scanDir:     t.TempDir(),
+		output:      "{page}.{ext}",
+		collision:   collisionIncrement,
+		source:      "platen",
+		splitPhotos: true,
+		service:     &dnssd.BrowseEntry{Name: "test", Host: "test"},
+	}
+	settings := &airscan.ScanSettings{
+		InputSource:    "Platen",
+		DocumentFormat: "image/png",
+	}
+	pw := sc.newPageWriter(settings, "1", "png")
+	// A blank page is written as scanned instead of being dropped:
+	img := image.NewGray(image.Rect(0, 0, 300, 200))
+	draw.Draw(img, img.Bounds(), image.White, image.Point{}, draw.Src)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	if err := pw.write(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(pw.job.Pages), 1; got != want {
+		t.Fatalf("unexpected number of pages: got %d, want %d", got, want)
+	}
+	b, err := os.ReadFile(pw.job.Pages[0].File)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, buf.Bytes()) {
+		t.Errorf("written page differs from the scanned page")
+	}
+}
@@ -0,0 +1,145 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/google/renameio/v2"
+	"github.com/stapelberg/airscan"
+)
+
+// caps prints a human-readable report of the device’s capabilities.
+func (sc *airscanner) caps() error {
+	cl := sc.client()
+	b, err := cl.ScannerCapabilitiesXML()
+	if err != nil {
+		return err
+	}
+	if sc.capsXML != "" {
+		if err := renameio.WriteFile(sc.capsXML, b, 0644); err != nil {
+			return err
+		}
+		log.Printf("wrote %s (%d bytes)", sc.capsXML, len(b))
+	}
+	var caps airscan.ScannerCapabilities
+	if err := xml.Unmarshal(b, &caps); err != nil {
+		return fmt.Errorf("decoding XML: %v", err)
+	}
+	return writeCapsReport(os.Stdout, &caps)
+}
+
+func writeCapsReport(w io.Writer, caps *airscan.ScannerCapabilities) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "Device:\t%s %s\n", caps.Manufacturer, caps.MakeAndModel)
+	fmt.Fprintf(tw, "Serial number:\t%s\n", caps.SerialNumber)
+	fmt.Fprintf(tw, "UUID:\t%s\n", caps.UUID)
+	fmt.Fprintf(tw, "eSCL version:\t%v\n", caps.Version)
+	fmt.Fprintf(tw, "Admin URL:\t%s\n", caps.AdminURI)
+	if c := caps.Certifications; c.Name != "" {
+		fmt.Fprintf(tw, "Certifications:\t%s %v\n", c.Name, c.Version)
+	}
+
+	sources := []string{"platen"}
+	if caps.Platen == nil {
+		sources = nil
+	}
+	if caps.Adf != nil {
+		sources = append(sources, "adf")
+	}
+	fmt.Fprintf(tw, "Sources:\t%s\n", strings.Join(sources, ", "))
+	if b := caps.BrightnessSupport; b != nil {
+		fmt.Fprintf(tw, "Brightness:\t%d to %d (step %d, normal %d)\n", b.Min, b.Max, b.Step, b.Normal)
+	}
+	if c := caps.ContrastSupport; c != nil {
+		fmt.Fprintf(tw, "Contrast:\t%d to %d (step %d, normal %d)\n", c.Min, c.Max, c.Step, c.Normal)
+	}
+
+	if caps.Platen != nil {
+		fmt.Fprintf(tw, "\nPlaten:\n")
+		writeInputCaps(tw, &caps.Platen.PlatenInputCaps)
+	}
+	if adf := caps.Adf; adf != nil {
+		fmt.Fprintf(tw, "\nADF:\n")
+		fmt.Fprintf(tw, "  Feeder capacity:\t%d sheets\n", adf.FeederCapacity)
+		fmt.Fprintf(tw, "  Duplex:\t%v\n", adf.AdfDuplexInputCaps != nil)
+		if j := adf.Justification; j.XImagePosition != "" || j.YImagePosition != "" {
+			fmt.Fprintf(tw, "  Justification:\t%s, %s\n", j.XImagePosition, j.YImagePosition)
+		}
+		if adf.AdfSimplexInputCaps != nil {
+			writeInputCaps(tw, adf.AdfSimplexInputCaps)
+		}
+		if adf.AdfDuplexInputCaps != nil {
+			fmt.Fprintf(tw, "\nADF (duplex):\n")
+			writeInputCaps(tw, adf.AdfDuplexInputCaps)
+		}
+	}
+	return tw.Flush()
+}
+
+func writeInputCaps(w io.Writer, ic *airscan.InputCaps) {
+	if ic.MaxWidth > 0 || ic.MaxHeight > 0 {
+		fmt.Fprintf(w, "  Minimum size:\t%s\n", formatSize(ic.MinWidth, ic.MinHeight))
+		fmt.Fprintf(w, "  Maximum size:\t%s\n", formatSize(ic.MaxWidth, ic.MaxHeight))
+	}
+	if ic.MaxOpticalXResolution > 0 {
+		fmt.Fprintf(w, "  Max optical resolution:\t%d x %d dpi\n",
+			ic.MaxOpticalXResolution,
+			ic.MaxOpticalYResolution)
+	}
+	if intents := ic.SupportedIntents.Intent; len(intents) > 0 {
+		fmt.Fprintf(w, "  Intents:\t%s\n", strings.Join(intents, ", "))
+	}
+	if dirs := ic.FeedDirections.FeedDirection; len(dirs) > 0 {
+		fmt.Fprintf(w, "  Feed directions:\t%s\n", strings.Join(dirs, ", "))
+	}
+	profiles := ic.SettingProfiles.SettingProfile
+	for idx, p := range profiles {
+		indent := "  "
+		if len(profiles) > 1 {
+			fmt.Fprintf(w, "  Setting profile %d:\n", idx+1)
+			indent = "    "
+		}
+		fmt.Fprintf(w, "%sColor modes:\t%s\n", indent, strings.Join(p.ColorModes.ColorMode, ", "))
+		if ct := p.ContentTypes.ContentType; len(ct) > 0 {
+			fmt.Fprintf(w, "%sContent types:\t%s\n", indent, strings.Join(ct, ", "))
+		}
+		var resolutions []string
+		for _, r := range p.SupportedResolutions.DiscreteResolutions.DiscreteResolution {
+			resolutions = append(resolutions, fmt.Sprintf("%dx%d", r.XResolution, r.YResolution))
+		}
+		if rr := p.SupportedResolutions.ResolutionRange; rr != nil {
+			resolutions = append(resolutions, fmt.Sprintf("%d-%d", rr.XResolutionRange.Min, rr.XResolutionRange.Max))
+		}
+		fmt.Fprintf(w, "%sResolutions:\t%s dpi\n", indent, strings.Join(resolutions, ", "))
+		fmt.Fprintf(w, "%sFormats:\t%s\n", indent, strings.Join(p.DocumentFormats.DocumentFormat, ", "))
+	}
+}
+
+// formatSize formats a width and height given in escl:ThreeHundredthsOfInches
+// in both millimeters and inches.
+func formatSize(width, height int) string {
+	return fmt.Sprintf("%.1f x %.1f mm (%.2f x %.2f in)",
+		airscan.UnitsToMillimeters(width),
+		airscan.UnitsToMillimeters(height),
+		airscan.UnitsToInches(width),
+		airscan.UnitsToInches(height))
+}
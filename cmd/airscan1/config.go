@@ -0,0 +1,116 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// config is the airscan1 configuration file, e.g.:
+//
+//	{
+//	  "host": "BRW405BD8AxxDyz",
+//	  "profiles": {
+//	    "receipts": {"source": "adf", "duplex": false},
+//	    "contracts-color-duplex": {
+//	      "source": "adf",
+//	      "color": "RGB24",
+//	      "format": "application/pdf",
+//	      "duplex": true
+//	    }
+//	  }
+//	}
+//
+// Profiles map flag names to values.
+type config struct {
+	// Host is the default device, used when -host is not specified. An
+	// explicit -host= (empty) discovers all devices instead.
+	Host string `json:"host"`
+
+	Profiles map[string]map[string]interface{} `json:"profiles"`
+}
+
+// defaultConfigPath returns $XDG_CONFIG_HOME/airscan/config.json (or the
+// platform equivalent), or the empty string if there is no config directory.
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "airscan", "config.json")
+}
+
+// loadConfig reads the configuration file at path. A missing file is only an
+// error if mustExist is true.
+func loadConfig(path string, mustExist bool) (*config, error) {
+	var cfg config
+	if path == "" {
+		return &cfg, nil
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) && !mustExist {
+			return &cfg, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return &cfg, nil
+}
+
+// apply sets the default host and the flags of the named profile (if any) on
+// fs, unless they were explicitly specified on the command line.
+func (cfg *config) apply(fs *flag.FlagSet, profile string) error {
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+
+	if cfg.Host != "" && !explicit["host"] {
+		if err := fs.Set("host", cfg.Host); err != nil {
+			return err
+		}
+	}
+
+	if profile == "" {
+		return nil
+	}
+	values, ok := cfg.Profiles[profile]
+	if !ok {
+		names := make([]string, 0, len(cfg.Profiles))
+		for name := range cfg.Profiles {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("profile %q not found in config file, available profiles: %q", profile, names)
+	}
+	for name, value := range values {
+		if fs.Lookup(name) == nil {
+			return fmt.Errorf("profile %q: unknown flag %q", profile, name)
+		}
+		if explicit[name] {
+			continue // command line flags take precedence
+		}
+		if err := fs.Set(name, fmt.Sprint(value)); err != nil {
+			return fmt.Errorf("profile %q: flag %q: %v", profile, name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+const testConfig = `{
+  "host": "BRW405BD8AxxDyz",
+  "profiles": {
+    "receipts": {"source": "adf", "duplex": false, "resolution": 200},
+    "typo": {"sauce": "adf"},
+    "invalid": {"resolution": "high"}
+  }
+}`
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+	if err := os.WriteFile(path, []byte(testConfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := loadConfig(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := cfg.Host, "BRW405BD8AxxDyz"; got != want {
+		t.Errorf("unexpected host: got %q, want %q", got, want)
+	}
+	if got, want := len(cfg.Profiles), 3; got != want {
+		t.Errorf("unexpected number of profiles: got %d, want %d", got, want)
+	}
+
+	missing := filepath.Join(dir, "missing.json")
+	if _, err := loadConfig(missing, false); err != nil {
+		t.Errorf("loadConfig(missing, false): %v", err)
+	}
+	if _, err := loadConfig(missing, true); err == nil {
+		t.Errorf("loadConfig(missing, true) unexpectedly succeeded")
+	}
+
+	invalid := filepath.Join(dir, "invalid.json")
+	if err := os.WriteFile(invalid, []byte(`{"host": `), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadConfig(invalid, false); err == nil {
+		t.Errorf("loadConfig(invalid) unexpectedly succeeded")
+	}
+}
+
+// applyFlags parses args into a FlagSet with a subset of airscan1’s flags,
+// applies cfg and returns the resulting flag values.
+func applyFlags(cfg *config, profile string, args ...string) (map[string]string, error) {
+	fs := flag.NewFlagSet("airscan1", flag.ContinueOnError)
+	fs.String("host", "", "")
+	fs.String("source", "platen", "")
+	fs.Bool("duplex", true, "")
+	fs.Int("resolution", 300, "")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if err := cfg.apply(fs, profile); err != nil {
+		return nil, err
+	}
+	values := make(map[string]string)
+	fs.VisitAll(func(f *flag.Flag) { values[f.Name] = f.Value.String() })
+	return values, nil
+}
+
+func TestApplyConfig(t *testing.T) {
+	cfg := &config{}
+	if err := json.Unmarshal([]byte(testConfig), cfg); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		name    string
+		profile string
+		args    []string
+		want    map[string]string
+	}{
+		{
+			name: "default host",
+			want: map[string]string{
+				"host":       "BRW405BD8AxxDyz",
+				"source":     "platen",
+				"duplex":     "true",
+				"resolution": "300",
+			},
+		},
+		{
+			name: "explicit host",
+			args: []string{"-host=HPFXXXXXXXXXXXX"},
+			want: map[string]string{
+				"host":       "HPFXXXXXXXXXXXX",
+				"source":     "platen",
+				"duplex":     "true",
+				"resolution": "300",
+			},
+		},
+		{
+			name: "explicit empty host discovers",
+			args: []string{"-host="},
+			want: map[string]string{
+				"host":       "",
+				"source":     "platen",
+				"duplex":     "true",
+				"resolution": "300",
+			},
+		},
+		{
+			name:    "profile",
+			profile: "receipts",
+			want: map[string]string{
+				"host":       "BRW405BD8AxxDyz",
+				"source":     "adf",
+				"duplex":     "false",
+				"resolution": "200",
+			},
+		},
+		{
+			name:    "command line takes precedence over profile",
+			profile: "receipts",
+			args:    []string{"-resolution=600", "-duplex=true"},
+			want: map[string]string{
+				"host":       "BRW405BD8AxxDyz",
+				"source":     "adf",
+				"duplex":     "true",
+				"resolution": "600",
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := applyFlags(cfg, tt.profile, tt.args...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("unexpected flag values: diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	for _, profile := range []string{"missing", "typo", "invalid"} {
+		if _, err := applyFlags(cfg, profile); err == nil {
+			t.Errorf("apply(profile %q) unexpectedly succeeded", profile)
+		}
+	}
+}
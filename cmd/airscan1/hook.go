@@ -0,0 +1,117 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// hookPage describes one written page to -page_hook and -job_hook commands.
+type hookPage struct {
+	File string `json:"file"`
+	Page int    `json:"page"`
+	Side string `json:"side"`
+	Size int64  `json:"size"`
+}
+
+// hookJob describes the scan job to -page_hook and -job_hook commands.
+type hookJob struct {
+	Device string     `json:"device"`
+	Host   string     `json:"host"`
+	Job    string     `json:"job"`
+	Source string     `json:"source"`
+	Format string     `json:"format"`
+	Pages  []hookPage `json:"pages,omitempty"`
+}
+
+// runHook runs command via the shell, passing metadata in AIRSCAN_*
+// environment variables and as JSON on stdin.
+func runHook(kind, command string, env []string, stdin interface{}) error {
+	b, err := json.Marshal(stdin)
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command("/bin/sh", "-c", command)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdin = bytes.NewReader(b)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return fmt.Errorf("%s hook %q failed with exit code %d", kind, command, exitErr.ExitCode())
+		}
+		return fmt.Errorf("%s hook %q: %v", kind, command, err)
+	}
+	return nil
+}
+
+func (j *hookJob) env() []string {
+	return []string{
+		"AIRSCAN_DEVICE=" + j.Device,
+		"AIRSCAN_HOST=" + j.Host,
+		"AIRSCAN_JOB=" + j.Job,
+		"AIRSCAN_SOURCE=" + j.Source,
+		"AIRSCAN_FORMAT=" + j.Format,
+	}
+}
+
+// pageHook runs the -page_hook command (if any) for page p.
+func (sc *airscanner) pageHook(job *hookJob, p hookPage) error {
+	if sc.pageHookCmd == "" {
+		return nil
+	}
+	env := append(job.env(),
+		"AIRSCAN_FILE="+p.File,
+		"AIRSCAN_PAGE="+strconv.Itoa(p.Page),
+		"AIRSCAN_SIDE="+p.Side,
+		"AIRSCAN_SIZE="+strconv.FormatInt(p.Size, 10))
+	jobOnly := *job
+	jobOnly.Pages = nil // only describe the current page
+	stdin := struct {
+		hookJob
+		hookPage
+	}{jobOnly, p}
+	if sc.debug {
+		log.Printf("running page hook for %s", p.File)
+	}
+	return runHook("page", sc.pageHookCmd, env, stdin)
+}
+
+// jobHook runs the -job_hook command (if any) once all pages were written.
+func (sc *airscanner) jobHook(job *hookJob) error {
+	if sc.jobHookCmd == "" || len(job.Pages) == 0 {
+		return nil
+	}
+	files := make([]string, len(job.Pages))
+	for idx, p := range job.Pages {
+		files[idx] = p.File
+	}
+	env := append(job.env(),
+		"AIRSCAN_FILES="+strings.Join(files, "\n"),
+		"AIRSCAN_PAGES="+strconv.Itoa(len(job.Pages)))
+	if sc.debug {
+		log.Printf("running job hook for %d pages", len(job.Pages))
+	}
+	return runHook("job", sc.jobHookCmd, env, job)
+}
@@ -0,0 +1,188 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// outputFields are the values available in -output templates.
+type outputFields struct {
+	Time   time.Time // start of the scan job
+	Device string
+	Job    string
+	Page   int
+	Side   string // front or back
+	Source string // platen or adf
+	Ext    string
+}
+
+// pageFormat matches the zero-padded widths which {page:format} supports.
+var pageFormat = regexp.MustCompile(`^0[1-9][0-9]?$`)
+
+// expandOutput replaces each {field} or {field:format} in tmpl with the
+// corresponding value from f. The only supported format is a zero-padded
+// width for page numbers, e.g. {page:03}.
+func expandOutput(tmpl string, f outputFields) (string, error) {
+	var b strings.Builder
+	rest := tmpl
+	for {
+		start := strings.IndexByte(rest, '{')
+		if start == -1 {
+			b.WriteString(rest)
+			break
+		}
+		end := strings.IndexByte(rest[start:], '}')
+		if end == -1 {
+			return "", fmt.Errorf("output template %q: unterminated {", tmpl)
+		}
+		end += start
+		b.WriteString(rest[:start])
+		name, format, _ := strings.Cut(rest[start+1:end], ":")
+		var value string
+		switch name {
+		case "date":
+			value = f.Time.Format("2006-01-02")
+		case "time":
+			value = f.Time.Format("15-04-05")
+		case "timestamp":
+			value = f.Time.Format("20060102-150405")
+		case "device":
+			value = sanitizeFilename(f.Device)
+		case "job":
+			value = sanitizeFilename(f.Job)
+		case "page":
+			value = strconv.Itoa(f.Page)
+			if format != "" {
+				if !pageFormat.MatchString(format) {
+					return "", fmt.Errorf("output template %q: invalid page format %q, want e.g. 03", tmpl, format)
+				}
+				value = fmt.Sprintf("%"+format+"d", f.Page)
+			}
+		case "side":
+			value = f.Side
+		case "source":
+			value = f.Source
+		case "ext":
+			value = f.Ext
+		default:
+			return "", fmt.Errorf("output template %q: unknown field %q, want one of date, time, timestamp, device, job, page, side, source, ext", tmpl, name)
+		}
+		if format != "" && name != "page" {
+			return "", fmt.Errorf("output template %q: field %q does not support a format", tmpl, name)
+		}
+		b.WriteString(value)
+		rest = rest[end+1:]
+	}
+	// Field values are sanitized, but the template itself could still
+	// reference parent directories:
+	fn := b.String()
+	if !filepath.IsLocal(fn) {
+		return "", fmt.Errorf("output template %q: file name %q is outside of -scan_dir", tmpl, fn)
+	}
+	return fn, nil
+}
+
+// exampleFields are used to verify -output templates before scanning.
+var exampleFields = outputFields{
+	Time:   time.Date(2020, 1, 1, 12, 0, 0, 0, time.Local),
+	Device: "device",
+	Job:    "job",
+	Page:   1,
+	Side:   "front",
+	Source: "platen",
+	Ext:    "jpg",
+}
+
+// sanitizeFilename makes s usable as (part of) a file name.
+func sanitizeFilename(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '/', '\\', ':', ' ':
+			return '_'
+		}
+		return r
+	}, s)
+}
+
+// Collision policies for when an output file already exists:
+const (
+	collisionIncrement = "increment"
+	collisionOverwrite = "overwrite"
+	collisionFail      = "fail"
+)
+
+// outputNamer assigns file names to the pages of one scan job.
+type outputNamer struct {
+	dir       string
+	tmpl      string
+	collision string
+	fields    outputFields
+
+	// offset is added to the page number when incrementing to avoid
+	// collisions, so that subsequent pages continue the numbering.
+	offset int
+}
+
+// side returns whether the specified scanned page (1-based, within the job) is
+// the front or back of a sheet.
+func side(page int, duplex bool) string {
+	if duplex && page%2 == 0 {
+		return "back"
+	}
+	return "front"
+}
+
+// name returns the file name for the specified page (1-based, within the job)
+// on the specified side of the sheet.
+func (n *outputNamer) name(page int, side string) (string, error) {
+	f := n.fields
+	f.Side = side
+	for suffix := 1; ; suffix++ {
+		f.Page = page + n.offset
+		fn, err := expandOutput(n.tmpl, f)
+		if err != nil {
+			return "", err
+		}
+		fn = filepath.Join(n.dir, fn)
+		if n.collision == collisionIncrement && suffix > 1 && !strings.Contains(n.tmpl, "{page") {
+			ext := filepath.Ext(fn)
+			fn = fmt.Sprintf("%s-%d%s", strings.TrimSuffix(fn, ext), suffix, ext)
+		}
+		_, err = os.Stat(fn)
+		if os.IsNotExist(err) {
+			return fn, nil
+		}
+		if err != nil {
+			return "", err
+		}
+		// file exists
+		switch n.collision {
+		case collisionOverwrite:
+			return fn, nil
+		case collisionFail:
+			return "", fmt.Errorf("output file %s already exists (use -collision=%s or -collision=%s)", fn, collisionIncrement, collisionOverwrite)
+		}
+		if strings.Contains(n.tmpl, "{page") {
+			n.offset++
+		}
+	}
+}
@@ -0,0 +1,200 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestExpandOutput(t *testing.T) {
+	fields := outputFields{
+		Time:   time.Date(2020, 6, 7, 8, 9, 10, 0, time.Local),
+		Device: "Brother MFC-L2750DW",
+		Job:    "jobs/42",
+		Page:   7,
+		Side:   "back",
+		Source: "adf",
+		Ext:    "pdf",
+	}
+	for _, tt := range []struct {
+		tmpl string
+		want string
+	}{
+		{"page{page}.{ext}", "page7.pdf"},
+		{"{date}/{time}-{page:03}.{ext}", "2020-06-07/08-09-10-007.pdf"},
+		{"{timestamp}-{side}-{source}", "20200607-080910-back-adf"},
+		// Field values must not create directories or contain spaces:
+		{"{device}/{job}", "Brother_MFC-L2750DW/jobs_42"},
+		{"no fields", "no fields"},
+	} {
+		t.Run(tt.tmpl, func(t *testing.T) {
+			got, err := expandOutput(tt.tmpl, fields)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("expandOutput(%q) = %q, want %q", tt.tmpl, got, tt.want)
+			}
+		})
+	}
+
+	for _, tmpl := range []string{
+		"page{page",
+		"{pages}",
+		"{device:03}",
+		"{page:x}",
+		"{page:-5}",
+		"{page:+3}",
+		"{page:3}",
+		"{page:0}",
+		"{page:0100}",
+		"../{device}/page{page}.{ext}",
+		"receipts/../../page{page}.{ext}",
+		"/etc/page{page}",
+	} {
+		if _, err := expandOutput(tmpl, fields); err == nil {
+			t.Errorf("expandOutput(%q) unexpectedly succeeded", tmpl)
+		}
+	}
+
+	// Sanitized field values cannot escape the directory either:
+	dotdot := fields
+	dotdot.Device = ".."
+	if _, err := expandOutput("{device}/page{page}", dotdot); err == nil {
+		t.Errorf("expandOutput with device %q unexpectedly succeeded", dotdot.Device)
+	}
+}
+
+func TestSanitizeFilename(t *testing.T) {
+	for _, tt := range []struct {
+		in   string
+		want string
+	}{
+		{"Brother MFC-L2750DW", "Brother_MFC-L2750DW"},
+		{`a/b\c:d`, "a_b_c_d"},
+		{"plain", "plain"},
+	} {
+		if got := sanitizeFilename(tt.in); got != tt.want {
+			t.Errorf("sanitizeFilename(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+// namePages returns the file names for pages 1 to n, creating each file (like
+// writePage would) before naming the next page.
+func namePages(t *testing.T, n *outputNamer, pages int, duplex bool) []string {
+	t.Helper()
+	var names []string
+	for page := 1; page <= pages; page++ {
+		fn, err := n.name(page, side(page, duplex))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fn, nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, filepath.Base(fn))
+	}
+	return names
+}
+
+func TestOutputNamer(t *testing.T) {
+	for _, tt := range []struct {
+		name      string
+		tmpl      string
+		collision string
+		existing  []string
+		duplex    bool
+		want      []string
+	}{
+		{
+			name:      "no collision",
+			tmpl:      "page{page}.{ext}",
+			collision: collisionIncrement,
+			want:      []string{"page1.jpg", "page2.jpg"},
+		},
+		{
+			name:      "increment page number",
+			tmpl:      "page{page}.{ext}",
+			collision: collisionIncrement,
+			existing:  []string{"page1.jpg", "page2.jpg"},
+			want:      []string{"page3.jpg", "page4.jpg"},
+		},
+		{
+			name:      "increment suffix",
+			tmpl:      "{source}.{ext}",
+			collision: collisionIncrement,
+			existing:  []string{"adf.jpg"},
+			want:      []string{"adf-2.jpg", "adf-3.jpg"},
+		},
+		{
+			name:      "overwrite",
+			tmpl:      "page{page}.{ext}",
+			collision: collisionOverwrite,
+			existing:  []string{"page1.jpg"},
+			want:      []string{"page1.jpg", "page2.jpg"},
+		},
+		{
+			name:      "duplex sides",
+			tmpl:      "{page}-{side}.{ext}",
+			collision: collisionIncrement,
+			duplex:    true,
+			want:      []string{"1-front.jpg", "2-back.jpg", "3-front.jpg"},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for _, fn := range tt.existing {
+				if err := os.WriteFile(filepath.Join(dir, fn), nil, 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			n := &outputNamer{
+				dir:       dir,
+				tmpl:      tt.tmpl,
+				collision: tt.collision,
+				fields: outputFields{
+					Source: "adf",
+					Ext:    "jpg",
+				},
+			}
+			got := namePages(t, n, len(tt.want), tt.duplex)
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("unexpected file names: diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestOutputNamerFail(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "page1.jpg"), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	n := &outputNamer{
+		dir:       dir,
+		tmpl:      "page{page}.{ext}",
+		collision: collisionFail,
+		fields:    outputFields{Ext: "jpg"},
+	}
+	if _, err := n.name(1, "front"); err == nil {
+		t.Fatalf("name unexpectedly succeeded for existing file")
+	}
+}
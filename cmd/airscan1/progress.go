@@ -0,0 +1,78 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/stapelberg/airscan"
+)
+
+// isTerminal returns whether f is a terminal (as opposed to e.g. a file or
+// pipe), in which case progress lines can be updated in place.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+func humanBytes(b float64) string {
+	const unit = 1024
+	if b < unit {
+		return fmt.Sprintf("%.0f B", b)
+	}
+	div, exp := float64(unit), 0
+	for n := b / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", b/div, "KMGTPE"[exp])
+}
+
+// progressPrinter returns a function which prints (at most every 100ms) a
+// progress line per page to w, updating it in place.
+func progressPrinter(w io.Writer) func(airscan.Progress) {
+	var last time.Time
+	return func(p airscan.Progress) {
+		if !p.Done && time.Since(last) < 100*time.Millisecond {
+			return
+		}
+		last = time.Now()
+		line := fmt.Sprintf("page %d: %s", p.Page, humanBytes(float64(p.BytesReceived)))
+		if p.ContentLength >= 0 {
+			line += fmt.Sprintf(" of %s (%d%%)",
+				humanBytes(float64(p.ContentLength)),
+				p.BytesReceived*100/max64(p.ContentLength, 1))
+		}
+		line += fmt.Sprintf(", %s/s", humanBytes(p.Throughput()))
+		if remaining := p.Remaining(); remaining >= 0 && !p.Done {
+			line += fmt.Sprintf(", %v remaining", remaining.Round(time.Second))
+		}
+		// \033[K clears the rest of the line:
+		fmt.Fprintf(w, "\r%s\033[K", line)
+		if p.Done {
+			fmt.Fprintf(w, " (%v)\n", p.Elapsed.Round(time.Millisecond))
+		}
+	}
+}
+
+func max64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,64 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log"
+
+	"github.com/stapelberg/airscan/photosplit"
+)
+
+// split detects the photos in the scanned page r and returns one encoded
+// image (in the same format) per photo. If no photos are detected, the scanned
+// page is returned unmodified, so that the scan is not lost.
+func (sc *airscanner) split(r io.Reader, format string) ([]io.Reader, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(bytes.NewReader(b))
+	if err != nil {
+		return nil, fmt.Errorf("decoding scanned page: %v", err)
+	}
+	photos := photosplit.Split(img, photosplit.Options{
+		MinSize:   sc.splitMinSize,
+		Threshold: uint8(sc.splitThreshold),
+	})
+	if len(photos) == 0 {
+		log.Printf("warning: detected no photos, writing the scanned page instead (adjust -split_threshold or -split_min_size?)")
+		return []io.Reader{bytes.NewReader(b)}, nil
+	}
+	log.Printf("detected %d photo(s)", len(photos))
+	result := make([]io.Reader, 0, len(photos))
+	for _, photo := range photos {
+		var buf bytes.Buffer
+		if format == "image/png" {
+			err = png.Encode(&buf, photo)
+		} else {
+			err = jpeg.Encode(&buf, photo, &jpeg.Options{Quality: 95})
+		}
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, &buf)
+	}
+	return result, nil
+}
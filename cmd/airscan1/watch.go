@@ -0,0 +1,93 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"iter"
+	"log"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/stapelberg/airscan"
+)
+
+// watch scans each stack of documents inserted into the ADF, until
+// interrupted.
+func (sc *airscanner) watch() error {
+	cl := sc.client()
+
+	settings, suffix, err := sc.scanSettings(cl)
+	if err != nil {
+		return err
+	}
+
+	var quiet *airscan.QuietHours
+	if sc.quietHours != "" {
+		quiet, err = airscan.ParseQuietHours(sc.quietHours)
+		if err != nil {
+			return err
+		}
+	}
+
+	ctx, canc := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer canc()
+
+	w := &airscan.Watcher{
+		Scanner:     cl,
+		Settings:    settings,
+		Interval:    sc.watchInterval,
+		Debounce:    sc.watchDebounce,
+		QuietHours:  quiet,
+		MaxFailures: sc.maxFailures,
+		Debug:       sc.debug,
+		Job: func(ctx context.Context, pages iter.Seq2[*airscan.Page, error]) error {
+			// The scan job is only created once the iteration starts, so
+			// wait for our turn first:
+			release, err := sc.waitTurn(ctx, cl)
+			if err != nil {
+				return err
+			}
+			defer release()
+
+			start := time.Now()
+			// The pages iterator does not expose the device’s job id, so
+			// identify scan jobs by their start time:
+			pw := sc.newPageWriter(settings, start.Format("20060102-150405"), suffix)
+			log.Printf("ADF loaded, scanning")
+			for page, err := range pages {
+				if err != nil {
+					return err
+				}
+				if err := pw.write(bytes.NewReader(page.Data)); err != nil {
+					return err
+				}
+			}
+			if err := pw.finish(); err != nil {
+				return err
+			}
+			log.Printf("scan done in %v, waiting for the next stack", time.Since(start))
+			return nil
+		},
+	}
+	log.Printf("waiting for documents in the ADF of %q (press Ctrl-C to stop)", humanDeviceName(*sc.service))
+	if err := w.Run(ctx); err != nil && !errors.Is(err, context.Canceled) {
+		return err
+	}
+	return nil
+}
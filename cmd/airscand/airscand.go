@@ -0,0 +1,112 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Program airscand is a scan server: it discovers AirScan-compatible scanners
+// in the local network and exposes them via an HTTP/REST API, so that devices
+// which cannot speak eSCL (e.g. browsers and phones) can trigger scans.
+//
+// Scan jobs are queued per scanner, i.e. concurrent requests are processed one
+// after the other instead of failing because the scanner is busy.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/brutella/dnssd"
+	"github.com/davecgh/go-spew/spew"
+	"github.com/stapelberg/airscan"
+	"github.com/stapelberg/airscan/internal/daemon"
+	"github.com/stapelberg/airscan/queue"
+)
+
+func airscand() error {
+	var (
+		listen = flag.String("listen",
+			"localhost:8080",
+			"[host]:port to serve the HTTP API on")
+
+		debug = flag.Bool("debug",
+			false,
+			"if true, print extra debug output")
+
+		skipCertVerify = flag.Bool("skip_cert_verify",
+			false,
+			"if true, skip TLS certificate verification")
+
+		jobTTL = flag.Duration("job_ttl",
+			1*time.Hour,
+			"how long to keep finished scan jobs (including their pages) in memory")
+
+		lockDir = flag.String("lock_dir",
+			queue.DefaultLockDir,
+			"directory for lock files, which serialize scan jobs with other programs (e.g. airscan1) on this host. If empty, scan jobs are only serialized within airscand")
+
+		waitIdle = flag.Duration("wait_idle",
+			5*time.Minute,
+			"how long a scan job waits for a busy scanner (e.g. used by a different host) to become idle")
+
+		allowedHosts = flag.String("allowed_hosts",
+			"",
+			"comma-separated list of additional host names (e.g. of a reverse proxy) under which the HTTP API may be accessed. IP addresses, localhost and this machine’s host name are always allowed")
+	)
+	flag.Parse()
+
+	reg := newRegistry(*debug, *skipCertVerify, queue.New(*lockDir), *waitIdle)
+	jobs := newJobStore(*jobTTL)
+
+	addFn := func(service dnssd.BrowseEntry) {
+		if *debug {
+			log.Printf("DNSSD service discovered: %v", spew.Sdump(service))
+		}
+		dev := reg.add(service)
+		log.Printf("device %q discovered (id %q)", dev.name, dev.id)
+	}
+
+	rmvFn := func(service dnssd.BrowseEntry) {
+		if dev := reg.remove(service); dev != nil {
+			log.Printf("device %q vanished", dev.name)
+		}
+	}
+
+	go func() {
+		// LookupType only returns when its context is canceled, or when
+		// discovery fails entirely:
+		if err := dnssd.LookupType(context.Background(), airscan.ServiceName, addFn, rmvFn); err != nil {
+			log.Printf("discovery failed: %v", err)
+		}
+	}()
+
+	srv := &server{
+		reg:   reg,
+		jobs:  jobs,
+		hosts: defaultHosts(strings.FieldsFunc(*allowedHosts, func(r rune) bool { return r == ',' })),
+	}
+	ln, err := net.Listen("tcp", *listen)
+	if err != nil {
+		return err
+	}
+	log.Printf("serving HTTP API on %s", ln.Addr())
+	return daemon.Serve(ln, srv.handler(), nil)
+}
+
+func main() {
+	if err := airscand(); err != nil {
+		log.Fatal(err)
+	}
+}
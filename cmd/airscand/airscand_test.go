@@ -0,0 +1,453 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/brutella/dnssd"
+	"github.com/stapelberg/airscan/queue"
+	"github.com/stapelberg/airscan/virtual"
+)
+
+const testJobRequest = `{"source": "adf", "resolution": 75, "duplex": false}`
+
+// uniform returns a page of 85x110 pixels (8.5x11 inches at 10 dpi) in color c.
+func uniform(c color.Color) virtual.Page {
+	return func() (image.Image, error) {
+		img := image.NewRGBA(image.Rect(0, 0, 85, 110))
+		for y := 0; y < 110; y++ {
+			for x := 0; x < 85; x++ {
+				img.Set(x, y, c)
+			}
+		}
+		return img, nil
+	}
+}
+
+type testServer struct {
+	*httptest.Server
+	jobs  *jobStore
+	queue *queue.Queue
+	dev   *device
+}
+
+// newTestServer starts airscand with one device, a virtual scanner with the
+// specified number of pages, which is served by wrap(scanner).
+func newTestServer(t *testing.T, ttl time.Duration, pages int, wrap func(http.Handler) http.Handler) *testServer {
+	t.Helper()
+	var handler http.Handler = &virtual.Scanner{
+		Resolution: 10,
+		Pages: func() ([]virtual.Page, error) {
+			var p []virtual.Page
+			for range pages {
+				p = append(p, uniform(color.White))
+			}
+			return p, nil
+		},
+	}
+	if wrap != nil {
+		handler = wrap(handler)
+	}
+	scanner := httptest.NewServer(handler)
+	t.Cleanup(scanner.Close)
+	host, portStr, err := net.SplitHostPort(scanner.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q := queue.New("") // do not interfere with other processes
+	reg := newRegistry(false, false, q, 10*time.Second)
+	dev := reg.add(dnssd.BrowseEntry{
+		Name: "test scanner",
+		Host: host,
+		Port: port,
+	})
+	srv := &server{
+		reg:   reg,
+		jobs:  newJobStore(ttl),
+		hosts: defaultHosts(nil),
+	}
+	ts := httptest.NewServer(srv.handler())
+	t.Cleanup(ts.Close)
+	return &testServer{
+		Server: ts,
+		jobs:   srv.jobs,
+		queue:  q,
+		dev:    dev,
+	}
+}
+
+// do sends a request to the API and decodes the JSON response into v (unless
+// nil). It returns the HTTP status code.
+func (ts *testServer) do(t *testing.T, req *http.Request, v interface{}) int {
+	t.Helper()
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != nil && resp.StatusCode < 300 {
+		if err := json.Unmarshal(b, v); err != nil {
+			t.Fatalf("%s %s: %v (body: %s)", req.Method, req.URL, err, b)
+		}
+	}
+	return resp.StatusCode
+}
+
+func (ts *testServer) request(t *testing.T, method, path, body string) *http.Request {
+	t.Helper()
+	var r io.Reader
+	if body != "" {
+		r = strings.NewReader(body)
+	}
+	req, err := http.NewRequest(method, ts.URL+path, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return req
+}
+
+func (ts *testServer) createJob(t *testing.T) jobJSON {
+	t.Helper()
+	var j jobJSON
+	req := ts.request(t, "POST", "/api/devices/"+ts.dev.id+"/jobs", testJobRequest)
+	if got, want := ts.do(t, req, &j), http.StatusCreated; got != want {
+		t.Fatalf("creating job: unexpected status code: got %d, want %d", got, want)
+	}
+	return j
+}
+
+func (ts *testServer) getJob(t *testing.T, id string) (jobJSON, int) {
+	t.Helper()
+	var j jobJSON
+	code := ts.do(t, ts.request(t, "GET", "/api/jobs/"+id, ""), &j)
+	return j, code
+}
+
+// waitState polls the job until it is in state want (or pages is reached, if
+// want is stateScanning).
+func (ts *testServer) waitState(t *testing.T, id, want string, pages int) jobJSON {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		j, code := ts.getJob(t, id)
+		if code != http.StatusOK {
+			t.Fatalf("polling job: unexpected status code %d", code)
+		}
+		if j.State == want && j.Pages >= pages {
+			return j
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job did not reach state %q (%d pages): %+v", want, pages, j)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func (ts *testServer) cancelJob(t *testing.T, id string) jobJSON {
+	t.Helper()
+	var j jobJSON
+	if got, want := ts.do(t, ts.request(t, "DELETE", "/api/jobs/"+id, ""), &j), http.StatusOK; got != want {
+		t.Fatalf("canceling job: unexpected status code: got %d, want %d", got, want)
+	}
+	return j
+}
+
+func TestScanJob(t *testing.T) {
+	ts := newTestServer(t, time.Hour, 3, nil)
+	created := ts.createJob(t)
+	if created.State != stateQueued && created.State != stateScanning {
+		t.Errorf("unexpected state of new job: %q", created.State)
+	}
+	j := ts.waitState(t, created.ID, stateCompleted, 0)
+	if got, want := j.Pages, 3; got != want {
+		t.Errorf("unexpected number of pages: got %d, want %d", got, want)
+	}
+	if j.Started == nil || j.Finished == nil {
+		t.Errorf("started or finished time missing: %+v", j)
+	}
+
+	var jobs []jobJSON
+	if got, want := ts.do(t, ts.request(t, "GET", "/api/jobs", ""), &jobs), http.StatusOK; got != want {
+		t.Fatalf("listing jobs: unexpected status code: got %d, want %d", got, want)
+	}
+	if len(jobs) != 1 || jobs[0].ID != created.ID {
+		t.Errorf("unexpected jobs: %+v", jobs)
+	}
+
+	resp, err := ts.Client().Get(ts.URL + "/api/jobs/" + created.ID + "/pages/2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if got, want := resp.Header.Get("Content-Type"), "image/jpeg"; got != want {
+		t.Errorf("unexpected Content-Type: got %q, want %q", got, want)
+	}
+	if _, err := jpeg.Decode(resp.Body); err != nil {
+		t.Errorf("decoding page: %v", err)
+	}
+
+	resp, err = ts.Client().Get(ts.URL + "/api/jobs/" + created.ID + "/pdf?pages=3,1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(b, []byte("%PDF")) {
+		t.Errorf("unexpected PDF contents: %.20q", b)
+	}
+	if got := resp.Header.Get("Last-Modified"); got != "" {
+		t.Errorf("unexpected Last-Modified header %q for PDF", got)
+	}
+
+	for _, path := range []string{"/pages/4", "/pages/0", "/pdf?pages=4"} {
+		code := ts.do(t, ts.request(t, "GET", "/api/jobs/"+created.ID+path, ""), nil)
+		if code == http.StatusOK {
+			t.Errorf("GET %s unexpectedly succeeded", path)
+		}
+	}
+
+	// Finished jobs cannot be canceled:
+	if got, want := ts.do(t, ts.request(t, "DELETE", "/api/jobs/"+created.ID, ""), nil), http.StatusConflict; got != want {
+		t.Errorf("canceling finished job: unexpected status code: got %d, want %d", got, want)
+	}
+}
+
+func TestCancelQueued(t *testing.T) {
+	ts := newTestServer(t, time.Hour, 1, nil)
+
+	// Occupy the device, like a different scan job would:
+	lock, err := ts.queue.Enqueue(ts.dev.id).Wait(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lock.Release()
+
+	created := ts.createJob(t)
+	j, _ := ts.getJob(t, created.ID)
+	if j.State != stateQueued || j.Position == nil || *j.Position != 1 {
+		t.Fatalf("job unexpectedly not queued at position 1: %+v", j)
+	}
+	if got := ts.cancelJob(t, created.ID).State; got != stateCanceled {
+		t.Errorf("unexpected state after cancel: got %q, want %q", got, stateCanceled)
+	}
+	j = ts.waitState(t, created.ID, stateCanceled, 0)
+	if j.Pages != 0 || j.Started != nil {
+		t.Errorf("canceled job unexpectedly scanned: %+v", j)
+	}
+}
+
+// blockAfterFirstPage returns a handler which serves the first page of each
+// scan job, then blocks further NextDocument requests until they are
+// canceled. scanned is closed once the first page was served.
+func blockAfterFirstPage(scanned chan struct{}) func(http.Handler) http.Handler {
+	var (
+		mu       sync.Mutex
+		requests int
+		once     sync.Once
+	)
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if strings.HasSuffix(r.URL.Path, "/NextDocument") {
+				mu.Lock()
+				requests++
+				first := requests == 1
+				mu.Unlock()
+				if !first {
+					once.Do(func() { close(scanned) })
+					<-r.Context().Done()
+					return
+				}
+			}
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+func TestCancelScanning(t *testing.T) {
+	scanned := make(chan struct{})
+	ts := newTestServer(t, time.Hour, 3, blockAfterFirstPage(scanned))
+	created := ts.createJob(t)
+	<-scanned
+	ts.waitState(t, created.ID, stateScanning, 1)
+
+	if got := ts.cancelJob(t, created.ID).State; got != stateCanceled {
+		t.Errorf("unexpected state after cancel: got %q, want %q", got, stateCanceled)
+	}
+	j := ts.waitState(t, created.ID, stateCanceled, 0)
+	if j.Finished == nil {
+		// Wait for the job’s goroutine to record when it finished:
+		deadline := time.Now().Add(10 * time.Second)
+		for j.Finished == nil && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+			j, _ = ts.getJob(t, created.ID)
+		}
+	}
+	if j.Finished == nil {
+		t.Errorf("canceled job did not finish: %+v", j)
+	}
+	// Pages scanned before canceling remain available:
+	if got, want := j.Pages, 1; got != want {
+		t.Errorf("unexpected number of pages: got %d, want %d", got, want)
+	}
+}
+
+func TestCancelAfterAcquire(t *testing.T) {
+	ts := newTestServer(t, time.Hour, 1, nil)
+	caps, err := ts.dev.client().ScannerCapabilities()
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := defaultJobRequest()
+	settings, err := req.settings(caps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	j := newJob(ts.dev.id, settings, ts.queue.Enqueue(ts.dev.id))
+	// The job was canceled after run acquired the device, but before it
+	// started scanning:
+	j.state = stateCanceled
+	j.run(ts.dev)
+	if done, _ := j.done(); !done {
+		t.Errorf("canceled job is not done, so it would never expire")
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	ts := newTestServer(t, time.Millisecond, 1, nil)
+	created := ts.createJob(t)
+	ts.waitState(t, created.ID, stateCompleted, 1)
+	time.Sleep(10 * time.Millisecond)
+	ts.jobs.list() // expires jobs
+	if _, code := ts.getJob(t, created.ID); code != http.StatusNotFound {
+		t.Errorf("expired job: unexpected status code: got %d, want %d", code, http.StatusNotFound)
+	}
+}
+
+func TestCheckRequest(t *testing.T) {
+	ts := newTestServer(t, time.Hour, 1, nil)
+	jobs := "/api/devices/" + ts.dev.id + "/jobs"
+	for _, tt := range []struct {
+		name   string
+		method string
+		path   string
+		header map[string]string
+		host   string
+		want   int
+	}{
+		{
+			name:   "simple cross-origin form",
+			method: "POST",
+			path:   jobs,
+			header: map[string]string{"Content-Type": "text/plain"},
+			want:   http.StatusUnsupportedMediaType,
+		},
+		{
+			name:   "no content type",
+			method: "POST",
+			path:   "/api/devices/" + ts.dev.id + "/preview",
+			want:   http.StatusUnsupportedMediaType,
+		},
+		{
+			name:   "foreign origin",
+			method: "POST",
+			path:   jobs,
+			header: map[string]string{
+				"Content-Type": "application/json",
+				"Origin":       "https://example.com",
+			},
+			want: http.StatusForbidden,
+		},
+		{
+			name:   "null origin",
+			method: "DELETE",
+			path:   "/api/jobs/none",
+			header: map[string]string{"Origin": "null"},
+			want:   http.StatusForbidden,
+		},
+		{
+			name:   "DNS rebinding",
+			method: "GET",
+			path:   "/api/devices",
+			host:   "attacker.example.com",
+			want:   http.StatusForbidden,
+		},
+		{
+			name:   "localhost",
+			method: "GET",
+			path:   "/api/devices",
+			host:   "localhost:8080",
+			want:   http.StatusOK,
+		},
+		{
+			name:   "same origin",
+			method: "POST",
+			path:   jobs,
+			header: map[string]string{
+				"Content-Type": "application/json; charset=utf-8",
+				"Origin":       "<self>",
+			},
+			want: http.StatusCreated,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			req := ts.request(t, tt.method, tt.path, "")
+			if tt.method == "POST" {
+				req.Body = io.NopCloser(strings.NewReader(testJobRequest))
+			}
+			for k, v := range tt.header {
+				if v == "<self>" {
+					v = ts.URL
+				}
+				req.Header.Set(k, v)
+			}
+			if tt.host != "" {
+				req.Host = tt.host
+			}
+			if got := ts.do(t, req, nil); got != tt.want {
+				t.Errorf("unexpected status code: got %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
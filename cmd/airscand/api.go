@@ -0,0 +1,402 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"image/jpeg"
+	"io"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/stapelberg/airscan/internal/pdf"
+)
+
+// server implements the HTTP/REST API:
+//
+//	GET    /api/devices                         list discovered devices
+//	GET    /api/devices/{device}/capabilities   get the device’s capabilities
+//	POST   /api/devices/{device}/preview        scan a low-resolution preview
+//	POST   /api/devices/{device}/jobs           start a scan job (JSON settings)
+//	GET    /api/jobs                            list scan jobs
+//	GET    /api/jobs/{job}                      get the status of a scan job
+//	GET    /api/jobs/{job}/pages/{page}         download a page (starting at 1)
+//	GET    /api/jobs/{job}/pdf                  download all pages as PDF
+//	                                            (or ?pages=3,1,2 in that order)
+//	DELETE /api/jobs/{job}                      cancel a scan job
+type server struct {
+	reg  *registry
+	jobs *jobStore
+
+	// hosts are the host names (in addition to IP addresses and localhost)
+	// under which the server may be accessed, see checkRequest.
+	hosts map[string]bool
+}
+
+// defaultHosts returns the names of this machine, under which the server may
+// be accessed from the local network, plus the specified additional names.
+func defaultHosts(additional []string) map[string]bool {
+	hosts := make(map[string]bool)
+	if hostname, err := os.Hostname(); err == nil {
+		hostname = strings.ToLower(hostname)
+		hosts[hostname] = true
+		hosts[hostname+".local"] = true
+	}
+	for _, host := range additional {
+		hosts[strings.ToLower(host)] = true
+	}
+	return hosts
+}
+
+// allowedHost returns whether hostport (from a Host header or an Origin URL)
+// refers to this server.
+func (s *server) allowedHost(hostport string) bool {
+	host, _, err := net.SplitHostPort(hostport)
+	if err != nil {
+		host = hostport // no port
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if host == "localhost" || net.ParseIP(strings.Trim(host, "[]")) != nil {
+		return true
+	}
+	return s.hosts[host]
+}
+
+// checkRequest protects the API against web sites which the user visits:
+// browsers send “simple” cross-origin POST requests (e.g. with a text/plain
+// body) without asking the server first, and DNS rebinding lets web sites
+// access the server under their own host name. Hence, checkRequest rejects
+// requests for unknown host names or from other origins, and requires requests
+// which start scans to be JSON, which browsers only send cross-origin after a
+// CORS preflight request (which airscand does not allow).
+func (s *server) checkRequest(h http.Handler) http.Handler {
+	return handleError(func(w http.ResponseWriter, r *http.Request) error {
+		if !s.allowedHost(r.Host) {
+			return httpErrorf(http.StatusForbidden, "host %q not allowed (see -allowed_hosts)", r.Host)
+		}
+		if origin := r.Header.Get("Origin"); origin != "" {
+			u, err := url.Parse(origin)
+			if err != nil || !strings.EqualFold(u.Host, r.Host) {
+				return httpErrorf(http.StatusForbidden, "cross-origin requests from %q not allowed", origin)
+			}
+		}
+		if r.Method == "POST" {
+			mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+			if mediaType != "application/json" {
+				return httpErrorf(http.StatusUnsupportedMediaType, "unexpected Content-Type: got %q, want application/json", r.Header.Get("Content-Type"))
+			}
+		}
+		h.ServeHTTP(w, r)
+		return nil
+	})
+}
+
+// httpError is an error with an HTTP status code.
+type httpError struct {
+	code int
+	err  error
+}
+
+func (h *httpError) Error() string {
+	return h.err.Error()
+}
+
+func httpErrorf(code int, format string, args ...interface{}) error {
+	return &httpError{code: code, err: fmt.Errorf(format, args...)}
+}
+
+// handleError adapts h to an http.Handler, which reports errors as JSON
+// objects, e.g. {"error": "no such job"}.
+func handleError(h func(w http.ResponseWriter, r *http.Request) error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err := h(w, r)
+		if err == nil {
+			return
+		}
+		code := http.StatusInternalServerError
+		if he, ok := err.(*httpError); ok {
+			code = he.code
+		}
+		if code == http.StatusInternalServerError {
+			log.Printf("%s %s: %v", r.Method, r.URL.Path, err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(struct {
+			Error string `json:"error"`
+		}{err.Error()})
+	})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
+
+func (s *server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("GET /api/devices", handleError(s.listDevices))
+	mux.Handle("GET /api/devices/{device}/capabilities", handleError(s.capabilities))
+	mux.Handle("POST /api/devices/{device}/preview", handleError(s.preview))
+	mux.Handle("POST /api/devices/{device}/jobs", handleError(s.createJob))
+	mux.Handle("GET /api/jobs", handleError(s.listJobs))
+	mux.Handle("GET /api/jobs/{job}", handleError(s.getJob))
+	mux.Handle("GET /api/jobs/{job}/pages/{page}", handleError(s.getPage))
+	mux.Handle("GET /api/jobs/{job}/pdf", handleError(s.getPDF))
+	mux.Handle("DELETE /api/jobs/{job}", handleError(s.cancelJob))
+	mux.Handle("GET /", http.FileServerFS(uiFS))
+	return s.checkRequest(mux)
+}
+
+func (s *server) device(r *http.Request) (*device, error) {
+	dev := s.reg.get(r.PathValue("device"))
+	if dev == nil {
+		return nil, httpErrorf(http.StatusNotFound, "no such device: %q", r.PathValue("device"))
+	}
+	return dev, nil
+}
+
+func (s *server) job(r *http.Request) (*job, error) {
+	j := s.jobs.get(r.PathValue("job"))
+	if j == nil {
+		return nil, httpErrorf(http.StatusNotFound, "no such job: %q", r.PathValue("job"))
+	}
+	return j, nil
+}
+
+func (s *server) listDevices(w http.ResponseWriter, r *http.Request) error {
+	devices := []deviceJSON{} // encode as [], not null
+	for _, dev := range s.reg.list() {
+		devices = append(devices, dev.json())
+	}
+	return writeJSON(w, http.StatusOK, devices)
+}
+
+func (s *server) capabilities(w http.ResponseWriter, r *http.Request) error {
+	dev, err := s.device(r)
+	if err != nil {
+		return err
+	}
+	caps, err := dev.client().ScannerCapabilitiesContext(r.Context())
+	if err != nil {
+		return httpErrorf(http.StatusBadGateway, "%v", err)
+	}
+	return writeJSON(w, http.StatusOK, caps)
+}
+
+// previewJSON is the API representation of a preview scan. The region is
+// specified in escl:ThreeHundredthsOfInches and covers the entire image.
+type previewJSON struct {
+	Image   string `json:"image"` // data URL
+	XOffset int    `json:"x_offset"`
+	YOffset int    `json:"y_offset"`
+	Width   int    `json:"width"`
+	Height  int    `json:"height"`
+}
+
+func (s *server) preview(w http.ResponseWriter, r *http.Request) error {
+	dev, err := s.device(r)
+	if err != nil {
+		return err
+	}
+	req := struct {
+		Source string `json:"source"`
+	}{
+		Source: "platen",
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		return httpErrorf(http.StatusBadRequest, "decoding preview settings: %v", err)
+	}
+	inputSource := "Platen"
+	if req.Source == "adf" {
+		inputSource = "Feeder"
+	}
+	ticket := dev.enqueue()
+	if ticket == nil {
+		return httpErrorf(http.StatusServiceUnavailable, "too many scan jobs queued for device %q", dev.id)
+	}
+	cl, lock, err := dev.acquire(r.Context(), ticket)
+	if err != nil {
+		return httpErrorf(http.StatusServiceUnavailable, "%v", err)
+	}
+	preview, err := cl.Preview(r.Context(), inputSource)
+	lock.Release()
+	if err != nil {
+		return httpErrorf(http.StatusBadGateway, "%v", err)
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, preview.Image, nil); err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, previewJSON{
+		Image:   "data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(buf.Bytes()),
+		XOffset: preview.Region.XOffset,
+		YOffset: preview.Region.YOffset,
+		Width:   preview.Region.Width,
+		Height:  preview.Region.Height,
+	})
+}
+
+func (s *server) createJob(w http.ResponseWriter, r *http.Request) error {
+	dev, err := s.device(r)
+	if err != nil {
+		return err
+	}
+	req := defaultJobRequest()
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		return httpErrorf(http.StatusBadRequest, "decoding scan settings: %v", err)
+	}
+	caps, err := dev.client().ScannerCapabilitiesContext(r.Context())
+	if err != nil {
+		return httpErrorf(http.StatusBadGateway, "%v", err)
+	}
+	settings, err := req.settings(caps)
+	if err != nil {
+		return httpErrorf(http.StatusBadRequest, "%v", err)
+	}
+	ticket := dev.enqueue()
+	if ticket == nil {
+		return httpErrorf(http.StatusServiceUnavailable, "too many scan jobs queued for device %q", dev.id)
+	}
+	j := newJob(dev.id, settings, ticket)
+	s.jobs.add(j)
+	go j.run(dev)
+	w.Header().Set("Location", "/api/jobs/"+j.id)
+	return writeJSON(w, http.StatusCreated, j.json())
+}
+
+func (s *server) listJobs(w http.ResponseWriter, r *http.Request) error {
+	jobs := []jobJSON{} // encode as [], not null
+	for _, j := range s.jobs.list() {
+		jobs = append(jobs, j.json())
+	}
+	return writeJSON(w, http.StatusOK, jobs)
+}
+
+func (s *server) getJob(w http.ResponseWriter, r *http.Request) error {
+	j, err := s.job(r)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, j.json())
+}
+
+func (s *server) getPage(w http.ResponseWriter, r *http.Request) error {
+	j, err := s.job(r)
+	if err != nil {
+		return err
+	}
+	num, err := strconv.Atoi(r.PathValue("page"))
+	if err != nil {
+		return httpErrorf(http.StatusBadRequest, "invalid page number: %v", err)
+	}
+	b, ok := j.page(num)
+	if !ok {
+		return httpErrorf(http.StatusNotFound, "no such page: %d", num)
+	}
+	w.Header().Set("Content-Type", j.settings.DocumentFormat)
+	// No modification time: the zero time disables conditional requests.
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(b))
+	return nil
+}
+
+func (s *server) getPDF(w http.ResponseWriter, r *http.Request) error {
+	j, err := s.job(r)
+	if err != nil {
+		return err
+	}
+	pages := j.allPages()
+	if len(pages) == 0 {
+		return httpErrorf(http.StatusNotFound, "job has no pages (yet)")
+	}
+	if order := r.FormValue("pages"); order != "" {
+		// Select and reorder pages, e.g. after the user deleted a page:
+		var selected [][]byte
+		for _, field := range strings.Split(order, ",") {
+			num, err := strconv.Atoi(field)
+			if err != nil || num < 1 || num > len(pages) {
+				return httpErrorf(http.StatusBadRequest, "invalid page number %q, want 1-%d", field, len(pages))
+			}
+			selected = append(selected, pages[num-1])
+		}
+		pages = selected
+	}
+	var buf bytes.Buffer
+	switch format := j.settings.DocumentFormat; format {
+	case "application/pdf":
+		if len(pages) > 1 {
+			return httpErrorf(http.StatusNotImplemented, "combining %d PDF documents is not supported, download the pages individually", len(pages))
+		}
+		buf.Write(pages[0])
+
+	case "image/jpeg":
+		pdfPages := make([]pdf.Page, len(pages))
+		for idx, b := range pages {
+			pdfPages[idx] = pdf.Page{
+				JPEG:        b,
+				XResolution: j.settings.XResolution,
+				YResolution: j.settings.YResolution,
+			}
+		}
+		if err := pdf.Write(&buf, pdfPages); err != nil {
+			return err
+		}
+
+	default:
+		return httpErrorf(http.StatusNotImplemented, "converting %s to PDF is not supported, scan with format image/jpeg or application/pdf", format)
+	}
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "scan-"+j.id+".pdf"))
+	// No modification time: the PDF changes while pages arrive, so caches
+	// must not answer conditional requests with a partial PDF.
+	w.Header().Set("Cache-Control", "no-store")
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(buf.Bytes()))
+	return nil
+}
+
+func (s *server) cancelJob(w http.ResponseWriter, r *http.Request) error {
+	j, err := s.job(r)
+	if err != nil {
+		return err
+	}
+	ctx, canc := context.WithTimeout(r.Context(), cancelTimeout)
+	defer canc()
+	if err := j.abort(ctx); err != nil {
+		if _, ok := err.(*httpError); ok {
+			return err
+		}
+		return httpErrorf(http.StatusBadGateway, "canceling scan job on device: %v", err)
+	}
+	return writeJSON(w, http.StatusOK, j.json())
+}
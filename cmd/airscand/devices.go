@@ -0,0 +1,208 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/brutella/dnssd"
+	"github.com/stapelberg/airscan"
+	"github.com/stapelberg/airscan/queue"
+)
+
+// maxQueued is the maximum number of scan jobs (and previews) waiting for a
+// device. Further scan jobs are rejected.
+const maxQueued = 32
+
+func humanDeviceName(srv dnssd.BrowseEntry) string {
+	if ty := srv.Text["ty"]; ty != "" {
+		return ty
+	}
+
+	// miekg/dns escapes characters in DNS labels: as per RFC1034 and
+	// RFC1035, labels do not actually permit whitespace. The purpose of
+	// escaping originally appears to be to use these labels in a DNS
+	// master file, but for our UI, backslashes look just wrong:
+	return strings.ReplaceAll(srv.Name, "\\", "")
+}
+
+// deviceID returns the identifier under which the device is available in the
+// API, which is its host name (as in airscan1’s -host flag).
+func deviceID(srv dnssd.BrowseEntry) string {
+	return strings.TrimSuffix(strings.TrimSuffix(srv.Host, "."), ".local")
+}
+
+// A device is a discovered scanner, which processes one scan job at a time.
+type device struct {
+	id   string
+	name string
+
+	debug          bool
+	skipCertVerify bool
+
+	// queue serializes scan jobs and previews, also with other processes
+	// (e.g. airscan1) on the same host.
+	queue *queue.Queue
+
+	// waitIdle limits how long to wait for the device to become Idle, e.g.
+	// when a different host is scanning.
+	waitIdle time.Duration
+
+	mu      sync.Mutex
+	service dnssd.BrowseEntry
+	online  bool
+}
+
+func (d *device) client() *airscan.Client {
+	d.mu.Lock()
+	service := d.service
+	d.mu.Unlock()
+	cl := airscan.NewClientForService(&service)
+	cl.SetDebug(d.debug)
+	transport := cl.HTTPClient.(*http.Client).Transport.(*http.Transport)
+	transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: d.skipCertVerify}
+	return cl
+}
+
+// acquire waits for the device to be available: first for scan jobs of this
+// process or other processes on the same host (see package queue), then for
+// the device to become Idle.
+func (d *device) acquire(ctx context.Context, ticket *queue.Ticket) (*airscan.Client, *queue.Lock, error) {
+	lock, err := ticket.Wait(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	cl := d.client()
+	idleCtx, canc := context.WithTimeout(ctx, d.waitIdle)
+	defer canc()
+	if _, err := cl.WaitIdle(idleCtx); err != nil {
+		lock.Release()
+		return nil, nil, err
+	}
+	return cl, lock, nil
+}
+
+// enqueue queues a scan job (or preview) for this device, or returns nil if
+// too many are queued already.
+func (d *device) enqueue() *queue.Ticket {
+	if d.queue.Waiting(d.id) >= maxQueued {
+		return nil
+	}
+	return d.queue.Enqueue(d.id)
+}
+
+// deviceJSON is the API representation of a device.
+type deviceJSON struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Host   string `json:"host"`
+	Online bool   `json:"online"`
+}
+
+func (d *device) json() deviceJSON {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return deviceJSON{
+		ID:     d.id,
+		Name:   d.name,
+		Host:   d.service.Host,
+		Online: d.online,
+	}
+}
+
+// registry contains all devices discovered since the program started. Devices
+// which vanish are kept (marked offline) so that their scan jobs remain
+// accessible.
+type registry struct {
+	debug          bool
+	skipCertVerify bool
+	queue          *queue.Queue
+	waitIdle       time.Duration
+
+	mu      sync.Mutex
+	devices map[string]*device
+}
+
+func newRegistry(debug, skipCertVerify bool, q *queue.Queue, waitIdle time.Duration) *registry {
+	return &registry{
+		debug:          debug,
+		skipCertVerify: skipCertVerify,
+		queue:          q,
+		waitIdle:       waitIdle,
+		devices:        make(map[string]*device),
+	}
+}
+
+// add adds (or updates) the device for the discovered service.
+func (r *registry) add(service dnssd.BrowseEntry) *device {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	id := deviceID(service)
+	dev, ok := r.devices[id]
+	if !ok {
+		dev = &device{
+			id:             id,
+			debug:          r.debug,
+			skipCertVerify: r.skipCertVerify,
+			queue:          r.queue,
+			waitIdle:       r.waitIdle,
+		}
+		r.devices[id] = dev
+	}
+	dev.mu.Lock()
+	defer dev.mu.Unlock()
+	dev.name = humanDeviceName(service)
+	dev.service = service
+	dev.online = true
+	return dev
+}
+
+// remove marks the device for the vanished service as offline.
+func (r *registry) remove(service dnssd.BrowseEntry) *device {
+	dev := r.get(deviceID(service))
+	if dev == nil {
+		return nil
+	}
+	dev.mu.Lock()
+	defer dev.mu.Unlock()
+	dev.online = false
+	return dev
+}
+
+func (r *registry) get(id string) *device {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.devices[id]
+}
+
+// list returns all devices, sorted by ID.
+func (r *registry) list() []*device {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	devices := make([]*device, 0, len(r.devices))
+	for _, dev := range r.devices {
+		devices = append(devices, dev)
+	}
+	sort.Slice(devices, func(i, j int) bool {
+		return devices[i].id < devices[j].id
+	})
+	return devices
+}
@@ -0,0 +1,336 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	crypto_rand "crypto/rand"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/stapelberg/airscan"
+	"github.com/stapelberg/airscan/internal/scanopts"
+	"github.com/stapelberg/airscan/queue"
+)
+
+// Scan job states, as reported by the API.
+const (
+	stateQueued    = "queued"
+	stateScanning  = "scanning"
+	stateCompleted = "completed"
+	stateFailed    = "failed"
+	stateCanceled  = "canceled"
+)
+
+// jobRequest contains the settings for a new scan job, as posted to the API.
+// The fields correspond to airscan1’s flags of the same name.
+type jobRequest struct {
+	scanopts.Options
+	Validate bool `json:"validate"`
+}
+
+// defaultJobRequest returns the settings which are used for all fields that a
+// request does not specify.
+func defaultJobRequest() jobRequest {
+	return jobRequest{
+		Options: scanopts.Options{
+			Source:     "platen",
+			Size:       "A4",
+			Resolution: 300,
+			Format:     "image/jpeg",
+			Color:      "Grayscale8",
+			Duplex:     true,
+		},
+		Validate: true,
+	}
+}
+
+// settings converts the request into ScanSettings for the device with the
+// specified capabilities.
+func (r *jobRequest) settings(caps *airscan.ScannerCapabilities) (*airscan.ScanSettings, error) {
+	settings, err := r.ScanSettings(caps)
+	if err != nil {
+		return nil, err
+	}
+	if r.Validate {
+		if err := settings.Validate(caps); err != nil {
+			return nil, fmt.Errorf("%v (set \"validate\": false to skip this check)", err)
+		}
+	}
+	return settings, nil
+}
+
+// A job is a scan job of the API, which is queued until its device is
+// available.
+type job struct {
+	id       string
+	device   string
+	settings *airscan.ScanSettings
+	created  time.Time
+	ticket   *queue.Ticket
+
+	// ctx is canceled when the job is canceled, which aborts waiting for the
+	// device.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu       sync.Mutex
+	state    string
+	err      error
+	started  time.Time
+	finished time.Time
+	pages    [][]byte
+	active   *airscan.ScanState // while scanning
+}
+
+func newJobID() string {
+	random := make([]byte, 8)
+	crypto_rand.Read(random)
+	return fmt.Sprintf("%x", random)
+}
+
+func newJob(device string, settings *airscan.ScanSettings, ticket *queue.Ticket) *job {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &job{
+		id:       newJobID(),
+		device:   device,
+		settings: settings,
+		created:  time.Now(),
+		ticket:   ticket,
+		ctx:      ctx,
+		cancel:   cancel,
+		state:    stateQueued,
+	}
+}
+
+// run waits for the device and then scans all pages of the job.
+func (j *job) run(dev *device) {
+	cl, lock, err := dev.acquire(j.ctx, j.ticket)
+	if err != nil {
+		j.finish(err)
+		return
+	}
+	defer lock.Release()
+
+	j.mu.Lock()
+	if j.state != stateQueued {
+		j.mu.Unlock()
+		j.finish(nil) // canceled while queued, record when for expiry
+		return
+	}
+	j.state = stateScanning
+	j.started = time.Now()
+	j.mu.Unlock()
+
+	j.finish(j.scan(cl))
+}
+
+// finish records the result of the job, unless the job was canceled.
+func (j *job) finish(err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.active = nil
+	j.finished = time.Now()
+	if j.state == stateCanceled {
+		return
+	}
+	if err != nil {
+		log.Printf("job %s: %v", j.id, err)
+		j.state = stateFailed
+		j.err = err
+		return
+	}
+	j.state = stateCompleted
+}
+
+func (j *job) scan(cl *airscan.Client) error {
+	scan, err := cl.Scan(j.settings)
+	if err != nil {
+		return err
+	}
+	j.mu.Lock()
+	j.active = scan
+	canceled := j.state == stateCanceled
+	j.mu.Unlock()
+	if canceled {
+		// Canceled between starting the scan job and publishing it:
+		ctx, canc := context.WithTimeout(context.Background(), cancelTimeout)
+		defer canc()
+		return scan.Cancel(ctx)
+	}
+	for page, err := range scan.Pages(j.ctx) {
+		if err != nil {
+			return err
+		}
+		j.mu.Lock()
+		j.pages = append(j.pages, page.Data)
+		j.mu.Unlock()
+	}
+	return nil
+}
+
+// cancelTimeout limits how long to wait for the device to cancel a scan job.
+const cancelTimeout = 30 * time.Second
+
+// abort cancels the job: queued jobs are skipped, and the device is instructed
+// to cancel a running scan job.
+func (j *job) abort(ctx context.Context) error {
+	j.mu.Lock()
+	switch j.state {
+	case stateQueued, stateScanning:
+	default:
+		j.mu.Unlock()
+		return httpErrorf(http.StatusConflict, "job is already %s", j.state)
+	}
+	j.state = stateCanceled
+	scan := j.active
+	j.mu.Unlock()
+	if scan == nil {
+		j.cancel()
+		return nil
+	}
+	err := scan.Cancel(ctx)
+	j.cancel()
+	return err
+}
+
+// page returns the data of page num (starting at 1).
+func (j *job) page(num int) ([]byte, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if num < 1 || num > len(j.pages) {
+		return nil, false
+	}
+	return j.pages[num-1], true
+}
+
+// allPages returns the data of all pages received so far.
+func (j *job) allPages() [][]byte {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return append([][]byte(nil), j.pages...)
+}
+
+// jobJSON is the API representation of a job.
+type jobJSON struct {
+	ID       string     `json:"id"`
+	Device   string     `json:"device"`
+	State    string     `json:"state"`
+	Position *int       `json:"position,omitempty"` // while queued
+	Error    string     `json:"error,omitempty"`
+	Format   string     `json:"format"`
+	Pages    int        `json:"pages"`
+	Created  time.Time  `json:"created"`
+	Started  *time.Time `json:"started,omitempty"`
+	Finished *time.Time `json:"finished,omitempty"`
+}
+
+func (j *job) json() jobJSON {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	js := jobJSON{
+		ID:      j.id,
+		Device:  j.device,
+		State:   j.state,
+		Format:  j.settings.DocumentFormat,
+		Pages:   len(j.pages),
+		Created: j.created,
+	}
+	if j.err != nil {
+		js.Error = j.err.Error()
+	}
+	if j.state == stateQueued {
+		if pos := j.ticket.Position(); pos > -1 {
+			js.Position = &pos
+		}
+	}
+	if !j.started.IsZero() {
+		started := j.started
+		js.Started = &started
+	}
+	if !j.finished.IsZero() {
+		finished := j.finished
+		js.Finished = &finished
+	}
+	return js
+}
+
+// done returns whether the job is finished, and since when.
+func (j *job) done() (bool, time.Time) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	switch j.state {
+	case stateCompleted, stateFailed, stateCanceled:
+		return !j.finished.IsZero(), j.finished
+	}
+	return false, time.Time{}
+}
+
+// jobStore contains all scan jobs, which are kept in memory for ttl after they
+// finished.
+type jobStore struct {
+	ttl time.Duration
+
+	mu   sync.Mutex
+	jobs map[string]*job
+}
+
+func newJobStore(ttl time.Duration) *jobStore {
+	return &jobStore{
+		ttl:  ttl,
+		jobs: make(map[string]*job),
+	}
+}
+
+func (s *jobStore) add(j *job) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire()
+	s.jobs[j.id] = j
+}
+
+// expire removes expired jobs. s.mu must be held.
+func (s *jobStore) expire() {
+	for id, j := range s.jobs {
+		if done, finished := j.done(); done && time.Since(finished) > s.ttl {
+			delete(s.jobs, id)
+		}
+	}
+}
+
+func (s *jobStore) get(id string) *job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.jobs[id]
+}
+
+// list returns all jobs, oldest first.
+func (s *jobStore) list() []*job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire()
+	jobs := make([]*job, 0, len(s.jobs))
+	for _, j := range s.jobs {
+		jobs = append(jobs, j)
+	}
+	sort.Slice(jobs, func(i, k int) bool {
+		return jobs[i].created.Before(jobs[k].created)
+	})
+	return jobs
+}
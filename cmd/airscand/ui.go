@@ -0,0 +1,34 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"embed"
+	"io/fs"
+)
+
+// The web UI is a single page which only uses the API (see server), and is
+// self-contained so that it works without internet access.
+//
+//go:embed ui
+var uiEmbed embed.FS
+
+var uiFS = func() fs.FS {
+	sub, err := fs.Sub(uiEmbed, "ui")
+	if err != nil {
+		panic(err)
+	}
+	return sub
+}()
@@ -0,0 +1,355 @@
+// airscand web UI: uses only the airscand API (see api.go) and no external
+// assets, so that it works without internet access.
+'use strict';
+
+const $ = (id) => document.getElementById(id);
+
+// Fields of the scan settings form, named like the API’s JSON fields.
+const fields = ['source', 'size', 'color', 'resolution', 'format', 'duplex'];
+
+const builtinProfiles = {
+  'Document (grayscale)': {source: 'platen', size: 'A4', color: 'Grayscale8', resolution: '300', format: 'image/jpeg', duplex: false},
+  'Document feeder, duplex': {source: 'adf', size: 'A4', color: 'Grayscale8', resolution: '300', format: 'image/jpeg', duplex: true},
+  'Color photo': {source: 'platen', size: 'photo-4x6', color: 'RGB24', resolution: '600', format: 'image/jpeg', duplex: false},
+};
+
+const state = {
+  preview: null,   // API preview response
+  selection: null, // {x, y, w, h} as fractions of the preview image
+  job: null,       // API job
+  pages: [],       // page numbers in display order
+  received: 0,     // number of pages received so far
+  polling: null,
+};
+
+function setStatus(message, isError) {
+  $('status').textContent = message;
+  $('status').classList.toggle('error', !!isError);
+}
+
+async function api(method, path, body) {
+  const opts = {method};
+  if (body !== undefined) {
+    opts.headers = {'Content-Type': 'application/json'};
+    opts.body = JSON.stringify(body);
+  }
+  const resp = await fetch(path, opts);
+  const data = await resp.json();
+  if (!resp.ok) {
+    throw new Error(data.error || resp.statusText);
+  }
+  return data;
+}
+
+// Devices
+
+async function loadDevices() {
+  try {
+    const devices = await api('GET', '/api/devices');
+    const sel = $('device');
+    const previous = sel.value;
+    sel.replaceChildren(...devices.map((dev) => {
+      const opt = new Option(dev.name + (dev.online ? '' : ' (offline)'), dev.id);
+      opt.disabled = !dev.online;
+      return opt;
+    }));
+    if (devices.some((dev) => dev.id === previous)) {
+      sel.value = previous;
+    }
+    setStatus(devices.length ? '' : 'No scanners discovered (yet)');
+  } catch (err) {
+    setStatus('Listing devices: ' + err.message, true);
+  }
+}
+
+// Profiles are stored in the browser (localStorage), not on the server.
+
+function userProfiles() {
+  return JSON.parse(localStorage.getItem('airscand.profiles') || '{}');
+}
+
+function allProfiles() {
+  return Object.assign({}, builtinProfiles, userProfiles());
+}
+
+function loadProfiles(selected) {
+  const profiles = allProfiles();
+  $('profile').replaceChildren(
+    new Option('(custom)', ''),
+    ...Object.keys(profiles).map((name) => new Option(name, name)));
+  $('profile').value = selected || '';
+}
+
+function currentSettings() {
+  const settings = {};
+  for (const f of fields) {
+    const el = $(f);
+    settings[f] = el.type === 'checkbox' ? el.checked : el.value;
+  }
+  return settings;
+}
+
+function applyProfile(name) {
+  const profile = allProfiles()[name];
+  if (!profile) {
+    return;
+  }
+  for (const f of fields) {
+    if (!(f in profile)) {
+      continue;
+    }
+    const el = $(f);
+    if (el.type === 'checkbox') {
+      el.checked = profile[f];
+    } else {
+      el.value = profile[f];
+    }
+  }
+  localStorage.setItem('airscand.profile', name);
+}
+
+function saveProfile() {
+  const name = prompt('Profile name:', $('profile').value);
+  if (!name) {
+    return;
+  }
+  const profiles = userProfiles();
+  profiles[name] = currentSettings();
+  localStorage.setItem('airscand.profiles', JSON.stringify(profiles));
+  loadProfiles(name);
+}
+
+function deleteProfile() {
+  const name = $('profile').value;
+  const profiles = userProfiles();
+  if (!(name in profiles)) {
+    setStatus('Only profiles you saved can be deleted', true);
+    return;
+  }
+  delete profiles[name];
+  localStorage.setItem('airscand.profiles', JSON.stringify(profiles));
+  loadProfiles('');
+}
+
+// Preview and region selection
+
+async function preview() {
+  setStatus('Scanning preview…');
+  $('preview').disabled = true;
+  try {
+    state.preview = await api('POST', '/api/devices/' + encodeURIComponent($('device').value) + '/preview',
+                              {source: $('source').value});
+    $('preview-image').src = state.preview.image;
+    setSelection(null);
+    setStatus('');
+  } catch (err) {
+    setStatus('Preview: ' + err.message, true);
+  } finally {
+    $('preview').disabled = false;
+  }
+}
+
+function setSelection(sel) {
+  state.selection = sel;
+  const div = $('selection');
+  div.hidden = !sel;
+  $('clear-selection').disabled = !sel;
+  if (!sel) {
+    return;
+  }
+  div.style.left = (sel.x * 100) + '%';
+  div.style.top = (sel.y * 100) + '%';
+  div.style.width = (sel.w * 100) + '%';
+  div.style.height = (sel.h * 100) + '%';
+}
+
+function setupSelection() {
+  const area = $('preview-area');
+  let start = null;
+  const pos = (ev) => {
+    const r = $('preview-image').getBoundingClientRect();
+    return {
+      x: Math.min(Math.max((ev.clientX - r.left) / r.width, 0), 1),
+      y: Math.min(Math.max((ev.clientY - r.top) / r.height, 0), 1),
+    };
+  };
+  area.addEventListener('pointerdown', (ev) => {
+    if (!state.preview) {
+      return;
+    }
+    start = pos(ev);
+    area.setPointerCapture(ev.pointerId);
+  });
+  area.addEventListener('pointermove', (ev) => {
+    if (!start) {
+      return;
+    }
+    const p = pos(ev);
+    setSelection({
+      x: Math.min(start.x, p.x),
+      y: Math.min(start.y, p.y),
+      w: Math.abs(p.x - start.x),
+      h: Math.abs(p.y - start.y),
+    });
+  });
+  area.addEventListener('pointerup', () => {
+    start = null;
+    if (state.selection && (state.selection.w < 0.01 || state.selection.h < 0.01)) {
+      setSelection(null); // a click, not a drag
+    }
+  });
+}
+
+// inches converts escl:ThreeHundredthsOfInches into a length for the API.
+function inches(units) {
+  return (units / 300).toFixed(3) + 'in';
+}
+
+// Scanning
+
+function jobRequest() {
+  const req = currentSettings();
+  req.resolution = parseInt(req.resolution, 10);
+  const sel = state.selection;
+  if (sel && state.preview) {
+    const p = state.preview;
+    req.size = (sel.w * p.width / 300).toFixed(3) + 'x' + (sel.h * p.height / 300).toFixed(3) + 'in';
+    req.x_offset = inches(p.x_offset + sel.x * p.width);
+    req.y_offset = inches(p.y_offset + sel.y * p.height);
+  }
+  return req;
+}
+
+async function scan() {
+  setStatus('Starting scan…');
+  try {
+    state.job = await api('POST', '/api/devices/' + encodeURIComponent($('device').value) + '/jobs', jobRequest());
+  } catch (err) {
+    setStatus('Scan: ' + err.message, true);
+    return;
+  }
+  state.pages = [];
+  state.received = 0;
+  renderPages();
+  $('scan').disabled = true;
+  $('cancel').disabled = false;
+  poll();
+}
+
+async function poll() {
+  clearTimeout(state.polling);
+  try {
+    const job = await api('GET', '/api/jobs/' + state.job.id);
+    state.job = job;
+    for (; state.received < job.pages; state.received++) {
+      state.pages.push(state.received + 1);
+    }
+    renderPages();
+    if (job.state === 'queued' || job.state === 'scanning') {
+      setStatus(job.state === 'queued' ? 'Waiting for the scanner…' : 'Scanning (' + job.pages + ' pages so far)…');
+      state.polling = setTimeout(poll, 1000);
+      return;
+    }
+    setStatus(job.state === 'failed' ? 'Scan failed: ' + job.error : 'Scan ' + job.state, job.state === 'failed');
+  } catch (err) {
+    setStatus('Job status: ' + err.message, true);
+  }
+  $('scan').disabled = false;
+  $('cancel').disabled = true;
+}
+
+async function cancel() {
+  $('cancel').disabled = true;
+  try {
+    await api('DELETE', '/api/jobs/' + state.job.id);
+  } catch (err) {
+    setStatus('Cancel: ' + err.message, true);
+  }
+}
+
+// Pages
+
+function button(label, title, onclick) {
+  const b = document.createElement('button');
+  b.type = 'button';
+  b.textContent = label;
+  b.title = title;
+  b.addEventListener('click', onclick);
+  return b;
+}
+
+function movePage(idx, delta) {
+  const target = idx + delta;
+  if (target < 0 || target >= state.pages.length) {
+    return;
+  }
+  [state.pages[idx], state.pages[target]] = [state.pages[target], state.pages[idx]];
+  renderPages();
+}
+
+function renderPages() {
+  const job = state.job;
+  const isImage = job && job.format.startsWith('image/');
+  $('pages').replaceChildren(...state.pages.map((n, idx) => {
+    const li = document.createElement('li');
+    const url = '/api/jobs/' + job.id + '/pages/' + n;
+    let thumb;
+    if (isImage) {
+      thumb = document.createElement('img');
+      thumb.src = url;
+      thumb.alt = 'page ' + n;
+      thumb.loading = 'lazy';
+    } else {
+      thumb = document.createElement('a');
+      thumb.className = 'placeholder';
+      thumb.href = url;
+      thumb.textContent = 'page ' + n;
+    }
+    const controls = document.createElement('div');
+    controls.append(
+      button('◀', 'move left', () => movePage(idx, -1)),
+      button('✕', 'delete page', () => {
+        state.pages.splice(idx, 1);
+        renderPages();
+      }),
+      button('▶', 'move right', () => movePage(idx, +1)));
+    li.append(thumb, controls);
+    return li;
+  }));
+
+  // The server converts JPEG pages into a PDF document, and passes through a
+  // single PDF document scanned by the device:
+  const download = $('download');
+  const pdfable = job && state.pages.length > 0 &&
+        (job.format === 'image/jpeg' || (job.format === 'application/pdf' && state.pages.length === 1));
+  download.hidden = !pdfable;
+  if (pdfable) {
+    download.href = '/api/jobs/' + job.id + '/pdf?pages=' + state.pages.join(',');
+  }
+}
+
+// Setup
+
+$('refresh-devices').addEventListener('click', loadDevices);
+$('profile').addEventListener('change', (ev) => applyProfile(ev.target.value));
+$('save-profile').addEventListener('click', saveProfile);
+$('delete-profile').addEventListener('click', deleteProfile);
+for (const f of fields) {
+  $(f).addEventListener('change', () => { $('profile').value = ''; });
+}
+$('source').addEventListener('change', () => {
+  // A preview of a different source does not apply:
+  state.preview = null;
+  $('preview-image').removeAttribute('src');
+  setSelection(null);
+});
+$('preview').addEventListener('click', preview);
+$('clear-selection').addEventListener('click', () => setSelection(null));
+$('scan').addEventListener('click', scan);
+$('cancel').addEventListener('click', cancel);
+setupSelection();
+
+const lastProfile = localStorage.getItem('airscand.profile') || '';
+loadProfiles(lastProfile);
+applyProfile(lastProfile);
+loadDevices();
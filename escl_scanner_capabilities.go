@@ -1,41 +1,17 @@
 package airscan
 
 type adf struct {
-	AdfOptions          adfOptions           `xml:"AdfOptions"`
-	AdfSimplexInputCaps *adfSimplexInputCaps `xml:"AdfSimplexInputCaps"`
-	AdfDuplexInputCaps  *adfDuplexInputCaps  `xml:"AdfDuplexInputCaps"`
-	FeederCapacity      int                  `xml:"FeederCapacity"`
-	Justification       justification        `xml:"Justification"`
+	AdfOptions          adfOptions    `xml:"AdfOptions"`
+	AdfSimplexInputCaps *InputCaps    `xml:"AdfSimplexInputCaps"`
+	AdfDuplexInputCaps  *InputCaps    `xml:"AdfDuplexInputCaps"`
+	FeederCapacity      int           `xml:"FeederCapacity"`
+	Justification       justification `xml:"Justification"`
 }
 
 type adfOptions struct {
 	AdfOption string `xml:"AdfOption"`
 }
 
-type adfSimplexInputCaps struct {
-	MaxHeight             int              `xml:"MaxHeight"`
-	MaxOpticalXResolution int              `xml:"MaxOpticalXResolution"`
-	MaxOpticalYResolution int              `xml:"MaxOpticalYResolution"`
-	MaxPhysicalHeight     int              `xml:"MaxPhysicalHeight"`
-	MaxPhysicalWidth      int              `xml:"MaxPhysicalWidth"`
-	MaxScanRegions        bool             `xml:"MaxScanRegions"`
-	MaxWidth              int              `xml:"MaxWidth"`
-	MinHeight             int              `xml:"MinHeight"`
-	MinWidth              int              `xml:"MinWidth"`
-	SettingProfiles       settingProfiles  `xml:"SettingProfiles"`
-	SupportedIntents      supportedIntents `xml:"SupportedIntents"`
-}
-
-type adfDuplexInputCaps struct {
-	FeedDirections   feedDirections   `xml:"FeedDirections"`
-	MaxHeight        int              `xml:"MaxHeight"`
-	MaxWidth         int              `xml:"MaxWidth"`
-	MinHeight        int              `xml:"MinHeight"`
-	MinWidth         int              `xml:"MinWidth"`
-	SettingProfiles  settingProfiles  `xml:"SettingProfiles"`
-	SupportedIntents supportedIntents `xml:"SupportedIntents"`
-}
-
 type feedDirections struct {
 	FeedDirection []string `xml:"FeedDirection"`
 }
@@ -54,7 +30,7 @@ type colorModes struct {
 }
 
 type colorSpaces struct {
-	ColorSpace string `xml:"ColorSpace"`
+	ColorSpace []string `xml:"ColorSpace"`
 }
 
 type contentTypes struct {
@@ -67,7 +43,7 @@ type discreteResolution struct {
 }
 
 type discreteResolutions struct {
-	DiscreteResolution discreteResolution `xml:"DiscreteResolution"`
+	DiscreteResolution []discreteResolution `xml:"DiscreteResolution"`
 }
 
 type documentFormats struct {
@@ -81,16 +57,19 @@ type justification struct {
 }
 
 type platen struct {
-	PlatenInputCaps platenInputCaps `xml:"PlatenInputCaps"`
+	PlatenInputCaps InputCaps `xml:"PlatenInputCaps"`
 }
 
-type platenInputCaps struct {
+// InputCaps describes the capabilities of one input source (platen, or ADF in
+// simplex or duplex mode).
+type InputCaps struct {
+	FeedDirections        feedDirections   `xml:"FeedDirections"`
 	MaxHeight             int              `xml:"MaxHeight"`
 	MaxOpticalXResolution int              `xml:"MaxOpticalXResolution"`
 	MaxOpticalYResolution int              `xml:"MaxOpticalYResolution"`
 	MaxPhysicalHeight     int              `xml:"MaxPhysicalHeight"`
 	MaxPhysicalWidth      int              `xml:"MaxPhysicalWidth"`
-	MaxScanRegions        bool             `xml:"MaxScanRegions"`
+	MaxScanRegions        int              `xml:"MaxScanRegions"`
 	MaxWidth              int              `xml:"MaxWidth"`
 	MinHeight             int              `xml:"MinHeight"`
 	MinWidth              int              `xml:"MinWidth"`
@@ -98,18 +77,37 @@ type platenInputCaps struct {
 	SupportedIntents      supportedIntents `xml:"SupportedIntents"`
 }
 
-type scannerCapabilities struct {
-	Adf            *adf           `xml:"Adf"`
-	AdminURI       string         `xml:"AdminURI"`
-	Certifications certifications `xml:"Certifications"`
-	IconURI        string         `xml:"IconURI"`
-	MakeAndModel   string         `xml:"MakeAndModel"`
-	Manufacturer   string         `xml:"Manufacturer"`
-	Platen         *platen        `xml:"Platen"`
-	SerialNumber   string         `xml:"SerialNumber"`
-	SharpenSupport sharpenSupport `xml:"SharpenSupport"`
-	UUID           string         `xml:"UUID"`
-	Version        float64        `xml:"Version"`
+// ScannerCapabilities describes the input sources, color modes, resolutions,
+// document formats and region sizes that a device supports. Sizes are
+// expressed in escl:ThreeHundredthsOfInches.
+type ScannerCapabilities struct {
+	Adf                      *adf           `xml:"Adf"`
+	AdminURI                 string         `xml:"AdminURI"`
+	BlankPageDetection       bool           `xml:"BlankPageDetection"`
+	BrightnessSupport        *rangeSupport  `xml:"BrightnessSupport"`
+	Certifications           certifications `xml:"Certifications"`
+	CompressionFactorSupport *rangeSupport  `xml:"CompressionFactorSupport"`
+	ContrastSupport          *rangeSupport  `xml:"ContrastSupport"`
+	GammaSupport             *rangeSupport  `xml:"GammaSupport"`
+	HighlightSupport         *rangeSupport  `xml:"HighlightSupport"`
+	IconURI                  string         `xml:"IconURI"`
+	MakeAndModel             string         `xml:"MakeAndModel"`
+	Manufacturer             string         `xml:"Manufacturer"`
+	NoiseRemovalSupport      *rangeSupport  `xml:"NoiseRemovalSupport"`
+	Platen                   *platen        `xml:"Platen"`
+	SerialNumber             string         `xml:"SerialNumber"`
+	ShadowSupport            *rangeSupport  `xml:"ShadowSupport"`
+	SharpenSupport           *rangeSupport  `xml:"SharpenSupport"`
+	ThresholdSupport         *rangeSupport  `xml:"ThresholdSupport"`
+	UUID                     string         `xml:"UUID"`
+	Version                  float64        `xml:"Version"`
+}
+
+type rangeSupport struct {
+	Min    int `xml:"Min"`
+	Max    int `xml:"Max"`
+	Normal int `xml:"Normal"`
+	Step   int `xml:"Step"`
 }
 
 type settingProfile struct {
@@ -122,20 +120,19 @@ type settingProfile struct {
 }
 
 type settingProfiles struct {
-	SettingProfile settingProfile `xml:"SettingProfile"`
-}
-
-type sharpenSupport struct {
-	Max    int  `xml:"Max"`
-	Min    bool `xml:"Min"`
-	Normal int  `xml:"Normal"`
-	Step   bool `xml:"Step"`
+	SettingProfile []settingProfile `xml:"SettingProfile"`
 }
 
 type supportedIntents struct {
 	Intent []string `xml:"Intent"`
 }
 
+type resolutionRange struct {
+	XResolutionRange rangeSupport `xml:"XResolutionRange"`
+	YResolutionRange rangeSupport `xml:"YResolutionRange"`
+}
+
 type supportedResolutions struct {
 	DiscreteResolutions discreteResolutions `xml:"DiscreteResolutions"`
+	ResolutionRange     *resolutionRange    `xml:"ResolutionRange"`
 }
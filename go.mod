@@ -1,6 +1,6 @@
 module github.com/stapelberg/airscan
 
-go 1.18
+go 1.23
 
 require (
 	github.com/brutella/dnssd v1.2.5
@@ -0,0 +1,75 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package daemon contains the HTTP serving and shutdown logic which the
+// airscan servers (airscand, airscan-proxy, airscan-virtual) share.
+package daemon
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/stapelberg/airscan"
+)
+
+// shutdownTimeout limits how long to wait for in-flight requests on shutdown.
+const shutdownTimeout = 10 * time.Second
+
+// Serve serves HTTP requests on ln using h until SIGINT or SIGTERM is
+// received. Then, the advertisement adv (if non-nil) is withdrawn, so that
+// clients do not try to use the server after it exited, and the HTTP server is
+// shut down gracefully. A second signal terminates the program immediately.
+func Serve(ln net.Listener, h http.Handler, adv *airscan.Advertisement) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return serve(ctx, stop, ln, h, adv)
+}
+
+func serve(ctx context.Context, stop context.CancelFunc, ln net.Listener, h http.Handler, adv *airscan.Advertisement) error {
+	if adv != nil {
+		go func() {
+			if err := adv.Wait(); err != nil {
+				log.Printf("DNS-SD responder failed: %v", err)
+			}
+		}()
+	}
+	srv := &http.Server{Handler: h}
+	errc := make(chan error, 1)
+	go func() { errc <- srv.Serve(ln) }()
+
+	var err error
+	select {
+	case err = <-errc:
+	case <-ctx.Done():
+		stop() // restore the default signal handling
+		log.Printf("shutting down")
+	}
+	if adv != nil {
+		if err := adv.Shutdown(); err != nil {
+			log.Print(err)
+		}
+	}
+	if err != nil {
+		return err // Serve failed
+	}
+	sctx, canc := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer canc()
+	return srv.Shutdown(sctx)
+}
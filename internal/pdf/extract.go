@@ -0,0 +1,76 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+var (
+	imageRe  = regexp.MustCompile(`/Subtype\s*/Image`)
+	dctRe    = regexp.MustCompile(`/DCTDecode`)
+	lengthRe = regexp.MustCompile(`/Length\s+(\d+)(\s+\d+\s+R)?`)
+	streamRe = regexp.MustCompile(`(?:^|[^d])stream\r?\n`)
+)
+
+// JPEGs returns the JPEG images embedded in a PDF document, in the order in
+// which they appear in the file. This covers PDF documents as written by
+// scanners (and by Write), which consist of one JPEG image per page, but is
+// not a general-purpose PDF parser: e.g., compressed object streams are not
+// supported.
+func JPEGs(b []byte) ([][]byte, error) {
+	if !bytes.HasPrefix(b, []byte("%PDF-")) {
+		return nil, fmt.Errorf("not a PDF document")
+	}
+	var images [][]byte
+	offset := 0
+	for {
+		loc := streamRe.FindIndex(b[offset:])
+		if loc == nil {
+			break
+		}
+		start := offset + loc[1]
+		// The stream dictionary is located between the object header and
+		// the stream keyword:
+		dictStart := bytes.LastIndex(b[:start], []byte(" obj"))
+		if dictStart == -1 {
+			dictStart = 0
+		}
+		dict := b[dictStart:start]
+		end := bytes.Index(b[start:], []byte("endstream"))
+		if end == -1 {
+			return nil, fmt.Errorf("stream at offset %d: endstream not found", start)
+		}
+		end += start
+		offset = end
+		if !imageRe.Match(dict) || !dctRe.Match(dict) {
+			continue
+		}
+		data := b[start:end]
+		if m := lengthRe.FindSubmatch(dict); m != nil && len(m[2]) == 0 {
+			// A direct length is more precise than searching for endstream:
+			if n, err := strconv.Atoi(string(m[1])); err == nil && n <= len(b)-start {
+				data = b[start : start+n]
+			}
+		} else {
+			data = bytes.TrimRight(data, "\r\n")
+		}
+		images = append(images, data)
+	}
+	return images, nil
+}
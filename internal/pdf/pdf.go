@@ -0,0 +1,162 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package pdf writes minimal PDF documents containing one JPEG image per page,
+// which is sufficient to combine scanned pages into a single document.
+//
+// The JPEG data is embedded as-is (DCTDecode filter), i.e. without re-encoding.
+package pdf
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+)
+
+// A Page is a scanned page in JPEG format.
+type Page struct {
+	// JPEG is the JPEG-encoded page.
+	JPEG []byte
+
+	// XResolution and YResolution are the resolution (in dpi) at which the page
+	// was scanned, which determine the page size. If 0, 300 dpi is assumed.
+	XResolution int
+	YResolution int
+}
+
+func (p *Page) resolution() (x, y int) {
+	x, y = p.XResolution, p.YResolution
+	if x <= 0 {
+		x = 300
+	}
+	if y <= 0 {
+		y = 300
+	}
+	return x, y
+}
+
+// writer keeps track of object offsets for the cross-reference table.
+type writer struct {
+	w       *bufio.Writer
+	offset  int64
+	offsets []int64 // by object number - 1
+}
+
+func (w *writer) printf(format string, args ...interface{}) {
+	n, _ := fmt.Fprintf(w.w, format, args...)
+	w.offset += int64(n)
+}
+
+func (w *writer) write(b []byte) {
+	n, _ := w.w.Write(b)
+	w.offset += int64(n)
+}
+
+// object starts object number num (which must be the next object number).
+func (w *writer) object(num int) {
+	w.offsets = append(w.offsets, w.offset)
+	w.printf("%d 0 obj\n", num)
+}
+
+func colorSpace(m color.Model) (string, error) {
+	switch m {
+	case color.GrayModel:
+		return "/DeviceGray", nil
+	case color.YCbCrModel:
+		return "/DeviceRGB", nil
+	case color.CMYKModel:
+		return "/DeviceCMYK", nil
+	}
+	return "", fmt.Errorf("unsupported JPEG color model %T", m)
+}
+
+// Write writes a PDF document containing pages to w.
+func Write(w io.Writer, pages []Page) error {
+	if len(pages) == 0 {
+		return fmt.Errorf("no pages")
+	}
+	configs := make([]image.Config, len(pages))
+	for idx, p := range pages {
+		cfg, err := jpeg.DecodeConfig(bytes.NewReader(p.JPEG))
+		if err != nil {
+			return fmt.Errorf("page %d: %v", idx+1, err)
+		}
+		configs[idx] = cfg
+	}
+
+	pw := &writer{w: bufio.NewWriter(w)}
+	// The binary comment marks the file as containing binary data:
+	pw.printf("%%PDF-1.4\n%%\xe2\xe3\xcf\xd3\n")
+
+	// Object numbers: 1 is the catalog, 2 the page tree, followed by three
+	// objects per page (page, content stream, image).
+	const pagesObj = 2
+	pageObj := func(idx int) int { return 3 + 3*idx }
+
+	pw.object(1)
+	pw.printf("<< /Type /Catalog /Pages %d 0 R >>\nendobj\n", pagesObj)
+
+	pw.object(pagesObj)
+	pw.printf("<< /Type /Pages /Kids [")
+	for idx := range pages {
+		pw.printf(" %d 0 R", pageObj(idx))
+	}
+	pw.printf(" ] /Count %d >>\nendobj\n", len(pages))
+
+	for idx, p := range pages {
+		cfg := configs[idx]
+		cs, err := colorSpace(cfg.ColorModel)
+		if err != nil {
+			return fmt.Errorf("page %d: %v", idx+1, err)
+		}
+		xres, yres := p.resolution()
+		// PDF units are 1/72 inch:
+		width := float64(cfg.Width) * 72 / float64(xres)
+		height := float64(cfg.Height) * 72 / float64(yres)
+		num := pageObj(idx)
+
+		pw.object(num)
+		pw.printf("<< /Type /Page /Parent %d 0 R /MediaBox [0 0 %.2f %.2f] /Resources << /XObject << /Im0 %d 0 R >> >> /Contents %d 0 R >>\nendobj\n",
+			pagesObj, width, height, num+2, num+1)
+
+		content := fmt.Sprintf("q %.2f 0 0 %.2f 0 0 cm /Im0 Do Q\n", width, height)
+		pw.object(num + 1)
+		pw.printf("<< /Length %d >>\nstream\n%s\nendstream\nendobj\n", len(content), content)
+
+		pw.object(num + 2)
+		pw.printf("<< /Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace %s /BitsPerComponent 8 /Filter /DCTDecode",
+			cfg.Width, cfg.Height, cs)
+		if cs == "/DeviceCMYK" {
+			// Adobe applications write inverted CMYK JPEGs:
+			pw.printf(" /Decode [1 0 1 0 1 0 1 0]")
+		}
+		pw.printf(" /Length %d >>\nstream\n", len(p.JPEG))
+		pw.write(p.JPEG)
+		pw.printf("\nendstream\nendobj\n")
+	}
+
+	xref := pw.offset
+	pw.printf("xref\n0 %d\n", len(pw.offsets)+1)
+	// Each entry must be exactly 20 bytes long, including the line ending:
+	pw.printf("0000000000 65535 f \n")
+	for _, off := range pw.offsets {
+		pw.printf("%010d 00000 n \n", off)
+	}
+	pw.printf("trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(pw.offsets)+1, xref)
+	return pw.w.Flush()
+}
@@ -0,0 +1,127 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pdf_test
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stapelberg/airscan/internal/pdf"
+)
+
+func encodeJPEG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestWrite(t *testing.T) {
+	gray := encodeJPEG(t, image.NewGray(image.Rect(0, 0, 300, 600)))
+	rgb := encodeJPEG(t, image.NewRGBA(image.Rect(0, 0, 150, 150)))
+	var buf bytes.Buffer
+	if err := pdf.Write(&buf, []pdf.Page{
+		{JPEG: gray, XResolution: 300, YResolution: 300},
+		{JPEG: rgb, XResolution: 150, YResolution: 150},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	b := buf.Bytes()
+
+	if !bytes.HasPrefix(b, []byte("%PDF-1.4\n")) {
+		t.Errorf("PDF header missing")
+	}
+	if !bytes.HasSuffix(b, []byte("%%EOF\n")) {
+		t.Errorf("PDF trailer missing")
+	}
+	for _, want := range []string{
+		"/Count 2",
+		"/MediaBox [0 0 72.00 144.00]",
+		"/MediaBox [0 0 72.00 72.00]",
+		"/ColorSpace /DeviceGray",
+		"/ColorSpace /DeviceRGB",
+		fmt.Sprintf("/Length %d >>\nstream\n", len(gray)),
+		fmt.Sprintf("/Length %d >>\nstream\n", len(rgb)),
+	} {
+		if !bytes.Contains(b, []byte(want)) {
+			t.Errorf("PDF does not contain %q", want)
+		}
+	}
+
+	// Verify that the cross-reference table points to the objects:
+	m := regexp.MustCompile(`startxref\n(\d+)\n`).FindSubmatch(b)
+	if m == nil {
+		t.Fatalf("startxref not found")
+	}
+	xref, err := strconv.Atoi(string(m[1]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(b[xref:], []byte("xref\n")) {
+		t.Fatalf("startxref does not point to xref table")
+	}
+	lines := strings.Split(string(b[xref:]), "\n")
+	// lines[0] is "xref", lines[1] the subsection header, lines[2] the free entry:
+	for num := 1; num <= 8; num++ {
+		entry := lines[2+num]
+		off, err := strconv.Atoi(strings.Fields(entry)[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := fmt.Sprintf("%d 0 obj\n", num); !bytes.HasPrefix(b[off:], []byte(want)) {
+			t.Errorf("xref entry for object %d does not point to %q", num, want)
+		}
+	}
+}
+
+func TestJPEGs(t *testing.T) {
+	gray := encodeJPEG(t, image.NewGray(image.Rect(0, 0, 30, 60)))
+	rgb := encodeJPEG(t, image.NewRGBA(image.Rect(0, 0, 15, 15)))
+	var buf bytes.Buffer
+	if err := pdf.Write(&buf, []pdf.Page{{JPEG: gray}, {JPEG: rgb}}); err != nil {
+		t.Fatal(err)
+	}
+	images, err := pdf.JPEGs(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(images), 2; got != want {
+		t.Fatalf("unexpected number of images: got %d, want %d", got, want)
+	}
+	for idx, want := range [][]byte{gray, rgb} {
+		if !bytes.Equal(images[idx], want) {
+			t.Errorf("image %d differs from the embedded JPEG", idx)
+		}
+	}
+
+	if _, err := pdf.JPEGs([]byte("not a pdf")); err == nil {
+		t.Errorf("JPEGs unexpectedly succeeded on non-PDF input")
+	}
+}
+
+func TestWriteNotJPEG(t *testing.T) {
+	var buf bytes.Buffer
+	if err := pdf.Write(&buf, []pdf.Page{{JPEG: []byte("not a jpeg")}}); err == nil {
+		t.Fatalf("Write unexpectedly succeeded")
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package scanopts converts the scan options which users specify (as airscan1
+// flags or in airscand API requests) into airscan.ScanSettings.
+package scanopts
+
+import (
+	"fmt"
+
+	"github.com/stapelberg/airscan"
+	"github.com/stapelberg/airscan/preset"
+)
+
+// Options are the user-specified scan options. The JSON names correspond to
+// airscan1’s flags of the same name.
+type Options struct {
+	Source string `json:"source"` // platen or adf
+	Size   string `json:"size"`   // see airscan.ParsePaperSize
+
+	// XOffset and YOffset are lengths with unit (see airscan.ParseLength).
+	// If empty, the scan region is placed according to the ADF’s
+	// justification (if any), so that an explicit 0 overrides it.
+	XOffset string `json:"x_offset"`
+	YOffset string `json:"y_offset"`
+
+	Resolution  int    `json:"resolution"`
+	Intent      string `json:"intent"`
+	ContentType string `json:"content_type"`
+	Brightness  *int   `json:"brightness"`
+	Contrast    *int   `json:"contrast"`
+	Format      string `json:"format"`
+	Color       string `json:"color"`
+	Duplex      bool   `json:"duplex"`
+}
+
+// ScanSettings converts o into ScanSettings for the device with the specified
+// capabilities. The settings are not validated, see airscan.ScanSettings.Validate.
+func (o *Options) ScanSettings(caps *airscan.ScannerCapabilities) (*airscan.ScanSettings, error) {
+	settings := preset.GrayscaleA4ADF()
+	switch o.Source {
+	case "platen":
+		settings.InputSource = "Platen"
+	case "adf":
+	default:
+		return nil, fmt.Errorf("unexpected source: got %q, want one of platen or adf", o.Source)
+	}
+	paperSize, err := airscan.ParsePaperSize(o.Size)
+	if err != nil {
+		return nil, err
+	}
+	xOffset, err := parseOffset(o.XOffset)
+	if err != nil {
+		return nil, err
+	}
+	yOffset, err := parseOffset(o.YOffset)
+	if err != nil {
+		return nil, err
+	}
+	settings.DocumentFormat = o.Format
+	settings.ColorMode = o.Color
+	settings.XResolution = o.Resolution
+	settings.YResolution = o.Resolution
+	settings.Intent = o.Intent
+	settings.ContentType = o.ContentType
+	settings.Brightness = o.Brightness
+	settings.Contrast = o.Contrast
+	settings.Duplex = o.Duplex
+
+	// Clamp the region to the device’s scan area and place it according to
+	// the ADF’s justification (if any):
+	region := caps.Region(paperSize, settings.InputSource, settings.Duplex)
+	if region == nil {
+		region = paperSize.Region()
+	}
+	if xOffset != nil {
+		region.XOffset = *xOffset
+	}
+	if yOffset != nil {
+		region.YOffset = *yOffset
+	}
+	settings.ScanRegions.Regions = []*airscan.ScanRegion{region}
+	return settings, nil
+}
+
+// parseOffset parses an optional length: it returns nil if s is empty.
+func parseOffset(s string) (*int, error) {
+	if s == "" {
+		return nil, nil
+	}
+	v, err := airscan.ParseLength(s)
+	if err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
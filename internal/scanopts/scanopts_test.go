@@ -0,0 +1,71 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scanopts_test
+
+import (
+	"encoding/xml"
+	"os"
+	"testing"
+
+	"github.com/stapelberg/airscan"
+	"github.com/stapelberg/airscan/internal/scanopts"
+)
+
+func TestScanSettingsOffsets(t *testing.T) {
+	b, err := os.ReadFile("../../resources/eSCL/ScannerCapabilities.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var caps airscan.ScannerCapabilities
+	if err := xml.Unmarshal(b, &caps); err != nil {
+		t.Fatal(err)
+	}
+	a5, ok := airscan.LookupPaperSize("A5")
+	if !ok {
+		t.Fatalf("paper size A5 not found")
+	}
+	// The ADF centers documents horizontally:
+	justified := caps.Region(a5, "Feeder", false).XOffset
+	if justified == 0 {
+		t.Fatalf("A5 on the ADF unexpectedly not centered")
+	}
+
+	for _, tt := range []struct {
+		xOffset string
+		want    int
+	}{
+		{"", justified},
+		{"0", 0},
+		{"1in", 300},
+	} {
+		t.Run(tt.xOffset, func(t *testing.T) {
+			opts := scanopts.Options{
+				Source:     "adf",
+				Size:       "A5",
+				XOffset:    tt.xOffset,
+				Resolution: 300,
+				Format:     "image/jpeg",
+				Color:      "Grayscale8",
+			}
+			settings, err := opts.ScanSettings(&caps)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := settings.ScanRegions.Regions[0].XOffset; got != tt.want {
+				t.Errorf("unexpected XOffset: got %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package airscan
+
+import (
+	"context"
+	"errors"
+	"io"
+	"iter"
+)
+
+// A Page is one fully received page of a scan job.
+type Page struct {
+	// Number is the number of the page (starting at 1) within the scan job.
+	Number int
+
+	// Data is the scan data as sent by the device, see CurrentPage.
+	Data []byte
+}
+
+// Pages returns an iterator over the pages of the scan job, which is an
+// alternative to calling ScanPage, CurrentPage and Err. Each page is fully
+// received before it is yielded, so pages can be handed to other goroutines.
+//
+// All requests use ctx. An error ends the iteration. Once the iteration ends
+// (including when the loop is exited early), the scan job is deleted from the
+// device, see Close. Errors from Close are yielded (joined with the error which
+// ended the iteration, if any), or, if the loop was exited early, available via
+// Err.
+//
+// The iterator must only be used once.
+func (s *ScanState) Pages(ctx context.Context) iter.Seq2[*Page, error] {
+	return func(yield func(*Page, error) bool) {
+		s.ctx = ctx
+		number := 0
+		for s.ScanPage() {
+			b, err := io.ReadAll(s.CurrentPage())
+			if err != nil {
+				yield(nil, errors.Join(err, s.Close()))
+				return
+			}
+			number++
+			if !yield(&Page{Number: number, Data: b}, nil) {
+				if err := s.Close(); err != nil && s.err == nil {
+					s.err = err
+				}
+				return
+			}
+		}
+		if err := s.Err(); err != nil {
+			yield(nil, errors.Join(err, s.Close()))
+			return
+		}
+		if err := s.Close(); err != nil {
+			yield(nil, err)
+		}
+	}
+}
+
+// ScanPages starts a new scan job using the specified settings (see Scan) and
+// returns an iterator over its pages (see ScanState.Pages). Errors creating
+// the scan job are yielded, too.
+//
+// All requests use ctx.
+func (c *Client) ScanPages(ctx context.Context, settings *ScanSettings) iter.Seq2[*Page, error] {
+	return func(yield func(*Page, error) bool) {
+		job, err := c.scan(ctx, settings)
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		job.Pages(ctx)(yield)
+	}
+}
@@ -0,0 +1,244 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package airscan
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ThreeHundredthsOfInches is the unit in which scan regions and the sizes in
+// ScannerCapabilities are expressed. Note that it is independent of the scan
+// resolution.
+const ThreeHundredthsOfInches = "escl:ThreeHundredthsOfInches"
+
+// MillimetersToUnits converts mm to escl:ThreeHundredthsOfInches.
+func MillimetersToUnits(mm float64) int {
+	return int(math.Round(mm / 25.4 * 300))
+}
+
+// InchesToUnits converts in to escl:ThreeHundredthsOfInches.
+func InchesToUnits(in float64) int {
+	return int(math.Round(in * 300))
+}
+
+// UnitsToMillimeters converts escl:ThreeHundredthsOfInches to mm.
+func UnitsToMillimeters(units int) float64 {
+	return float64(units) / 300 * 25.4
+}
+
+// UnitsToInches converts escl:ThreeHundredthsOfInches to inches.
+func UnitsToInches(units int) float64 {
+	return float64(units) / 300
+}
+
+// UnitsToPixels converts escl:ThreeHundredthsOfInches to pixels at the
+// specified resolution (in dpi).
+func UnitsToPixels(units, resolution int) int {
+	return int(math.Round(float64(units) * float64(resolution) / 300))
+}
+
+// A PaperSize is a named document size. Width and Height are in
+// escl:ThreeHundredthsOfInches.
+type PaperSize struct {
+	Name   string
+	Width  int
+	Height int
+}
+
+func mm(name string, width, height float64) PaperSize {
+	return PaperSize{name, MillimetersToUnits(width), MillimetersToUnits(height)}
+}
+
+func in(name string, width, height float64) PaperSize {
+	return PaperSize{name, InchesToUnits(width), InchesToUnits(height)}
+}
+
+// isoSeries returns sizes 0 to 10 of an ISO 216/269 series, which are defined
+// by halving the previous size’s longer side (rounding down to the mm).
+func isoSeries(prefix string, width, height int) []PaperSize {
+	sizes := make([]PaperSize, 0, 11)
+	for i := 0; i <= 10; i++ {
+		sizes = append(sizes, mm(prefix+strconv.Itoa(i), float64(width), float64(height)))
+		width, height = height/2, width
+	}
+	return sizes
+}
+
+// PaperSizes is the catalog of paper sizes known by name to LookupPaperSize
+// and ParsePaperSize.
+var PaperSizes = func() []PaperSize {
+	var sizes []PaperSize
+	sizes = append(sizes, isoSeries("A", 841, 1189)...)
+	sizes = append(sizes, isoSeries("B", 1000, 1414)...)
+	sizes = append(sizes, isoSeries("C", 917, 1297)...)
+	return append(sizes,
+		// North American sizes:
+		in("letter", 8.5, 11),
+		in("legal", 8.5, 14),
+		in("tabloid", 11, 17),
+		in("ledger", 17, 11), // landscape tabloid
+		in("executive", 7.25, 10.5),
+		in("statement", 5.5, 8.5),
+
+		// Photo sizes:
+		in("photo-3.5x5", 3.5, 5),
+		in("photo-4x6", 4, 6),
+		in("photo-5x7", 5, 7),
+		in("photo-8x10", 8, 10),
+		mm("photo-10x15", 100, 150),
+		mm("photo-13x18", 130, 180),
+
+		// Cards:
+		in("business-card", 3.5, 2),
+		mm("business-card-eu", 85, 55),
+		mm("id-1", 85.6, 53.98), // credit cards, ID cards
+		in("index-card-3x5", 3, 5),
+		in("index-card-4x6", 4, 6),
+	)
+}()
+
+// normalizePaperSizeName allows matching e.g. “business card”, “businesscard”
+// and “Business-Card”.
+func normalizePaperSizeName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '-', '_', ' ':
+			return -1
+		}
+		return r
+	}, strings.ToLower(name))
+}
+
+// LookupPaperSize returns the paper size with the specified name from
+// PaperSizes (case-insensitively, ignoring dashes and spaces).
+func LookupPaperSize(name string) (PaperSize, bool) {
+	norm := normalizePaperSizeName(name)
+	for _, p := range PaperSizes {
+		if normalizePaperSizeName(p.Name) == norm {
+			return p, true
+		}
+	}
+	return PaperSize{}, false
+}
+
+// ParseLength parses a length such as 10mm or 0.5in, returning
+// escl:ThreeHundredthsOfInches.
+func ParseLength(s string) (int, error) {
+	if s == "0" {
+		return 0, nil
+	}
+	var toUnits func(float64) int
+	var num string
+	switch {
+	case strings.HasSuffix(s, "mm"):
+		toUnits, num = MillimetersToUnits, strings.TrimSuffix(s, "mm")
+	case strings.HasSuffix(s, "in"):
+		toUnits, num = InchesToUnits, strings.TrimSuffix(s, "in")
+	default:
+		return 0, fmt.Errorf("length %q: missing unit, want e.g. 10mm or 0.5in", s)
+	}
+	f, err := strconv.ParseFloat(num, 64)
+	if err != nil || f < 0 {
+		return 0, fmt.Errorf("length %q: invalid number %q", s, num)
+	}
+	return toUnits(f), nil
+}
+
+// ParsePaperSize parses either the name of a paper size (see LookupPaperSize)
+// or width x height with unit, e.g. 210x297mm or 8.5x11in.
+func ParsePaperSize(s string) (PaperSize, error) {
+	if p, ok := LookupPaperSize(s); ok {
+		return p, nil
+	}
+	w, h, ok := strings.Cut(s, "x")
+	if !ok {
+		return PaperSize{}, fmt.Errorf("unknown paper size %q, want a name (e.g. A4, letter, business-card) or e.g. 210x297mm or 8.5x11in", s)
+	}
+	// The unit is specified once, at the end:
+	unit := strings.TrimLeft(h, "0123456789.")
+	width, err := ParseLength(w + unit)
+	if err != nil {
+		return PaperSize{}, err
+	}
+	height, err := ParseLength(h)
+	if err != nil {
+		return PaperSize{}, err
+	}
+	return PaperSize{Name: s, Width: width, Height: height}, nil
+}
+
+// Region returns a ScanRegion of this paper size at offset 0, 0.
+func (p PaperSize) Region() *ScanRegion {
+	return &ScanRegion{
+		ContentRegionUnits: ThreeHundredthsOfInches,
+		Width:              p.Width,
+		Height:             p.Height,
+	}
+}
+
+// Clamp reduces the region’s width and height (keeping its offset) so that it
+// fits into the maximum scan area of ic, and increases them to the minimum.
+func (r *ScanRegion) Clamp(ic *InputCaps) {
+	if ic.MaxWidth > 0 && r.XOffset+r.Width > ic.MaxWidth {
+		r.Width = ic.MaxWidth - r.XOffset
+	}
+	if ic.MaxHeight > 0 && r.YOffset+r.Height > ic.MaxHeight {
+		r.Height = ic.MaxHeight - r.YOffset
+	}
+	if r.Width < ic.MinWidth {
+		r.Width = ic.MinWidth
+	}
+	if r.Height < ic.MinHeight {
+		r.Height = ic.MinHeight
+	}
+}
+
+// justify returns the offset at which a document of size within an area of
+// max is placed for the specified eSCL image position.
+func justify(position string, size, max int) int {
+	if max <= size {
+		return 0
+	}
+	switch position {
+	case "Center":
+		return (max - size) / 2
+	case "Right", "Bottom":
+		return max - size
+	}
+	return 0 // Left, Top, or unspecified
+}
+
+// Region returns a ScanRegion for a document of paper size p on the specified
+// input source (Platen or Feeder). The region is clamped to the maximum scan
+// area. On an ADF which justifies documents (e.g. centered, see
+// Adf.Justification), the region is placed accordingly. Returns nil if the
+// device does not have the input source.
+func (c *ScannerCapabilities) Region(p PaperSize, inputSource string, duplex bool) *ScanRegion {
+	ic := c.InputCaps(inputSource, duplex)
+	if ic == nil {
+		return nil
+	}
+	r := p.Region()
+	r.Clamp(ic)
+	if inputSource == "Feeder" {
+		j := c.Adf.Justification
+		r.XOffset = justify(j.XImagePosition, r.Width, ic.MaxWidth)
+		r.YOffset = justify(j.YImagePosition, r.Height, ic.MaxHeight)
+	}
+	return r
+}
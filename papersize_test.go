@@ -0,0 +1,139 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package airscan_test
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/stapelberg/airscan"
+)
+
+func TestParsePaperSize(t *testing.T) {
+	for _, tt := range []struct {
+		in            string
+		width, height int
+	}{
+		// Same as the preset and former airscan1 hard-coded sizes:
+		{"A4", 2480, 3508},
+		{"letter", 2550, 3300},
+		{"tabloid", 3300, 5100},
+		{"ledger", 5100, 3300},
+
+		{"a5", 1748, 2480},
+		{"A10", 307, 437},
+		{"B5", 2079, 2953},
+		{"C6", 1346, 1913},
+		{"Business Card", 1050, 600},
+		{"businesscard", 1050, 600},
+		{"210x297mm", 2480, 3508},
+		{"8.5x11in", 2550, 3300},
+	} {
+		t.Run(tt.in, func(t *testing.T) {
+			p, err := airscan.ParsePaperSize(tt.in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p.Width != tt.width || p.Height != tt.height {
+				t.Fatalf("ParsePaperSize(%q) = %dx%d, want %dx%d", tt.in, p.Width, p.Height, tt.width, tt.height)
+			}
+		})
+	}
+
+	for _, in := range []string{"A11", "10x10", "axbmm", "-1x2in"} {
+		if _, err := airscan.ParsePaperSize(in); err == nil {
+			t.Errorf("ParsePaperSize(%q) unexpectedly succeeded", in)
+		}
+	}
+}
+
+func TestUnitConversion(t *testing.T) {
+	if got, want := airscan.MillimetersToUnits(25.4), 300; got != want {
+		t.Errorf("MillimetersToUnits(25.4) = %d, want %d", got, want)
+	}
+	if got, want := airscan.UnitsToInches(2550), 8.5; got != want {
+		t.Errorf("UnitsToInches(2550) = %v, want %v", got, want)
+	}
+	if got, want := airscan.UnitsToPixels(2550, 600), 5100; got != want {
+		t.Errorf("UnitsToPixels(2550, 600) = %d, want %d", got, want)
+	}
+}
+
+func TestCapabilitiesRegion(t *testing.T) {
+	caps := mockCapabilities(t)
+	a5, _ := airscan.LookupPaperSize("A5")
+	legal, _ := airscan.LookupPaperSize("legal")
+	a3, _ := airscan.LookupPaperSize("A3")
+
+	for _, tt := range []struct {
+		name        string
+		size        airscan.PaperSize
+		inputSource string
+		want        *airscan.ScanRegion
+	}{
+		{
+			name:        "PlatenTopLeft",
+			size:        a5,
+			inputSource: "Platen",
+			want: &airscan.ScanRegion{
+				ContentRegionUnits: airscan.ThreeHundredthsOfInches,
+				Width:              1748,
+				Height:             2480,
+			},
+		},
+
+		{
+			// The mock ADF justifies documents centered, at the top:
+			name:        "FeederCentered",
+			size:        a5,
+			inputSource: "Feeder",
+			want: &airscan.ScanRegion{
+				ContentRegionUnits: airscan.ThreeHundredthsOfInches,
+				Width:              1748,
+				Height:             2480,
+				XOffset:            (2551 - 1748) / 2,
+			},
+		},
+
+		{
+			name:        "FeederFullWidth",
+			size:        legal,
+			inputSource: "Feeder",
+			want: &airscan.ScanRegion{
+				ContentRegionUnits: airscan.ThreeHundredthsOfInches,
+				Width:              2550,
+				Height:             4200,
+			},
+		},
+
+		{
+			name:        "PlatenClamped",
+			size:        a3,
+			inputSource: "Platen",
+			want: &airscan.ScanRegion{
+				ContentRegionUnits: airscan.ThreeHundredthsOfInches,
+				Width:              2551,
+				Height:             4200,
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got := caps.Region(tt.size, tt.inputSource, false)
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Fatalf("unexpected region: diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,367 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package photosplit detects separate rectangular objects (e.g. photo prints)
+// in a scan of the full platen, and crops and deskews each into its own image.
+//
+// Objects are told apart from the background by brightness, i.e. this works
+// best with the scanner lid open or a white lid, and with photos that do not
+// have a white border.
+package photosplit
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"sort"
+)
+
+// Options configure Split. The zero value uses the defaults.
+type Options struct {
+	// MinSize is the minimum width and height (in pixels) of an object.
+	// Smaller objects (e.g. dust or scratches) are ignored. Defaults to 1/20
+	// of the shorter side of the scanned image.
+	MinSize int
+
+	// Threshold is the luminance (0-255) below which pixels are considered
+	// part of an object instead of the (bright) background. Defaults to 220.
+	Threshold uint8
+}
+
+// An Object is a detected rectangular object.
+type Object struct {
+	// Center of the object in the coordinate space of the scanned image.
+	Center struct{ X, Y float64 }
+
+	// Width and Height of the object in pixels (after deskewing).
+	Width, Height int
+
+	// Angle (in radians) by which the object is rotated clockwise in the
+	// scanned image, between -π/4 and π/4.
+	Angle float64
+}
+
+// Split detects the objects in img and returns one cropped and deskewed image
+// per object, ordered top to bottom, then left to right.
+func Split(img image.Image, opts Options) []image.Image {
+	objects := Detect(img, opts)
+	result := make([]image.Image, len(objects))
+	for idx, o := range objects {
+		result[idx] = Extract(img, o)
+	}
+	return result
+}
+
+// Detect returns the objects in img, ordered top to bottom, then left to
+// right.
+func Detect(img image.Image, opts Options) []Object {
+	b := img.Bounds()
+	shorter := b.Dx()
+	if b.Dy() < shorter {
+		shorter = b.Dy()
+	}
+	if opts.MinSize == 0 {
+		opts.MinSize = shorter / 20
+	}
+	if opts.Threshold == 0 {
+		opts.Threshold = 220
+	}
+
+	// Work on a grid of cells to keep the number of pixels to analyze
+	// manageable at high resolutions:
+	cell := shorter / 500
+	if cell < 1 {
+		cell = 1
+	}
+	m := newMask(img, cell, opts.Threshold)
+	// Close small gaps, e.g. bright areas within a photo:
+	const radius = 2
+	m = m.dilate(radius).erode(radius)
+
+	var objects []Object
+	for _, component := range m.components() {
+		hull := convexHull(component, cell, b.Min)
+		o, ok := minAreaRect(hull)
+		if !ok {
+			continue
+		}
+		if o.Width < opts.MinSize || o.Height < opts.MinSize {
+			continue
+		}
+		objects = append(objects, o)
+	}
+	sort.Slice(objects, func(i, j int) bool {
+		oi, oj := objects[i], objects[j]
+		// Objects whose centers are within MinSize vertically are
+		// considered to be in the same row:
+		if math.Abs(oi.Center.Y-oj.Center.Y) >= float64(opts.MinSize) {
+			return oi.Center.Y < oj.Center.Y
+		}
+		return oi.Center.X < oj.Center.X
+	})
+	return objects
+}
+
+// mask is a binary image on the cell grid: true means object, false means
+// background.
+type mask struct {
+	w, h int
+	bits []bool
+}
+
+func newMask(img image.Image, cell int, threshold uint8) *mask {
+	b := img.Bounds()
+	m := &mask{
+		w: (b.Dx() + cell - 1) / cell,
+		h: (b.Dy() + cell - 1) / cell,
+	}
+	m.bits = make([]bool, m.w*m.h)
+	lum := luminance(img)
+	for y := 0; y < m.h; y++ {
+		for x := 0; x < m.w; x++ {
+			// Average luminance of the cell:
+			var sum, n int
+			for py := b.Min.Y + y*cell; py < b.Min.Y+(y+1)*cell && py < b.Max.Y; py++ {
+				for px := b.Min.X + x*cell; px < b.Min.X+(x+1)*cell && px < b.Max.X; px++ {
+					sum += int(lum(px, py))
+					n++
+				}
+			}
+			m.bits[y*m.w+x] = sum/n < int(threshold)
+		}
+	}
+	return m
+}
+
+// luminance returns a func which returns the luminance of the pixel at x, y.
+// Scans decode to *image.YCbCr (JPEG) or *image.Gray (grayscale JPEG and PNG)
+// in most cases, whose luminance is read directly: converting each pixel of a
+// high-resolution scan via img.At is slow.
+func luminance(img image.Image) func(x, y int) uint8 {
+	switch img := img.(type) {
+	case *image.YCbCr:
+		return func(x, y int) uint8 { return img.Y[img.YOffset(x, y)] }
+	case *image.Gray:
+		return func(x, y int) uint8 { return img.Pix[img.PixOffset(x, y)] }
+	}
+	return func(x, y int) uint8 {
+		return color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y
+	}
+}
+
+func (m *mask) at(x, y int) bool {
+	if x < 0 || y < 0 || x >= m.w || y >= m.h {
+		return false
+	}
+	return m.bits[y*m.w+x]
+}
+
+// morph sets each cell to value if any cell within radius has value.
+func (m *mask) morph(radius int, value bool) *mask {
+	result := &mask{w: m.w, h: m.h, bits: make([]bool, len(m.bits))}
+	for y := 0; y < m.h; y++ {
+		for x := 0; x < m.w; x++ {
+			v := m.bits[y*m.w+x]
+			for dy := -radius; dy <= radius && v != value; dy++ {
+				for dx := -radius; dx <= radius; dx++ {
+					nx, ny := x+dx, y+dy
+					if nx < 0 || ny < 0 || nx >= m.w || ny >= m.h {
+						continue
+					}
+					if m.bits[ny*m.w+nx] == value {
+						v = value
+						break
+					}
+				}
+			}
+			result.bits[y*m.w+x] = v
+		}
+	}
+	return result
+}
+
+func (m *mask) dilate(radius int) *mask { return m.morph(radius, true) }
+func (m *mask) erode(radius int) *mask  { return m.morph(radius, false) }
+
+// components returns the boundary cells of each 4-connected component.
+func (m *mask) components() [][]image.Point {
+	var result [][]image.Point
+	seen := make([]bool, len(m.bits))
+	for start := range m.bits {
+		if !m.bits[start] || seen[start] {
+			continue
+		}
+		var boundary []image.Point
+		queue := []int{start}
+		seen[start] = true
+		for len(queue) > 0 {
+			idx := queue[0]
+			queue = queue[1:]
+			x, y := idx%m.w, idx/m.w
+			neighbors := []image.Point{{x - 1, y}, {x + 1, y}, {x, y - 1}, {x, y + 1}}
+			inner := true
+			for _, n := range neighbors {
+				if !m.at(n.X, n.Y) {
+					inner = false
+					continue
+				}
+				nidx := n.Y*m.w + n.X
+				if !seen[nidx] {
+					seen[nidx] = true
+					queue = append(queue, nidx)
+				}
+			}
+			if !inner {
+				boundary = append(boundary, image.Pt(x, y))
+			}
+		}
+		result = append(result, boundary)
+	}
+	return result
+}
+
+type point struct{ x, y float64 }
+
+// convexHull returns the convex hull (in image coordinates) of the cells,
+// using Andrew’s monotone chain algorithm.
+func convexHull(cells []image.Point, cell int, origin image.Point) []point {
+	pts := make([]point, 0, 4*len(cells))
+	for _, c := range cells {
+		// Use all corners of the cell so that the hull covers it entirely:
+		x0 := float64(origin.X + c.X*cell)
+		y0 := float64(origin.Y + c.Y*cell)
+		x1, y1 := x0+float64(cell), y0+float64(cell)
+		pts = append(pts, point{x0, y0}, point{x1, y0}, point{x0, y1}, point{x1, y1})
+	}
+	sort.Slice(pts, func(i, j int) bool {
+		if pts[i].x != pts[j].x {
+			return pts[i].x < pts[j].x
+		}
+		return pts[i].y < pts[j].y
+	})
+	cross := func(o, a, b point) float64 {
+		return (a.x-o.x)*(b.y-o.y) - (a.y-o.y)*(b.x-o.x)
+	}
+	hull := make([]point, 0, 2*len(pts))
+	for _, p := range pts { // lower hull
+		for len(hull) >= 2 && cross(hull[len(hull)-2], hull[len(hull)-1], p) <= 0 {
+			hull = hull[:len(hull)-1]
+		}
+		hull = append(hull, p)
+	}
+	lower := len(hull) + 1
+	for i := len(pts) - 2; i >= 0; i-- { // upper hull
+		p := pts[i]
+		for len(hull) >= lower && cross(hull[len(hull)-2], hull[len(hull)-1], p) <= 0 {
+			hull = hull[:len(hull)-1]
+		}
+		hull = append(hull, p)
+	}
+	return hull[:len(hull)-1]
+}
+
+// minAreaRect returns the minimum-area rectangle enclosing the convex hull. One
+// of its sides is collinear with a hull edge, so it suffices to try each edge
+// direction.
+func minAreaRect(hull []point) (Object, bool) {
+	if len(hull) < 3 {
+		return Object{}, false
+	}
+	var best Object
+	bestArea := math.Inf(1)
+	for i := range hull {
+		a, b := hull[i], hull[(i+1)%len(hull)]
+		angle := math.Atan2(b.y-a.y, b.x-a.x)
+		// Normalize to (-π/4, π/4], the rectangle is the same for any
+		// multiple of π/2:
+		for angle > math.Pi/4 {
+			angle -= math.Pi / 2
+		}
+		for angle <= -math.Pi/4 {
+			angle += math.Pi / 2
+		}
+		sin, cos := math.Sincos(angle)
+		minU, maxU := math.Inf(1), math.Inf(-1)
+		minV, maxV := math.Inf(1), math.Inf(-1)
+		for _, p := range hull {
+			// Rotate by -angle:
+			u := p.x*cos + p.y*sin
+			v := -p.x*sin + p.y*cos
+			minU, maxU = math.Min(minU, u), math.Max(maxU, u)
+			minV, maxV = math.Min(minV, v), math.Max(maxV, v)
+		}
+		area := (maxU - minU) * (maxV - minV)
+		if area >= bestArea {
+			continue
+		}
+		bestArea = area
+		cu, cv := (minU+maxU)/2, (minV+maxV)/2
+		best = Object{
+			Width:  int(math.Round(maxU - minU)),
+			Height: int(math.Round(maxV - minV)),
+			Angle:  angle,
+		}
+		// Rotate the center back by angle:
+		best.Center.X = cu*cos - cv*sin
+		best.Center.Y = cu*sin + cv*cos
+	}
+	return best, true
+}
+
+// Extract returns the deskewed contents of object o in img.
+func Extract(img image.Image, o Object) image.Image {
+	dst := image.NewRGBA(image.Rect(0, 0, o.Width, o.Height))
+	sin, cos := math.Sincos(o.Angle)
+	b := img.Bounds()
+	for y := 0; y < o.Height; y++ {
+		for x := 0; x < o.Width; x++ {
+			// Position relative to the object center (at the pixel center):
+			u := float64(x) + 0.5 - float64(o.Width)/2
+			v := float64(y) + 0.5 - float64(o.Height)/2
+			sx := o.Center.X + u*cos - v*sin
+			sy := o.Center.Y + u*sin + v*cos
+			dst.SetRGBA(x, y, bilinear(img, b, sx-0.5, sy-0.5))
+		}
+	}
+	return dst
+}
+
+// bilinear interpolates the color of img at (x, y), clamping to b.
+func bilinear(img image.Image, b image.Rectangle, x, y float64) color.RGBA {
+	x0, y0 := math.Floor(x), math.Floor(y)
+	fx, fy := x-x0, y-y0
+	clamp := func(v, min, max int) int {
+		if v < min {
+			return min
+		}
+		if v > max-1 {
+			return max - 1
+		}
+		return v
+	}
+	at := func(px, py int) [4]float64 {
+		r, g, bl, a := img.At(clamp(px, b.Min.X, b.Max.X), clamp(py, b.Min.Y, b.Max.Y)).RGBA()
+		return [4]float64{float64(r), float64(g), float64(bl), float64(a)}
+	}
+	ix, iy := int(x0), int(y0)
+	c00, c10 := at(ix, iy), at(ix+1, iy)
+	c01, c11 := at(ix, iy+1), at(ix+1, iy+1)
+	var result [4]uint8
+	for i := range result {
+		top := c00[i]*(1-fx) + c10[i]*fx
+		bottom := c01[i]*(1-fx) + c11[i]*fx
+		result[i] = uint8((top*(1-fy) + bottom*fy) / 257)
+	}
+	return color.RGBA{result[0], result[1], result[2], result[3]}
+}
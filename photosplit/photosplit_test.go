@@ -0,0 +1,141 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package photosplit_test
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"math"
+	"testing"
+
+	"github.com/stapelberg/airscan/photosplit"
+)
+
+// drawRect draws a filled rectangle of w x h centered at cx, cy, rotated
+// clockwise by angle (radians).
+func drawRect(img *image.RGBA, cx, cy, w, h, angle float64, c color.RGBA) {
+	sin, cos := math.Sincos(angle)
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			dx, dy := float64(x)+0.5-cx, float64(y)+0.5-cy
+			u := dx*cos + dy*sin
+			v := -dx*sin + dy*cos
+			if math.Abs(u) <= w/2 && math.Abs(v) <= h/2 {
+				img.SetRGBA(x, y, c)
+			}
+		}
+	}
+}
+
+const skew = 10 * math.Pi / 180
+
+var (
+	red  = color.RGBA{R: 0xc0, A: 0xff}
+	blue = color.RGBA{B: 0xc0, A: 0xff}
+)
+
+// testScan returns a scan of two photos (one skewed) and some dust.
+func testScan() *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, 600, 400))
+	for i := range img.Pix {
+		img.Pix[i] = 0xff // white background
+	}
+	// Drawn in reverse order to verify sorting:
+	drawRect(img, 420, 250, 120, 80, skew, blue)
+	drawRect(img, 150, 125, 200, 150, 0, red)
+	// Dust, which should be ignored:
+	drawRect(img, 500, 50, 3, 3, 0, color.RGBA{A: 0xff})
+	return img
+}
+
+// checkObjects verifies that objects are the photos of testScan.
+func checkObjects(t *testing.T, objects []photosplit.Object) {
+	t.Helper()
+	if got, want := len(objects), 2; got != want {
+		t.Fatalf("unexpected number of objects: got %d, want %d (%+v)", got, want, objects)
+	}
+	for idx, want := range []struct {
+		cx, cy, w, h, angle float64
+	}{
+		{150, 125, 200, 150, 0},
+		{420, 250, 120, 80, skew},
+	} {
+		o := objects[idx]
+		const tolerance = 3 // pixels
+		if math.Abs(o.Center.X-want.cx) > tolerance ||
+			math.Abs(o.Center.Y-want.cy) > tolerance ||
+			math.Abs(float64(o.Width)-want.w) > tolerance ||
+			math.Abs(float64(o.Height)-want.h) > tolerance {
+			t.Errorf("object %d: got %+v, want center %v,%v size %vx%v", idx, o, want.cx, want.cy, want.w, want.h)
+		}
+		if math.Abs(o.Angle-want.angle) > 1*math.Pi/180 {
+			t.Errorf("object %d: unexpected angle: got %v°, want %v°", idx, o.Angle*180/math.Pi, want.angle*180/math.Pi)
+		}
+	}
+}
+
+func TestSplit(t *testing.T) {
+	img := testScan()
+	checkObjects(t, photosplit.Detect(img, photosplit.Options{}))
+
+	photos := photosplit.Split(img, photosplit.Options{})
+	if got, want := len(photos), 2; got != want {
+		t.Fatalf("unexpected number of photos: got %d, want %d", got, want)
+	}
+	// The deskewed photo should be blue all the way to (near) its corners:
+	photo := photos[1]
+	b := photo.Bounds()
+	for _, pt := range []image.Point{
+		b.Min.Add(image.Pt(3, 3)),
+		b.Max.Sub(image.Pt(4, 4)),
+		image.Pt(b.Min.X+3, b.Max.Y-4),
+		image.Pt(b.Max.X-4, b.Min.Y+3),
+	} {
+		if got := color.RGBAModel.Convert(photo.At(pt.X, pt.Y)); got != blue {
+			t.Errorf("photo 1: unexpected color at %v: got %v, want %v", pt, got, blue)
+		}
+	}
+}
+
+// TestDetectDecodedScans verifies detection in the image types which scans
+// decode to, whose luminance is read directly.
+func TestDetectDecodedScans(t *testing.T) {
+	img := testScan()
+
+	t.Run("YCbCr", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 95}); err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := jpeg.Decode(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := decoded.(*image.YCbCr); !ok {
+			t.Fatalf("unexpected image type: got %T, want *image.YCbCr", decoded)
+		}
+		checkObjects(t, photosplit.Detect(decoded, photosplit.Options{}))
+	})
+
+	t.Run("Gray", func(t *testing.T) {
+		gray := image.NewGray(img.Bounds())
+		draw.Draw(gray, gray.Bounds(), img, image.Point{}, draw.Src)
+		checkObjects(t, photosplit.Detect(gray, photosplit.Options{}))
+	})
+}
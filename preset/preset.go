@@ -33,8 +33,9 @@ func GrayscaleA4ADF() *airscan.ScanSettings {
 		ScanRegions: airscan.ScanRegions{
 			MustHonor: true,
 			Regions: []*airscan.ScanRegion{
-				// A4 at 300 dpi is 2480 x 3508 as per
-				// https://www.papersizes.org/a-sizes-in-pixels.htm
+				// A4 (210 x 297 mm) is 2480 x 3508 in
+				// escl:ThreeHundredthsOfInches, independent of the
+				// resolution. See also airscan.LookupPaperSize.
 				{
 					ContentRegionUnits: "escl:ThreeHundredthsOfInches",
 					Width:              2480,
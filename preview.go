@@ -0,0 +1,179 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package airscan
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"log"
+	"math"
+)
+
+// A Preview is a low-resolution scan of the full scan area of an input source,
+// typically displayed so that the user can select the region to scan.
+type Preview struct {
+	// Image is the decoded preview scan.
+	Image image.Image
+
+	// Settings are the settings with which the preview was scanned.
+	Settings *ScanSettings
+
+	// Region is the scanned region, i.e. the full scan area.
+	Region ScanRegion
+}
+
+// lowestResolution returns the lowest resolution which ic supports.
+func (ic *InputCaps) lowestResolution() (x, y int) {
+	for _, p := range ic.SettingProfiles.SettingProfile {
+		for _, r := range p.SupportedResolutions.DiscreteResolutions.DiscreteResolution {
+			if x == 0 || r.XResolution*r.YResolution < x*y {
+				x, y = r.XResolution, r.YResolution
+			}
+		}
+		if rr := p.SupportedResolutions.ResolutionRange; rr != nil {
+			if x == 0 || rr.XResolutionRange.Min*rr.YResolutionRange.Min < x*y {
+				x, y = rr.XResolutionRange.Min, rr.YResolutionRange.Min
+			}
+		}
+	}
+	return x, y
+}
+
+// first returns the first of preferred which is contained in values, or the
+// empty string.
+func first(values []string, preferred ...string) string {
+	for _, p := range preferred {
+		if contains(values, p) {
+			return p
+		}
+	}
+	return ""
+}
+
+// Preview scans the full scan area of the specified input source (Platen or
+// Feeder) at the lowest supported resolution, in a format which is fast to
+// transfer and can be decoded (JPEG, or PNG).
+//
+// Use the Preview’s ScanRegion method to convert a selection of preview pixels
+// into a ScanRegion for the subsequent scan.
+func (c *Client) Preview(ctx context.Context, inputSource string) (_ *Preview, err error) {
+	caps, err := c.ScannerCapabilitiesContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ic := caps.InputCaps(inputSource, false)
+	if ic == nil {
+		return nil, fmt.Errorf("input source %q not supported by this scanner", inputSource)
+	}
+	format := first(ic.DocumentFormats(), "image/jpeg", "image/png")
+	if format == "" {
+		return nil, fmt.Errorf("scanner supports neither image/jpeg nor image/png for previews")
+	}
+	xres, yres := ic.lowestResolution()
+	if xres == 0 {
+		return nil, fmt.Errorf("scanner capabilities do not specify any resolutions")
+	}
+	settings := &ScanSettings{
+		XmlnsScan: "http://schemas.hp.com/imaging/escl/2011/05/03",
+		XmlnsPWG:  "http://www.pwg.org/schemas/2010/12/sm",
+		Version:   "2.0",
+		Intent:    first(ic.SupportedIntents.Intent, "Preview"),
+		ScanRegions: ScanRegions{
+			MustHonor: true,
+			Regions: []*ScanRegion{
+				{
+					ContentRegionUnits: ThreeHundredthsOfInches,
+					Width:              ic.MaxWidth,
+					Height:             ic.MaxHeight,
+				},
+			},
+		},
+		DocumentFormat: format,
+		InputSource:    inputSource,
+		ColorMode:      first(ic.ColorModes(), "RGB24", "Grayscale8"),
+		XResolution:    xres,
+		YResolution:    yres,
+	}
+	if settings.ColorMode == "" && len(ic.ColorModes()) > 0 {
+		settings.ColorMode = ic.ColorModes()[0]
+	}
+	if c.debug {
+		log.Printf("preview settings: %+v", settings)
+	}
+
+	state, err := c.scan(ctx, settings)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if cerr := state.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}()
+	if !state.ScanPage() {
+		if err := state.Err(); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("scanner returned no preview page")
+	}
+	img, _, err := image.Decode(state.CurrentPage())
+	if err != nil {
+		return nil, fmt.Errorf("decoding preview: %v", err)
+	}
+	return &Preview{
+		Image:    img,
+		Settings: settings,
+		Region:   *settings.ScanRegions.Regions[0],
+	}, nil
+}
+
+// scale returns the number of escl:ThreeHundredthsOfInches per preview pixel.
+// The scale is derived from the actual image size, as devices might deliver
+// slightly different sizes than requested.
+func (p *Preview) scale() (x, y float64) {
+	b := p.Image.Bounds()
+	return float64(p.Region.Width) / float64(b.Dx()),
+		float64(p.Region.Height) / float64(b.Dy())
+}
+
+// ScanRegion converts r (in preview pixels) into a ScanRegion.
+func (p *Preview) ScanRegion(r image.Rectangle) *ScanRegion {
+	r = r.Sub(p.Image.Bounds().Min)
+	sx, sy := p.scale()
+	x0 := int(math.Round(float64(r.Min.X) * sx))
+	y0 := int(math.Round(float64(r.Min.Y) * sy))
+	x1 := int(math.Round(float64(r.Max.X) * sx))
+	y1 := int(math.Round(float64(r.Max.Y) * sy))
+	return &ScanRegion{
+		ContentRegionUnits: ThreeHundredthsOfInches,
+		XOffset:            p.Region.XOffset + x0,
+		YOffset:            p.Region.YOffset + y0,
+		Width:              x1 - x0,
+		Height:             y1 - y0,
+	}
+}
+
+// Rect converts r into preview pixels, e.g. to display a selection.
+func (p *Preview) Rect(r *ScanRegion) image.Rectangle {
+	sx, sy := p.scale()
+	x0 := float64(r.XOffset - p.Region.XOffset)
+	y0 := float64(r.YOffset - p.Region.YOffset)
+	return image.Rect(
+		int(math.Round(x0/sx)),
+		int(math.Round(y0/sy)),
+		int(math.Round((x0+float64(r.Width))/sx)),
+		int(math.Round((y0+float64(r.Height))/sy))).Add(p.Image.Bounds().Min)
+}
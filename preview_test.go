@@ -0,0 +1,80 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package airscan_test
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/jpeg"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/stapelberg/airscan"
+)
+
+func TestPreview(t *testing.T) {
+	// A tenth of the platen size (2551 x 4200 escl:ThreeHundredthsOfInches),
+	// as delivered by a device which scans previews at 30 dpi:
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, 255, 420)), nil); err != nil {
+		t.Fatal(err)
+	}
+	var requested []string
+	cl := clientFor(t, mockScannerWith(t, mockScannerConfig{
+		page:     buf.Bytes(),
+		pages:    1,
+		settings: func(s string) { requested = append(requested, s) },
+	}))
+
+	preview, err := cl.Preview(context.Background(), "Platen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := preview.Image.Bounds(), image.Rect(0, 0, 255, 420); got != want {
+		t.Errorf("unexpected preview bounds: got %v, want %v", got, want)
+	}
+	if got, want := len(requested), 1; got != want {
+		t.Fatalf("unexpected number of scan jobs: got %d, want %d", got, want)
+	}
+	for _, want := range []string{
+		"<scan:Intent>Preview</scan:Intent>",
+		"<pwg:DocumentFormat>image/jpeg</pwg:DocumentFormat>",
+		"<scan:ColorMode>RGB24</scan:ColorMode>",
+		"<scan:XResolution>300</scan:XResolution>",
+		"<pwg:Width>2551</pwg:Width>",
+	} {
+		if !strings.Contains(requested[0], want) {
+			t.Errorf("preview request does not contain %q:\n%s", want, requested[0])
+		}
+	}
+
+	selection := image.Rect(30, 30, 90, 60)
+	region := preview.ScanRegion(selection)
+	want := &airscan.ScanRegion{
+		ContentRegionUnits: airscan.ThreeHundredthsOfInches,
+		XOffset:            300,
+		YOffset:            300,
+		Width:              600,
+		Height:             300,
+	}
+	if diff := cmp.Diff(want, region); diff != "" {
+		t.Errorf("unexpected ScanRegion: diff (-want +got):\n%s", diff)
+	}
+	if got := preview.Rect(region); got != selection {
+		t.Errorf("Rect(ScanRegion(%v)) = %v, want %v", selection, got, selection)
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package airscan
+
+import (
+	"io"
+	"time"
+)
+
+// Progress describes how far along the transfer of a page is.
+type Progress struct {
+	// Page is the number of the page (starting at 1) within the scan job.
+	Page int
+
+	// BytesReceived is the number of bytes of scan data received so far.
+	BytesReceived int64
+
+	// ContentLength is the total number of bytes of the page as announced by
+	// the device, or -1 if unknown (e.g. chunked transfer encoding).
+	ContentLength int64
+
+	// Elapsed is the time since the device started sending the page.
+	Elapsed time.Duration
+
+	// Done is true once the page was received completely.
+	Done bool
+}
+
+// Throughput returns the average number of bytes received per second.
+func (p Progress) Throughput() float64 {
+	if p.Elapsed <= 0 {
+		return 0
+	}
+	return float64(p.BytesReceived) / p.Elapsed.Seconds()
+}
+
+// Remaining returns the estimated time until the page is received completely,
+// or -1 if the ContentLength is unknown.
+func (p Progress) Remaining() time.Duration {
+	tp := p.Throughput()
+	if p.ContentLength < 0 || tp == 0 {
+		return -1
+	}
+	return time.Duration(float64(p.ContentLength-p.BytesReceived) / tp * float64(time.Second))
+}
+
+// SetProgressFunc installs fn to be called while page data is read from
+// CurrentPage. fn is called from within Read, i.e. on the reading goroutine,
+// and should return quickly.
+func (s *ScanState) SetProgressFunc(fn func(Progress)) {
+	s.progress = fn
+}
+
+// progressReader reports the progress of reading from r to fn.
+type progressReader struct {
+	r     io.Reader
+	fn    func(Progress)
+	start time.Time
+	p     Progress
+}
+
+func newProgressReader(r io.Reader, fn func(Progress), page int, contentLength int64) *progressReader {
+	pr := &progressReader{
+		r:     r,
+		fn:    fn,
+		start: time.Now(),
+		p: Progress{
+			Page:          page,
+			ContentLength: contentLength,
+		},
+	}
+	fn(pr.p)
+	return pr
+}
+
+func (pr *progressReader) Read(b []byte) (int, error) {
+	n, err := pr.r.Read(b)
+	if pr.p.Done {
+		return n, err
+	}
+	pr.p.BytesReceived += int64(n)
+	pr.p.Elapsed = time.Since(pr.start)
+	pr.p.Done = err == io.EOF
+	if n > 0 || pr.p.Done {
+		pr.fn(pr.p)
+	}
+	return n, err
+}
@@ -0,0 +1,68 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package airscan_test
+
+import (
+	"io"
+	"testing"
+
+	"github.com/stapelberg/airscan"
+	"github.com/stapelberg/airscan/preset"
+)
+
+func TestProgress(t *testing.T) {
+	cl := clientForMockScanner(t)
+	settings := preset.GrayscaleA4ADF()
+	settings.InputSource = "Platen"
+	job, err := cl.Scan(settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer job.Close()
+	var updates []airscan.Progress
+	job.SetProgressFunc(func(p airscan.Progress) {
+		updates = append(updates, p)
+	})
+	for job.ScanPage() {
+		if _, err := io.Copy(io.Discard, job.CurrentPage()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := job.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	var done []airscan.Progress
+	for _, p := range updates {
+		if p.Done {
+			done = append(done, p)
+		}
+	}
+	if got, want := len(done), 2; got != want {
+		t.Fatalf("unexpected number of completed pages: got %d, want %d (updates: %+v)", got, want, updates)
+	}
+	for idx, p := range done {
+		if got, want := p.Page, idx+1; got != want {
+			t.Errorf("unexpected page number: got %d, want %d", got, want)
+		}
+		size := int64(len(binaryScanDataStandIn))
+		if p.BytesReceived != size || p.ContentLength != size {
+			t.Errorf("page %d: unexpected progress: got %d of %d bytes, want %d of %d bytes", p.Page, p.BytesReceived, p.ContentLength, size, size)
+		}
+	}
+	if first := updates[0]; first.BytesReceived != 0 || first.Done {
+		t.Errorf("first progress update: got %+v, want 0 bytes received", first)
+	}
+}
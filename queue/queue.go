@@ -0,0 +1,309 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package queue serializes scan jobs per device, so that multiple users (or
+// programs) of the same scanner wait for their turn instead of failing because
+// the scanner is busy.
+//
+// Within a process, waiting jobs are processed in FIFO order and can learn
+// their position in the queue. Across processes (e.g. a cron job and an
+// interactive scan on the same host), jobs are serialized using lock files.
+package queue
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultLockDir is the directory in which lock files are created unless
+// specified otherwise. Programs which should not interfere with each other
+// need to use the same directory, even when running as different users (see
+// New).
+var DefaultLockDir = filepath.Join(os.TempDir(), "airscan-locks")
+
+// lockPollInterval is how often to retry acquiring a lock file which is held
+// by a different process.
+const lockPollInterval = 250 * time.Millisecond
+
+// A Queue serializes access to devices.
+type Queue struct {
+	lockDir string
+
+	mu      sync.Mutex
+	devices map[string]*device
+}
+
+// New returns a Queue which creates lock files in lockDir, which will be
+// created if needed. If lockDir is empty, access is only serialized within the
+// process.
+//
+// Like /tmp, a newly created lockDir is writable for all users and has the
+// sticky bit set, and lock files are readable for all users (regardless of the
+// umask), so that e.g. airscand running as a system user and airscan1 started
+// by a user serialize their scan jobs.
+func New(lockDir string) *Queue {
+	return &Queue{
+		lockDir: lockDir,
+		devices: make(map[string]*device),
+	}
+}
+
+// waiter is a job waiting for (or holding) a device.
+type waiter struct {
+	// since is when the job started waiting. Note that waiter must not be
+	// zero-sized: pointers to distinct zero-sized values may compare equal.
+	since time.Time
+}
+
+// device contains the jobs waiting for a device. The first waiter holds the
+// device.
+type device struct {
+	waiters []*waiter
+
+	// changed is closed (and replaced) whenever waiters changes.
+	changed chan struct{}
+}
+
+func (d *device) position(w *waiter) int {
+	for idx, other := range d.waiters {
+		if other == w {
+			return idx
+		}
+	}
+	return -1
+}
+
+// key normalizes the device name, so that e.g. BRW405BD8A10D7C and
+// brw405bd8a10d7c.local. refer to the same device.
+func key(name string) string {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	return strings.TrimSuffix(name, ".local")
+}
+
+// Waiting returns the number of jobs which hold or wait for the device within
+// this process.
+func (q *Queue) Waiting(name string) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if d, ok := q.devices[key(name)]; ok {
+		return len(d.waiters)
+	}
+	return 0
+}
+
+// remove removes w from the queue of device k and notifies the other waiters.
+// q.mu must be held.
+func (q *Queue) remove(k string, w *waiter) {
+	d := q.devices[k]
+	if idx := d.position(w); idx > -1 {
+		d.waiters = append(d.waiters[:idx], d.waiters[idx+1:]...)
+	}
+	close(d.changed)
+	d.changed = make(chan struct{})
+	if len(d.waiters) == 0 {
+		delete(q.devices, k)
+	}
+}
+
+// Acquire waits until the device is available, or until ctx is done. The
+// device name is typically the host name of the scanner. See Ticket.Wait for
+// details.
+func (q *Queue) Acquire(ctx context.Context, name string, position func(int)) (*Lock, error) {
+	return q.Enqueue(name).Wait(ctx, position)
+}
+
+// A Ticket is a place in the queue of a device.
+type Ticket struct {
+	q   *Queue
+	key string
+	w   *waiter
+}
+
+// Enqueue adds a job to the end of the queue of the device, which is typically
+// the host name of the scanner. Wait must be called on the returned Ticket,
+// otherwise the device stays blocked.
+//
+// Use Enqueue (instead of Acquire) to determine the order of jobs before
+// waiting in a separate goroutine.
+func (q *Queue) Enqueue(name string) *Ticket {
+	k := key(name)
+	w := &waiter{since: time.Now()}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	d, ok := q.devices[k]
+	if !ok {
+		d = &device{changed: make(chan struct{})}
+		q.devices[k] = d
+	}
+	d.waiters = append(d.waiters, w)
+	return &Ticket{q: q, key: k, w: w}
+}
+
+// Position returns the number of jobs ahead of this one within the process (0
+// while the job holds the device), or -1 once the job left the queue, i.e. its
+// Lock was released or Wait gave up.
+func (t *Ticket) Position() int {
+	t.q.mu.Lock()
+	defer t.q.mu.Unlock()
+	d, ok := t.q.devices[t.key]
+	if !ok {
+		return -1
+	}
+	return d.position(t.w)
+}
+
+// Wait waits until the device is available, or until ctx is done.
+//
+// If position is non-nil, it is called with the number of jobs ahead of this
+// one when Wait is called and whenever that number changes (0 means the job
+// is next, but might still wait for a different process). Changes in quick
+// succession might be reported only once.
+//
+// The returned Lock must be released once the job is done.
+func (t *Ticket) Wait(ctx context.Context, position func(int)) (*Lock, error) {
+	q, k, w := t.q, t.key, t.w
+	last := -1
+	for {
+		q.mu.Lock()
+		d := q.devices[k]
+		if d == nil || d.position(w) == -1 {
+			q.mu.Unlock()
+			return nil, fmt.Errorf("ticket for %q is no longer queued (Wait called twice?)", k)
+		}
+		pos := d.position(w)
+		changed := d.changed
+		q.mu.Unlock()
+		if pos != last && position != nil {
+			position(pos)
+		}
+		last = pos
+		if pos == 0 {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			q.mu.Lock()
+			q.remove(k, w)
+			q.mu.Unlock()
+			return nil, ctx.Err()
+		case <-changed:
+		}
+	}
+
+	l := &Lock{q: q, key: k, w: w}
+	if q.lockDir != "" {
+		f, err := q.lockFile(ctx, k)
+		if err != nil {
+			l.Release()
+			return nil, err
+		}
+		l.f = f
+	}
+	return l, nil
+}
+
+// lockFile acquires the lock file for device k, polling while a different
+// process holds it.
+func (q *Queue) lockFile(ctx context.Context, k string) (*os.File, error) {
+	if err := mkdirShared(q.lockDir); err != nil {
+		return nil, err
+	}
+	fn := filepath.Join(q.lockDir, url.PathEscape(k)+".lock")
+	f, err := openShared(fn)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		locked, err := tryLock(f)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("locking %s: %v", fn, err)
+		}
+		if locked {
+			return f, nil
+		}
+		select {
+		case <-ctx.Done():
+			f.Close()
+			return nil, ctx.Err()
+		case <-time.After(lockPollInterval):
+		}
+	}
+}
+
+// mkdirShared creates dir (unless it exists) with the permissions of /tmp. The
+// permissions are set explicitly, as the umask applies to os.Mkdir.
+func mkdirShared(dir string) error {
+	if _, err := os.Stat(dir); err == nil {
+		return nil // leave permissions of existing directories alone
+	}
+	if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+		return err
+	}
+	const perm = os.ModeSticky | 0777
+	if err := os.Mkdir(dir, perm); err != nil {
+		if os.IsExist(err) {
+			return nil // created concurrently
+		}
+		return err
+	}
+	return os.Chmod(dir, perm)
+}
+
+// openShared opens the lock file fn, creating it readable for all users if
+// needed. flock(2) does not require write access, so lock files are opened
+// read-only, which also works for lock files created by other users.
+func openShared(fn string) (*os.File, error) {
+	f, err := os.OpenFile(fn, os.O_RDONLY|os.O_CREATE|os.O_EXCL, 0666)
+	if os.IsExist(err) {
+		return os.Open(fn)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := f.Chmod(0666); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// A Lock grants exclusive access to a device.
+type Lock struct {
+	q    *Queue
+	key  string
+	w    *waiter
+	f    *os.File
+	once sync.Once
+}
+
+// Release releases the device, allowing the next job to proceed. Calling
+// Release more than once is safe.
+func (l *Lock) Release() {
+	l.once.Do(func() {
+		if l.f != nil {
+			unlock(l.f)
+			l.f.Close()
+		}
+		l.q.mu.Lock()
+		defer l.q.mu.Unlock()
+		l.q.remove(l.key, l.w)
+	})
+}
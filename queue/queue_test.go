@@ -0,0 +1,216 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package queue_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/stapelberg/airscan/queue"
+)
+
+func TestFIFO(t *testing.T) {
+	q := queue.New("")
+	ctx := context.Background()
+	first, err := q.Acquire(ctx, "BRW405BD8A10D7C", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		mu        sync.Mutex
+		order     []string
+		positions = make(map[string][]int)
+		wg        sync.WaitGroup
+	)
+	for _, name := range []string{"second", "third"} {
+		queued := make(chan struct{})
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// Different spelling of the same device:
+			l, err := q.Acquire(ctx, "brw405bd8a10d7c.local.", func(pos int) {
+				mu.Lock()
+				defer mu.Unlock()
+				if len(positions[name]) == 0 {
+					defer close(queued)
+				}
+				positions[name] = append(positions[name], pos)
+			})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			mu.Lock()
+			order = append(order, name)
+			mu.Unlock()
+			l.Release()
+		}()
+		<-queued
+	}
+	if got, want := q.Waiting("BRW405BD8A10D7C"), 3; got != want {
+		t.Errorf("unexpected number of waiting jobs: got %d, want %d", got, want)
+	}
+	first.Release()
+	first.Release() // must be safe
+	wg.Wait()
+
+	if diff := cmp.Diff([]string{"second", "third"}, order); diff != "" {
+		t.Errorf("unexpected order: diff (-want +got):\n%s", diff)
+	}
+	// Intermediate positions might be skipped, so only verify the initial and
+	// final position:
+	for name, want := range map[string][]int{
+		"second": {1, 0},
+		"third":  {2, 0},
+	} {
+		got := positions[name]
+		if len(got) < 2 {
+			t.Errorf("%s: unexpected positions: got %v, want %v", name, got, want)
+			continue
+		}
+		got = []int{got[0], got[len(got)-1]}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("%s: unexpected positions: diff (-want +got):\n%s", name, diff)
+		}
+	}
+	if got, want := q.Waiting("BRW405BD8A10D7C"), 0; got != want {
+		t.Errorf("unexpected number of waiting jobs: got %d, want %d", got, want)
+	}
+}
+
+func TestEnqueue(t *testing.T) {
+	q := queue.New("")
+	first := q.Enqueue("scanner")
+	second := q.Enqueue("scanner")
+	if got, want := second.Position(), 1; got != want {
+		t.Errorf("unexpected position: got %d, want %d", got, want)
+	}
+	ctx := context.Background()
+	l, err := first.Wait(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Release()
+	if got, want := first.Position(), -1; got != want {
+		t.Errorf("unexpected position after Release: got %d, want %d", got, want)
+	}
+	if got, want := second.Position(), 0; got != want {
+		t.Errorf("unexpected position: got %d, want %d", got, want)
+	}
+	l, err = second.Wait(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Release()
+	if _, err := first.Wait(ctx, nil); err == nil {
+		t.Errorf("Wait on released ticket unexpectedly succeeded")
+	}
+}
+
+func TestAcquireTimeout(t *testing.T) {
+	q := queue.New("")
+	l, err := q.Acquire(context.Background(), "scanner", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Release()
+
+	ctx, canc := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer canc()
+	if _, err := q.Acquire(ctx, "scanner", nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Acquire: got %v, want %v", err, context.DeadlineExceeded)
+	}
+	if got, want := q.Waiting("scanner"), 1; got != want {
+		t.Errorf("unexpected number of waiting jobs: got %d, want %d", got, want)
+	}
+	// Other devices are not affected:
+	other, err := q.Acquire(context.Background(), "other-scanner", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other.Release()
+}
+
+func TestLockFile(t *testing.T) {
+	// Two queues using the same lock directory behave like two processes:
+	dir := t.TempDir()
+	q1 := queue.New(dir)
+	q2 := queue.New(dir)
+
+	l1, err := q1.Acquire(context.Background(), "scanner", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, canc := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer canc()
+	if _, err := q2.Acquire(ctx, "scanner", nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Acquire: got %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	acquired := make(chan error)
+	go func() {
+		l2, err := q2.Acquire(context.Background(), "scanner", nil)
+		if err == nil {
+			l2.Release()
+		}
+		acquired <- err
+	}()
+	l1.Release()
+	select {
+	case err := <-acquired:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("lock file not acquired after release")
+	}
+}
+
+func TestLockFilePermissions(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("lock files are not used on windows")
+	}
+	// Other users must be able to use the lock directory and lock files,
+	// regardless of the umask:
+	dir := filepath.Join(t.TempDir(), "airscan-locks")
+	l, err := queue.New(dir).Acquire(context.Background(), "scanner", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Release()
+	st, err := os.Stat(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := st.Mode(), os.ModeDir|os.ModeSticky|0777; got != want {
+		t.Errorf("unexpected lock directory mode: got %v, want %v", got, want)
+	}
+	st, err = os.Stat(filepath.Join(dir, "scanner.lock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := st.Mode(), os.FileMode(0666); got != want {
+		t.Errorf("unexpected lock file mode: got %v, want %v", got, want)
+	}
+}
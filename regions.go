@@ -0,0 +1,152 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package airscan
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log"
+)
+
+// ScanEachRegion scans each of the specified regions of the platen (e.g.
+// several receipts or photos on the glass) into its own page.
+//
+// If the device supports as many scan regions as requested (see
+// InputCaps.MaxScanRegions; devices which do not specify it support one), the
+// regions are sent to the device. Otherwise, the full scan area is scanned and
+// the regions are cropped locally, which requires settings.DocumentFormat to be
+// image/jpeg or image/png. With the Feeder input source, the regions are
+// cropped from each scanned page.
+//
+// The ScanRegions of settings are ignored and remain unmodified.
+func (c *Client) ScanEachRegion(settings *ScanSettings, regions []*ScanRegion) (*ScanState, error) {
+	if len(regions) == 0 {
+		return nil, fmt.Errorf("no scan regions specified")
+	}
+	caps, err := c.ScannerCapabilities()
+	if err != nil {
+		return nil, err
+	}
+	ic := caps.InputCaps(settings.InputSource, settings.Duplex)
+	if ic == nil {
+		return nil, fmt.Errorf("input source %q not supported by this scanner", settings.InputSource)
+	}
+
+	copied := *settings
+	maxRegions := ic.MaxScanRegions
+	if maxRegions == 0 {
+		maxRegions = 1 // not specified, but every device supports one region
+	}
+	if maxRegions >= len(regions) {
+		copied.ScanRegions.Regions = regions
+		return c.Scan(&copied)
+	}
+
+	if c.debug {
+		log.Printf("scanner supports %d scan regions, want %d: cropping locally", maxRegions, len(regions))
+	}
+	switch settings.DocumentFormat {
+	case "image/jpeg", "image/png":
+	default:
+		return nil, fmt.Errorf("cropping %d scan regions locally requires DocumentFormat image/jpeg or image/png, got %q", len(regions), settings.DocumentFormat)
+	}
+	crop := &cropper{
+		format: settings.DocumentFormat,
+	}
+	for _, r := range regions {
+		if r.ContentRegionUnits != "" && r.ContentRegionUnits != ThreeHundredthsOfInches {
+			return nil, fmt.Errorf("cropping scan regions locally requires ContentRegionUnits %s, got %q", ThreeHundredthsOfInches, r.ContentRegionUnits)
+		}
+		crop.rects = append(crop.rects, image.Rect(
+			UnitsToPixels(r.XOffset, settings.XResolution),
+			UnitsToPixels(r.YOffset, settings.YResolution),
+			UnitsToPixels(r.XOffset+r.Width, settings.XResolution),
+			UnitsToPixels(r.YOffset+r.Height, settings.YResolution)))
+	}
+	copied.ScanRegions.Regions = []*ScanRegion{
+		{
+			ContentRegionUnits: ThreeHundredthsOfInches,
+			Width:              ic.MaxWidth,
+			Height:             ic.MaxHeight,
+		},
+	}
+	state, err := c.Scan(&copied)
+	if err != nil {
+		return nil, err
+	}
+	state.crop = crop
+	return state, nil
+}
+
+// cropper splits each page of a scan job into one page per rectangle.
+type cropper struct {
+	format  string
+	rects   []image.Rectangle // in pixels
+	pending [][]byte
+}
+
+func (c *cropper) scanPage(s *ScanState) bool {
+	if len(c.pending) == 0 {
+		if !s.nextDocument() {
+			s.reader = nil
+			return false
+		}
+		pages, err := c.split(s.reader)
+		s.closeBody()
+		if err != nil {
+			s.err = err
+			return false
+		}
+		c.pending = pages
+	}
+	s.reader = bytes.NewReader(c.pending[0])
+	c.pending = c.pending[1:]
+	return true
+}
+
+func (c *cropper) split(r io.Reader) ([][]byte, error) {
+	img, _, err := image.Decode(r)
+	if err != nil {
+		return nil, fmt.Errorf("decoding scanned page for cropping: %v", err)
+	}
+	sub, ok := img.(interface {
+		SubImage(image.Rectangle) image.Image
+	})
+	if !ok {
+		return nil, fmt.Errorf("cropping %T images is not supported", img)
+	}
+	pages := make([][]byte, 0, len(c.rects))
+	for _, rect := range c.rects {
+		cropped := rect.Add(img.Bounds().Min).Intersect(img.Bounds())
+		if cropped.Empty() {
+			return nil, fmt.Errorf("scan region %v is outside of the scanned area %v", rect, img.Bounds())
+		}
+		var buf bytes.Buffer
+		if c.format == "image/png" {
+			err = png.Encode(&buf, sub.SubImage(cropped))
+		} else {
+			err = jpeg.Encode(&buf, sub.SubImage(cropped), &jpeg.Options{Quality: 95})
+		}
+		if err != nil {
+			return nil, err
+		}
+		pages = append(pages, buf.Bytes())
+	}
+	return pages, nil
+}
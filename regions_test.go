@@ -0,0 +1,262 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package airscan_test
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/stapelberg/airscan"
+	"github.com/stapelberg/airscan/preset"
+)
+
+// testPlaten returns a PNG of the full platen (2551 x 4200
+// escl:ThreeHundredthsOfInches) at 30 dpi, with a red rectangle at 1 x 1 in
+// and a blue rectangle at 3.33 x 6.67 in.
+func testPlaten(t *testing.T) []byte {
+	t.Helper()
+	platen := image.NewRGBA(image.Rect(0, 0, 255, 420))
+	draw.Draw(platen, platen.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(platen, image.Rect(30, 30, 90, 60), image.NewUniform(red), image.Point{}, draw.Src)
+	draw.Draw(platen, image.Rect(100, 200, 150, 300), image.NewUniform(blue), image.Point{}, draw.Src)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, platen); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+var (
+	red  = color.RGBA{R: 0xff, A: 0xff}
+	blue = color.RGBA{B: 0xff, A: 0xff}
+)
+
+func TestScanEachRegionCropsLocally(t *testing.T) {
+	var requested []string
+	cl := clientFor(t, mockScannerWith(t, mockScannerConfig{
+		page:     testPlaten(t),
+		pages:    1,
+		settings: func(s string) { requested = append(requested, s) },
+	}))
+
+	settings := preset.GrayscaleA4ADF()
+	settings.InputSource = "Platen"
+	settings.DocumentFormat = "image/png"
+	settings.XResolution = 30
+	settings.YResolution = 30
+	regions := []*airscan.ScanRegion{
+		{Width: 600, Height: 300, XOffset: 300, YOffset: 300},
+		{Width: 500, Height: 1000, XOffset: 1000, YOffset: 2000},
+	}
+	job, err := cl.ScanEachRegion(settings, regions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer job.Close()
+
+	if got, want := len(requested), 1; got != want {
+		t.Fatalf("unexpected number of scan jobs: got %d, want %d", got, want)
+	}
+	if !strings.Contains(requested[0], "<pwg:Width>2551</pwg:Width>") ||
+		!strings.Contains(requested[0], "<pwg:Height>4200</pwg:Height>") {
+		t.Errorf("scan job did not request the full platen:\n%s", requested[0])
+	}
+
+	var pages []image.Image
+	for job.ScanPage() {
+		img, err := png.Decode(job.CurrentPage())
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages = append(pages, img)
+	}
+	if err := job.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(pages), len(regions); got != want {
+		t.Fatalf("unexpected number of pages: got %d, want %d", got, want)
+	}
+	for idx, want := range []struct {
+		size  image.Point
+		color color.RGBA
+	}{
+		{image.Pt(60, 30), red},
+		{image.Pt(50, 100), blue},
+	} {
+		img := pages[idx]
+		if got := img.Bounds().Size(); got != want.size {
+			t.Errorf("page %d: unexpected size: got %v, want %v", idx, got, want.size)
+		}
+		b := img.Bounds()
+		for _, pt := range []image.Point{b.Min, b.Max.Sub(image.Pt(1, 1))} {
+			if got := color.RGBAModel.Convert(img.At(pt.X, pt.Y)); got != want.color {
+				t.Errorf("page %d: unexpected color at %v: got %v, want %v", idx, pt, got, want.color)
+			}
+		}
+	}
+}
+
+func TestScanEachRegionRequiresImageFormat(t *testing.T) {
+	cl := clientForMockScanner(t)
+	settings := preset.GrayscaleA4ADF()
+	settings.InputSource = "Platen"
+	settings.DocumentFormat = "application/pdf"
+	regions := []*airscan.ScanRegion{
+		{Width: 600, Height: 300},
+		{Width: 600, Height: 300, YOffset: 600},
+	}
+	if _, err := cl.ScanEachRegion(settings, regions); err == nil {
+		t.Fatalf("ScanEachRegion unexpectedly succeeded with a PDF format")
+	}
+}
+
+// maxScanRegions returns a mockScannerConfig.capabilities func which replaces
+// the MaxScanRegions of all input sources with max, or removes them if max
+// is empty.
+func maxScanRegions(max string) func(string) string {
+	return func(caps string) string {
+		if max == "" {
+			return strings.ReplaceAll(caps, "<scan:MaxScanRegions>1</scan:MaxScanRegions>", "")
+		}
+		return strings.ReplaceAll(caps,
+			"<scan:MaxScanRegions>1</scan:MaxScanRegions>",
+			"<scan:MaxScanRegions>"+max+"</scan:MaxScanRegions>")
+	}
+}
+
+func TestScanEachRegionOnDevice(t *testing.T) {
+	for _, tt := range []struct {
+		name           string
+		maxScanRegions string
+		regions        []*airscan.ScanRegion
+	}{
+		{
+			name:           "MultipleRegions",
+			maxScanRegions: "4",
+			regions: []*airscan.ScanRegion{
+				{ContentRegionUnits: airscan.ThreeHundredthsOfInches, Width: 600, Height: 300, XOffset: 300, YOffset: 300},
+				{ContentRegionUnits: airscan.ThreeHundredthsOfInches, Width: 500, Height: 1000, XOffset: 1000, YOffset: 2000},
+			},
+		},
+		{
+			// Devices which do not specify MaxScanRegions support one:
+			name:           "UnspecifiedMaxScanRegions",
+			maxScanRegions: "",
+			regions: []*airscan.ScanRegion{
+				{ContentRegionUnits: airscan.ThreeHundredthsOfInches, Width: 600, Height: 300, XOffset: 300, YOffset: 300},
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var requested []string
+			cl := clientFor(t, mockScannerWith(t, mockScannerConfig{
+				page:         binaryScanDataStandIn,
+				pages:        len(tt.regions),
+				settings:     func(s string) { requested = append(requested, s) },
+				capabilities: maxScanRegions(tt.maxScanRegions),
+			}))
+
+			settings := preset.GrayscaleA4ADF()
+			settings.InputSource = "Platen"
+			// Not croppable locally, so the device must handle the regions:
+			settings.DocumentFormat = "application/pdf"
+			job, err := cl.ScanEachRegion(settings, tt.regions)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer job.Close()
+
+			if got, want := len(requested), 1; got != want {
+				t.Fatalf("unexpected number of scan jobs: got %d, want %d", got, want)
+			}
+			got, err := airscan.UnmarshalScanSettings([]byte(requested[0]))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tt.regions, got.ScanRegions.Regions); diff != "" {
+				t.Errorf("unexpected scan regions: diff (-want +got):\n%s", diff)
+			}
+			if len(settings.ScanRegions.Regions) != 1 {
+				t.Errorf("ScanEachRegion modified the settings")
+			}
+
+			var pages int
+			for job.ScanPage() {
+				b, err := io.ReadAll(job.CurrentPage())
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(b, binaryScanDataStandIn) {
+					t.Errorf("page %d: unexpected scan data %x", pages+1, b)
+				}
+				pages++
+			}
+			if err := job.Err(); err != nil {
+				t.Fatal(err)
+			}
+			if got, want := pages, len(tt.regions); got != want {
+				t.Errorf("unexpected number of pages: got %d, want %d", got, want)
+			}
+		})
+	}
+}
+
+func TestScanEachRegionCropsEachFeederPage(t *testing.T) {
+	cl := clientFor(t, mockScannerWith(t, mockScannerConfig{
+		page:  testPlaten(t),
+		pages: 2,
+		status: func(status string) string {
+			return strings.ReplaceAll(status, "ScannerAdfEmpty", "ScannerAdfLoaded")
+		},
+	}))
+
+	settings := preset.GrayscaleA4ADF()
+	settings.DocumentFormat = "image/png"
+	settings.Duplex = false
+	settings.XResolution = 30
+	settings.YResolution = 30
+	regions := []*airscan.ScanRegion{
+		{Width: 600, Height: 300, XOffset: 300, YOffset: 300},
+		{Width: 500, Height: 1000, XOffset: 1000, YOffset: 2000},
+	}
+	job, err := cl.ScanEachRegion(settings, regions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer job.Close()
+
+	var colors []color.RGBA
+	for job.ScanPage() {
+		img, err := png.Decode(job.CurrentPage())
+		if err != nil {
+			t.Fatal(err)
+		}
+		colors = append(colors, color.RGBAModel.Convert(img.At(img.Bounds().Min.X, img.Bounds().Min.Y)).(color.RGBA))
+	}
+	if err := job.Err(); err != nil {
+		t.Fatal(err)
+	}
+	// Both regions of both scanned pages:
+	if diff := cmp.Diff([]color.RGBA{red, blue, red, blue}, colors); diff != "" {
+		t.Errorf("unexpected pages: diff (-want +got):\n%s", diff)
+	}
+}
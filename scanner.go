@@ -0,0 +1,131 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package airscan
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"iter"
+)
+
+// A Scanner is a device which can be scanned from, regardless of the protocol
+// it speaks: *Client implements Scanner using eSCL, and
+// https://pkg.go.dev/github.com/stapelberg/airscan/wsscan implements Scanner
+// using Microsoft WS-Scan.
+//
+// Scan settings, status and capabilities are expressed in eSCL terms, which
+// other protocols translate from and to.
+//
+// Programs which depend on Scanner instead of *Client can be tested against an
+// in-memory fake, see https://pkg.go.dev/github.com/stapelberg/airscan/airscantest.
+type Scanner interface {
+	// ScannerStatusContext queries the device for its status. The request
+	// is aborted once ctx is done.
+	ScannerStatusContext(ctx context.Context) (*ScannerStatus, error)
+
+	// ScannerCapabilitiesContext queries the device for its capabilities.
+	// The request is aborted once ctx is done.
+	ScannerCapabilitiesContext(ctx context.Context) (*ScannerCapabilities, error)
+
+	// ScanPages starts a new scan job using the specified settings and
+	// returns an iterator over its pages. Errors (including errors
+	// starting the scan job) are yielded and end the iteration. Once the
+	// iteration ends (including when the loop is exited early), the scan
+	// job is finished.
+	ScanPages(ctx context.Context, settings *ScanSettings) iter.Seq2[*Page, error]
+
+	// Cancel aborts the scan jobs which are in progress, e.g. when the user
+	// interrupts a multi-page scan. Cancel may be called from a different
+	// goroutine than the one iterating over the pages, whose iteration
+	// then ends without an error.
+	Cancel(ctx context.Context) error
+}
+
+var _ Scanner = (*Client)(nil)
+
+// ScannerFuncs is an adapter to allow the use of ordinary functions as a
+// Scanner, e.g. to wrap a Scanner and modify some of its behavior. Methods
+// whose function is nil return an error (Cancel returns nil).
+type ScannerFuncs struct {
+	StatusFunc       func(ctx context.Context) (*ScannerStatus, error)
+	CapabilitiesFunc func(ctx context.Context) (*ScannerCapabilities, error)
+	ScanPagesFunc    func(ctx context.Context, settings *ScanSettings) iter.Seq2[*Page, error]
+	CancelFunc       func(ctx context.Context) error
+}
+
+var _ Scanner = (*ScannerFuncs)(nil)
+
+var errNotImplemented = errors.New("not implemented")
+
+// ScannerStatusContext calls f.StatusFunc.
+func (f *ScannerFuncs) ScannerStatusContext(ctx context.Context) (*ScannerStatus, error) {
+	if f.StatusFunc == nil {
+		return nil, fmt.Errorf("ScannerStatusContext: %w", errNotImplemented)
+	}
+	return f.StatusFunc(ctx)
+}
+
+// ScannerCapabilitiesContext calls f.CapabilitiesFunc.
+func (f *ScannerFuncs) ScannerCapabilitiesContext(ctx context.Context) (*ScannerCapabilities, error) {
+	if f.CapabilitiesFunc == nil {
+		return nil, fmt.Errorf("ScannerCapabilitiesContext: %w", errNotImplemented)
+	}
+	return f.CapabilitiesFunc(ctx)
+}
+
+// ScanPages calls f.ScanPagesFunc.
+func (f *ScannerFuncs) ScanPages(ctx context.Context, settings *ScanSettings) iter.Seq2[*Page, error] {
+	if f.ScanPagesFunc == nil {
+		return func(yield func(*Page, error) bool) {
+			yield(nil, fmt.Errorf("ScanPages: %w", errNotImplemented))
+		}
+	}
+	return f.ScanPagesFunc(ctx, settings)
+}
+
+// Cancel calls f.CancelFunc.
+func (f *ScannerFuncs) Cancel(ctx context.Context) error {
+	if f.CancelFunc == nil {
+		return nil
+	}
+	return f.CancelFunc(ctx)
+}
+
+// Validating returns a Scanner which verifies the settings against the
+// capabilities of s (see ScanSettings.Validate) before starting a scan job,
+// so that invalid settings result in a descriptive error instead of a device
+// error.
+func Validating(s Scanner) Scanner {
+	return &ScannerFuncs{
+		StatusFunc:       s.ScannerStatusContext,
+		CapabilitiesFunc: s.ScannerCapabilitiesContext,
+		ScanPagesFunc: func(ctx context.Context, settings *ScanSettings) iter.Seq2[*Page, error] {
+			return func(yield func(*Page, error) bool) {
+				caps, err := s.ScannerCapabilitiesContext(ctx)
+				if err != nil {
+					yield(nil, err)
+					return
+				}
+				if err := settings.Validate(caps); err != nil {
+					yield(nil, err)
+					return
+				}
+				s.ScanPages(ctx, settings)(yield)
+			}
+		},
+		CancelFunc: s.Cancel,
+	}
+}
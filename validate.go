@@ -0,0 +1,245 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package airscan
+
+import (
+	"fmt"
+	"strings"
+)
+
+// InputCaps returns the capabilities of the specified input source (Platen or
+// Feeder), or nil if the device does not have this input source.
+func (c *ScannerCapabilities) InputCaps(inputSource string, duplex bool) *InputCaps {
+	switch inputSource {
+	case "Platen":
+		if c.Platen == nil {
+			return nil
+		}
+		return &c.Platen.PlatenInputCaps
+	case "Feeder":
+		if c.Adf == nil {
+			return nil
+		}
+		if duplex {
+			if c.Adf.AdfDuplexInputCaps != nil &&
+				len(c.Adf.AdfDuplexInputCaps.SettingProfiles.SettingProfile) > 0 {
+				return c.Adf.AdfDuplexInputCaps
+			}
+			// Many devices only describe the simplex capabilities in detail
+			// and merely announce duplex support with an (almost) empty
+			// AdfDuplexInputCaps element.
+		}
+		return c.Adf.AdfSimplexInputCaps
+	}
+	return nil
+}
+
+// union returns the values of all setting profiles (as selected by fn) without
+// duplicates, in order of appearance.
+func (ic *InputCaps) union(fn func(p *settingProfile) []string) []string {
+	var result []string
+	seen := make(map[string]bool)
+	for idx := range ic.SettingProfiles.SettingProfile {
+		for _, v := range fn(&ic.SettingProfiles.SettingProfile[idx]) {
+			if seen[v] {
+				continue
+			}
+			seen[v] = true
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+// ColorModes returns all supported color modes.
+func (ic *InputCaps) ColorModes() []string {
+	return ic.union(func(p *settingProfile) []string { return p.ColorModes.ColorMode })
+}
+
+// DocumentFormats returns all supported document formats.
+func (ic *InputCaps) DocumentFormats() []string {
+	return ic.union(func(p *settingProfile) []string {
+		return append(append([]string(nil), p.DocumentFormats.DocumentFormat...), p.DocumentFormats.DocumentFormatExt...)
+	})
+}
+
+// ContentTypes returns all supported content types.
+func (ic *InputCaps) ContentTypes() []string {
+	return ic.union(func(p *settingProfile) []string { return p.ContentTypes.ContentType })
+}
+
+// supportsResolution returns whether x/y is a discrete resolution or within the
+// resolution range of any setting profile.
+func (ic *InputCaps) supportsResolution(x, y int) bool {
+	for _, p := range ic.SettingProfiles.SettingProfile {
+		for _, r := range p.SupportedResolutions.DiscreteResolutions.DiscreteResolution {
+			if r.XResolution == x && r.YResolution == y {
+				return true
+			}
+		}
+		if rr := p.SupportedResolutions.ResolutionRange; rr != nil &&
+			rr.XResolutionRange.contains(x) &&
+			rr.YResolutionRange.contains(y) {
+			return true
+		}
+	}
+	return false
+}
+
+// Resolutions returns a human-readable description of all supported
+// resolutions, e.g. 75x75, 300x300, 100-1200.
+func (ic *InputCaps) Resolutions() []string {
+	return ic.union(func(p *settingProfile) []string {
+		var result []string
+		for _, r := range p.SupportedResolutions.DiscreteResolutions.DiscreteResolution {
+			result = append(result, fmt.Sprintf("%dx%d", r.XResolution, r.YResolution))
+		}
+		if rr := p.SupportedResolutions.ResolutionRange; rr != nil {
+			result = append(result, fmt.Sprintf("%d-%d", rr.XResolutionRange.Min, rr.XResolutionRange.Max))
+		}
+		return result
+	})
+}
+
+func (r *rangeSupport) contains(v int) bool {
+	if v < r.Min || v > r.Max {
+		return false
+	}
+	return r.Step <= 1 || (v-r.Min)%r.Step == 0
+}
+
+func contains(values []string, v string) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate verifies that the device, as described by caps, supports the
+// settings. The returned error describes which values are supported instead.
+//
+// Note that the capabilities which devices report are not always accurate, so
+// programs should offer a way to scan without validation.
+func (s *ScanSettings) Validate(caps *ScannerCapabilities) error {
+	ic := caps.InputCaps(s.InputSource, s.Duplex)
+	if ic == nil {
+		var sources []string
+		if caps.Platen != nil {
+			sources = append(sources, "Platen")
+		}
+		if caps.Adf != nil {
+			sources = append(sources, "Feeder")
+		}
+		return fmt.Errorf("input source %q not supported, want one of %s", s.InputSource, strings.Join(sources, ", "))
+	}
+	if s.InputSource == "Feeder" && s.Duplex && caps.Adf.AdfDuplexInputCaps == nil {
+		return fmt.Errorf("duplex scanning not supported by the feeder")
+	}
+	if modes := ic.ColorModes(); len(modes) > 0 && !contains(modes, s.ColorMode) {
+		return fmt.Errorf("color mode %q not supported, want one of %s", s.ColorMode, strings.Join(modes, ", "))
+	}
+	if formats := ic.DocumentFormats(); len(formats) > 0 && !contains(formats, s.DocumentFormat) {
+		return fmt.Errorf("document format %q not supported, want one of %s", s.DocumentFormat, strings.Join(formats, ", "))
+	}
+	if s.ContentType != "" {
+		if types := ic.ContentTypes(); !contains(types, s.ContentType) {
+			return fmt.Errorf("content type %q not supported, want one of %s", s.ContentType, strings.Join(types, ", "))
+		}
+	}
+	if s.Intent != "" {
+		if intents := ic.SupportedIntents.Intent; !contains(intents, s.Intent) {
+			return fmt.Errorf("intent %q not supported, want one of %s", s.Intent, strings.Join(intents, ", "))
+		}
+	}
+	if !ic.supportsResolution(s.XResolution, s.YResolution) {
+		return fmt.Errorf("resolution %dx%d not supported, want one of %s", s.XResolution, s.YResolution, strings.Join(ic.Resolutions(), ", "))
+	}
+	for _, r := range s.ScanRegions.Regions {
+		if err := r.validate(ic); err != nil {
+			return err
+		}
+	}
+	if s.DocumentFormatExt != "" {
+		if formats := ic.DocumentFormats(); len(formats) > 0 && !contains(formats, s.DocumentFormatExt) {
+			return fmt.Errorf("document format %q not supported, want one of %s", s.DocumentFormatExt, strings.Join(formats, ", "))
+		}
+	}
+	if s.ColorSpace != "" {
+		if spaces := ic.union(func(p *settingProfile) []string { return p.ColorSpaces.ColorSpace }); !contains(spaces, s.ColorSpace) {
+			return fmt.Errorf("color space %q not supported, want one of %s", s.ColorSpace, strings.Join(spaces, ", "))
+		}
+	}
+	if s.CcdChannel != "" {
+		if channels := ic.union(func(p *settingProfile) []string { return p.CcdChannels.CcdChannel }); !contains(channels, s.CcdChannel) {
+			return fmt.Errorf("CCD channel %q not supported, want one of %s", s.CcdChannel, strings.Join(channels, ", "))
+		}
+	}
+	if s.FeedDirection != "" {
+		if dirs := ic.FeedDirections.FeedDirection; !contains(dirs, s.FeedDirection) {
+			return fmt.Errorf("feed direction %q not supported, want one of %s", s.FeedDirection, strings.Join(dirs, ", "))
+		}
+	}
+	if s.BlankPageDetection != nil && *s.BlankPageDetection && !caps.BlankPageDetection {
+		return fmt.Errorf("blank page detection not supported by this device")
+	}
+	for _, r := range []struct {
+		name    string
+		v       *int
+		support *rangeSupport
+	}{
+		{"brightness", s.Brightness, caps.BrightnessSupport},
+		{"compression factor", s.CompressionFactor, caps.CompressionFactorSupport},
+		{"contrast", s.Contrast, caps.ContrastSupport},
+		{"gamma", s.Gamma, caps.GammaSupport},
+		{"highlight", s.Highlight, caps.HighlightSupport},
+		{"noise removal", s.NoiseRemoval, caps.NoiseRemovalSupport},
+		{"shadow", s.Shadow, caps.ShadowSupport},
+		{"sharpen", s.Sharpen, caps.SharpenSupport},
+		{"threshold", s.Threshold, caps.ThresholdSupport},
+	} {
+		if err := validateRange(r.name, r.v, r.support); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *ScanRegion) validate(ic *InputCaps) error {
+	if r.ContentRegionUnits != "" && r.ContentRegionUnits != "escl:ThreeHundredthsOfInches" {
+		return nil // cannot verify
+	}
+	if ic.MaxWidth > 0 && (r.Width < ic.MinWidth || r.XOffset+r.Width > ic.MaxWidth) {
+		return fmt.Errorf("scan region width %d (offset %d) not supported, want width between %d and %d (including offset)", r.Width, r.XOffset, ic.MinWidth, ic.MaxWidth)
+	}
+	if ic.MaxHeight > 0 && (r.Height < ic.MinHeight || r.YOffset+r.Height > ic.MaxHeight) {
+		return fmt.Errorf("scan region height %d (offset %d) not supported, want height between %d and %d (including offset)", r.Height, r.YOffset, ic.MinHeight, ic.MaxHeight)
+	}
+	return nil
+}
+
+func validateRange(name string, v *int, support *rangeSupport) error {
+	if v == nil {
+		return nil
+	}
+	if support == nil {
+		return fmt.Errorf("setting %s not supported by this device", name)
+	}
+	if !support.contains(*v) {
+		return fmt.Errorf("%s %d not supported, want a value between %d and %d (step %d, normal %d)", name, *v, support.Min, support.Max, support.Step, support.Normal)
+	}
+	return nil
+}
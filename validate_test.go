@@ -0,0 +1,172 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package airscan_test
+
+import (
+	"encoding/xml"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stapelberg/airscan"
+	"github.com/stapelberg/airscan/preset"
+)
+
+func mockCapabilities(t *testing.T) *airscan.ScannerCapabilities {
+	t.Helper()
+	f := openEsclMockFile(t, "ScannerCapabilities.xml")
+	defer f.Close()
+	b, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var caps airscan.ScannerCapabilities
+	if err := xml.Unmarshal(b, &caps); err != nil {
+		t.Fatal(err)
+	}
+	return &caps
+}
+
+func TestValidate(t *testing.T) {
+	caps := mockCapabilities(t)
+	intPtr := func(i int) *int { return &i }
+
+	for _, tt := range []struct {
+		name    string
+		modify  func(s *airscan.ScanSettings)
+		wantErr string // substring, or empty if no error is expected
+	}{
+		{
+			name:   "PlatenPreset",
+			modify: func(s *airscan.ScanSettings) {},
+		},
+
+		{
+			name: "FeederSimplex",
+			modify: func(s *airscan.ScanSettings) {
+				s.InputSource = "Feeder"
+				s.Duplex = false
+				s.Intent = "Document"
+				s.ContentType = "Text"
+			},
+		},
+
+		{
+			name: "FeederDuplex",
+			modify: func(s *airscan.ScanSettings) {
+				s.InputSource = "Feeder"
+			},
+			wantErr: "duplex scanning not supported",
+		},
+
+		{
+			name: "UnknownSource",
+			modify: func(s *airscan.ScanSettings) {
+				s.InputSource = "Camera"
+			},
+			wantErr: `input source "Camera" not supported, want one of Platen, Feeder`,
+		},
+
+		{
+			name: "ColorMode",
+			modify: func(s *airscan.ScanSettings) {
+				s.ColorMode = "RGB48"
+			},
+			wantErr: `color mode "RGB48" not supported, want one of Grayscale8, RGB24`,
+		},
+
+		{
+			name: "DocumentFormat",
+			modify: func(s *airscan.ScanSettings) {
+				s.DocumentFormat = "image/png"
+			},
+			wantErr: `document format "image/png" not supported`,
+		},
+
+		{
+			name: "Resolution",
+			modify: func(s *airscan.ScanSettings) {
+				s.XResolution = 600
+				s.YResolution = 600
+			},
+			wantErr: "resolution 600x600 not supported, want one of 300x300",
+		},
+
+		{
+			name: "Intent",
+			modify: func(s *airscan.ScanSettings) {
+				s.Intent = "BusinessCard"
+			},
+			wantErr: `intent "BusinessCard" not supported`,
+		},
+
+		{
+			name: "RegionTooLarge",
+			modify: func(s *airscan.ScanSettings) {
+				s.ScanRegions.Regions[0].XOffset = 100
+			},
+			wantErr: "scan region width 2480 (offset 100) not supported",
+		},
+
+		{
+			name: "Sharpen",
+			modify: func(s *airscan.ScanSettings) {
+				s.Sharpen = intPtr(4)
+				s.ColorSpace = "RGB"
+				s.CcdChannel = "Green"
+			},
+		},
+
+		{
+			name: "SharpenOutOfRange",
+			modify: func(s *airscan.ScanSettings) {
+				s.Sharpen = intPtr(8)
+			},
+			wantErr: "sharpen 8 not supported, want a value between 1 and 7",
+		},
+
+		{
+			name: "CcdChannel",
+			modify: func(s *airscan.ScanSettings) {
+				s.CcdChannel = "NTSC"
+			},
+			wantErr: `CCD channel "NTSC" not supported, want one of Red, Green, Blue`,
+		},
+
+		{
+			name: "Brightness",
+			modify: func(s *airscan.ScanSettings) {
+				s.Brightness = intPtr(50)
+			},
+			wantErr: "setting brightness not supported",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			settings := preset.GrayscaleA4ADF()
+			settings.InputSource = "Platen"
+			tt.modify(settings)
+			err := settings.Validate(caps)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Validate: unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Validate: unexpected error: got %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package virtual
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+
+	"github.com/stapelberg/airscan"
+	"github.com/stapelberg/airscan/internal/pdf"
+)
+
+// render scans page with the specified settings, i.e. it crops, scales and
+// converts the page image and encodes it in the requested document format.
+func (s *Scanner) render(page Page, settings *airscan.ScanSettings) (_ []byte, contentType string, _ error) {
+	src, err := page()
+	if err != nil {
+		return nil, "", err
+	}
+	img := s.scan(src, settings)
+
+	format := settings.DocumentFormat
+	if settings.DocumentFormatExt != "" {
+		format = settings.DocumentFormatExt
+	}
+	var buf bytes.Buffer
+	switch format {
+	case "image/png":
+		if err := png.Encode(&buf, img); err != nil {
+			return nil, "", err
+		}
+
+	case "image/jpeg":
+		if err := jpeg.Encode(&buf, img, nil); err != nil {
+			return nil, "", err
+		}
+
+	case "application/pdf":
+		var j bytes.Buffer
+		if err := jpeg.Encode(&j, img, nil); err != nil {
+			return nil, "", err
+		}
+		if err := pdf.Write(&buf, []pdf.Page{{
+			JPEG:        j.Bytes(),
+			XResolution: settings.XResolution,
+			YResolution: settings.YResolution,
+		}}); err != nil {
+			return nil, "", err
+		}
+
+	default:
+		return nil, "", fmt.Errorf("document format %q not supported", format)
+	}
+	return buf.Bytes(), format, nil
+}
+
+// scan returns the part of src which is covered by the scan region (the whole
+// image if no region is specified), at the requested resolution and in the
+// requested color mode. Parts of the region which lie outside of src are white,
+// like the lid of a scanner.
+func (s *Scanner) scan(src image.Image, settings *airscan.ScanSettings) image.Image {
+	// The physical size of src is determined by s.Resolution:
+	sb := src.Bounds()
+	region := airscan.ScanRegion{
+		Width:  sb.Dx() * 300 / s.Resolution,
+		Height: sb.Dy() * 300 / s.Resolution,
+	}
+	if len(settings.ScanRegions.Regions) > 0 {
+		region = *settings.ScanRegions.Regions[0]
+	}
+	width := airscan.UnitsToPixels(region.Width, settings.XResolution)
+	height := airscan.UnitsToPixels(region.Height, settings.YResolution)
+
+	// Work on RGBA pixels, which is much faster than calling src.At:
+	rgba, ok := src.(*image.RGBA)
+	if !ok {
+		rgba = image.NewRGBA(sb)
+		draw.Draw(rgba, sb, src, sb.Min, draw.Src)
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		// Convert from destination pixels via escl:ThreeHundredthsOfInches to
+		// source pixels (nearest neighbor):
+		sy := (region.YOffset*settings.YResolution + y*300) * s.Resolution / (300 * settings.YResolution)
+		for x := 0; x < width; x++ {
+			sx := (region.XOffset*settings.XResolution + x*300) * s.Resolution / (300 * settings.XResolution)
+			c := color.RGBA{0xff, 0xff, 0xff, 0xff}
+			if p := image.Pt(sb.Min.X+sx, sb.Min.Y+sy); p.In(sb) {
+				c = rgba.RGBAAt(p.X, p.Y)
+			}
+			dst.SetRGBA(x, y, c)
+		}
+	}
+	if settings.ColorMode == "RGB24" {
+		return dst
+	}
+
+	gray := image.NewGray(dst.Bounds())
+	draw.Draw(gray, gray.Bounds(), dst, image.Point{}, draw.Src)
+	if settings.ColorMode == "BlackAndWhite1" {
+		for idx, v := range gray.Pix {
+			if v < 0x80 {
+				gray.Pix[idx] = 0
+			} else {
+				gray.Pix[idx] = 0xff
+			}
+		}
+	}
+	return gray
+}
@@ -0,0 +1,96 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package virtual
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/stapelberg/airscan/internal/pdf"
+)
+
+// Dir returns a function for Scanner.Pages which lists the pages in dir: each
+// JPEG, PNG or GIF file is one page, and each PDF file contributes the JPEG
+// images it contains (e.g. as written by scanners) as pages. Files are sorted
+// by name; other files are ignored.
+//
+// The directory is read whenever Pages is called, so files can be added or
+// removed while the Scanner is running.
+func Dir(dir string) func() ([]Page, error) {
+	return func() ([]Page, error) {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		names := make([]string, 0, len(entries))
+		for _, e := range entries {
+			if e.Type().IsRegular() {
+				names = append(names, e.Name())
+			}
+		}
+		sort.Strings(names)
+		var pages []Page
+		for _, name := range names {
+			fn := filepath.Join(dir, name)
+			switch strings.ToLower(filepath.Ext(name)) {
+			case ".jpg", ".jpeg", ".png", ".gif":
+				pages = append(pages, func() (image.Image, error) {
+					return decodeFile(fn)
+				})
+
+			case ".pdf":
+				b, err := os.ReadFile(fn)
+				if err != nil {
+					return nil, err
+				}
+				images, err := pdf.JPEGs(b)
+				if err != nil {
+					return nil, fmt.Errorf("%s: %v", fn, err)
+				}
+				for idx, b := range images {
+					pages = append(pages, func() (image.Image, error) {
+						img, _, err := image.Decode(bytes.NewReader(b))
+						if err != nil {
+							return nil, fmt.Errorf("%s: image %d: %v", fn, idx+1, err)
+						}
+						return img, nil
+					})
+				}
+			}
+		}
+		return pages, nil
+	}
+}
+
+func decodeFile(fn string) (image.Image, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", fn, err)
+	}
+	return img, nil
+}
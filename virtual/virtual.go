@@ -0,0 +1,444 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package virtual implements an eSCL scanner which serves images (e.g. files in
+// a directory) as if they were a stack of paper in its Automatic Document
+// Feeder (ADF).
+//
+// The virtual scanner honors the requested color mode, resolution, scan region
+// and document format by converting the images on the fly. It is useful for
+// demos, for testing programs which use package airscan without access to a
+// physical device, and as a reference server in integration tests.
+package virtual
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/xml"
+	"fmt"
+	"image"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/stapelberg/airscan"
+)
+
+// maxJobs is the number of finished jobs which are still listed in the
+// ScannerStatus document.
+const maxJobs = 10
+
+// DefaultJobTimeout is the default for Scanner.JobTimeout.
+const DefaultJobTimeout = 5 * time.Minute
+
+// A Page returns the image of one page. Pages are only decoded when they are
+// scanned.
+type Page func() (image.Image, error)
+
+// A Scanner is a virtual eSCL scanner. It implements http.Handler, serving the
+// /eSCL/ endpoints.
+//
+// It is safe to update its struct fields before the Scanner first handles a
+// request.
+type Scanner struct {
+	// MakeAndModel is the name under which the scanner identifies itself.
+	// The default is “airscan virtual scanner”.
+	MakeAndModel string
+
+	// UUID identifies the scanner. If empty, a random UUID is generated.
+	UUID string
+
+	// Resolution is the resolution (in dpi) of the page images, which
+	// determines their physical size. The default is 300 dpi.
+	Resolution int
+
+	// Pages returns the pages in the ADF, which is called whenever a scan
+	// job is created and when the status is queried. Each scan job scans
+	// all pages, i.e. the ADF is refilled after each job. The platen
+	// contains the first page.
+	Pages func() ([]Page, error)
+
+	// JobTimeout is how long a scan job may go without a page being
+	// requested before the scanner aborts it, like physical devices do, so
+	// that a job abandoned by its client does not keep the scanner busy. The
+	// default is DefaultJobTimeout.
+	JobTimeout time.Duration
+
+	// Debug enables logging of all requests.
+	Debug bool
+
+	once sync.Once
+	mux  *http.ServeMux
+	caps *airscan.ScannerCapabilities
+
+	mu     sync.Mutex
+	jobs   []*job // most recent last
+	lastID int
+}
+
+// job is a scan job and its state.
+type job struct {
+	id       string
+	settings *airscan.ScanSettings
+	pages    []Page
+
+	// next is the index of the next page to scan.
+	next int
+
+	// state is one of Processing, Completed, Canceled or Aborted.
+	state string
+
+	// lastActive is when the job was created or its last page was
+	// requested.
+	lastActive time.Time
+}
+
+func (s *Scanner) init() {
+	if s.MakeAndModel == "" {
+		s.MakeAndModel = "airscan virtual scanner"
+	}
+	if s.UUID == "" {
+		s.UUID = newUUID()
+	}
+	if s.Resolution == 0 {
+		s.Resolution = 300
+	}
+	if s.Pages == nil {
+		s.Pages = func() ([]Page, error) { return nil, nil }
+	}
+	if s.JobTimeout == 0 {
+		s.JobTimeout = DefaultJobTimeout
+	}
+	s.caps = new(airscan.ScannerCapabilities)
+	if err := xml.Unmarshal(s.capabilitiesXML(), s.caps); err != nil {
+		panic(fmt.Sprintf("BUG: capabilities template does not decode: %v", err))
+	}
+
+	s.mux = http.NewServeMux()
+	s.mux.HandleFunc("GET /eSCL/ScannerCapabilities", s.serveCapabilities)
+	s.mux.HandleFunc("GET /eSCL/ScannerStatus", s.serveStatus)
+	s.mux.HandleFunc("POST /eSCL/ScanJobs", s.createJob)
+	s.mux.HandleFunc("GET /eSCL/ScanJobs/{job}/NextDocument", s.nextDocument)
+	s.mux.HandleFunc("DELETE /eSCL/ScanJobs/{job}", s.deleteJob)
+}
+
+// Capabilities returns the capabilities of the scanner, e.g. to advertise it
+// via airscan.Advertise.
+func (s *Scanner) Capabilities() *airscan.ScannerCapabilities {
+	s.once.Do(s.init)
+	return s.caps
+}
+
+func (s *Scanner) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.once.Do(s.init)
+	if s.Debug {
+		log.Printf("%s %s", r.Method, r.URL)
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+// newUUID returns a random (version 4) UUID.
+func newUUID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+var capabilitiesTmpl = template.Must(template.New("caps").Funcs(template.FuncMap{
+	"resolutions": func() []int { return []int{75, 100, 150, 200, 300, 600} },
+}).Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<scan:ScannerCapabilities xmlns:pwg="http://www.pwg.org/schemas/2010/12/sm" xmlns:scan="http://schemas.hp.com/imaging/escl/2011/05/03">
+  <pwg:Version>2.63</pwg:Version>
+  <pwg:MakeAndModel>{{ .MakeAndModel }}</pwg:MakeAndModel>
+  <scan:UUID>{{ .UUID }}</scan:UUID>
+  <scan:Platen>
+    <scan:PlatenInputCaps>{{ template "inputcaps" }}</scan:PlatenInputCaps>
+  </scan:Platen>
+  <scan:Adf>
+    <scan:AdfSimplexInputCaps>{{ template "inputcaps" }}</scan:AdfSimplexInputCaps>
+    <scan:AdfDuplexInputCaps>{{ template "inputcaps" }}</scan:AdfDuplexInputCaps>
+    <scan:FeederCapacity>50</scan:FeederCapacity>
+    <scan:Justification>
+      <pwg:XImagePosition>Left</pwg:XImagePosition>
+      <pwg:YImagePosition>Top</pwg:YImagePosition>
+    </scan:Justification>
+    <scan:AdfOptions>
+      <scan:AdfOption>DetectPaperLoaded</scan:AdfOption>
+    </scan:AdfOptions>
+  </scan:Adf>
+</scan:ScannerCapabilities>
+{{ define "inputcaps" }}
+      <scan:MinWidth>16</scan:MinWidth>
+      <scan:MaxWidth>2550</scan:MaxWidth>
+      <scan:MinHeight>16</scan:MinHeight>
+      <scan:MaxHeight>4200</scan:MaxHeight>
+      <scan:MaxScanRegions>1</scan:MaxScanRegions>
+      <scan:MaxOpticalXResolution>600</scan:MaxOpticalXResolution>
+      <scan:MaxOpticalYResolution>600</scan:MaxOpticalYResolution>
+      <scan:MaxPhysicalWidth>2550</scan:MaxPhysicalWidth>
+      <scan:MaxPhysicalHeight>4200</scan:MaxPhysicalHeight>
+      <scan:SettingProfiles>
+        <scan:SettingProfile>
+          <scan:ColorModes>
+            <scan:ColorMode>BlackAndWhite1</scan:ColorMode>
+            <scan:ColorMode>Grayscale8</scan:ColorMode>
+            <scan:ColorMode>RGB24</scan:ColorMode>
+          </scan:ColorModes>
+          <scan:ContentTypes>
+            <scan:ContentType>Photo</scan:ContentType>
+            <scan:ContentType>Text</scan:ContentType>
+            <scan:ContentType>TextAndPhoto</scan:ContentType>
+          </scan:ContentTypes>
+          <scan:DocumentFormats>
+            <pwg:DocumentFormat>image/jpeg</pwg:DocumentFormat>
+            <pwg:DocumentFormat>image/png</pwg:DocumentFormat>
+            <pwg:DocumentFormat>application/pdf</pwg:DocumentFormat>
+            <scan:DocumentFormatExt>image/jpeg</scan:DocumentFormatExt>
+            <scan:DocumentFormatExt>image/png</scan:DocumentFormatExt>
+            <scan:DocumentFormatExt>application/pdf</scan:DocumentFormatExt>
+          </scan:DocumentFormats>
+          <scan:SupportedResolutions>
+            <scan:DiscreteResolutions>{{ range $res := resolutions }}
+              <scan:DiscreteResolution>
+                <scan:XResolution>{{ $res }}</scan:XResolution>
+                <scan:YResolution>{{ $res }}</scan:YResolution>
+              </scan:DiscreteResolution>{{ end }}
+            </scan:DiscreteResolutions>
+          </scan:SupportedResolutions>
+          <scan:ColorSpaces>
+            <scan:ColorSpace>RGB</scan:ColorSpace>
+          </scan:ColorSpaces>
+        </scan:SettingProfile>
+      </scan:SettingProfiles>
+      <scan:SupportedIntents>
+        <scan:Intent>Document</scan:Intent>
+        <scan:Intent>Photo</scan:Intent>
+        <scan:Intent>TextAndGraphic</scan:Intent>
+        <scan:Intent>Preview</scan:Intent>
+      </scan:SupportedIntents>
+    {{ end }}`))
+
+func (s *Scanner) capabilitiesXML() []byte {
+	var buf bytes.Buffer
+	if err := capabilitiesTmpl.Execute(&buf, struct {
+		MakeAndModel string
+		UUID         string
+	}{
+		MakeAndModel: xmlEscape(s.MakeAndModel),
+		UUID:         xmlEscape(s.UUID),
+	}); err != nil {
+		panic(fmt.Sprintf("BUG: capabilities template: %v", err))
+	}
+	return buf.Bytes()
+}
+
+func (s *Scanner) serveCapabilities(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/xml")
+	w.Write(s.capabilitiesXML())
+}
+
+var statusTmpl = template.Must(template.New("status").Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<scan:ScannerStatus xmlns:pwg="http://www.pwg.org/schemas/2010/12/sm" xmlns:scan="http://schemas.hp.com/imaging/escl/2011/05/03">
+  <pwg:Version>2.63</pwg:Version>
+  <pwg:State>{{ .State }}</pwg:State>
+  <scan:AdfState>{{ .ADFState }}</scan:AdfState>
+  <scan:Jobs>{{ range .Jobs }}
+    <scan:JobInfo>
+      <pwg:JobUri>{{ .JobURI }}</pwg:JobUri>
+      <pwg:JobUuid>{{ .JobUUID }}</pwg:JobUuid>
+      <scan:Age>{{ .Age }}</scan:Age>
+      <pwg:ImagesCompleted>{{ .ImagesCompleted }}</pwg:ImagesCompleted>
+      <pwg:ImagesToTransfer>{{ .ImagesToTransfer }}</pwg:ImagesToTransfer>
+      <pwg:JobState>{{ .JobState }}</pwg:JobState>
+    </scan:JobInfo>{{ end }}
+  </scan:Jobs>
+</scan:ScannerStatus>
+`))
+
+// status returns the current status of the scanner.
+func (s *Scanner) status() (*airscan.ScannerStatus, error) {
+	pages, err := s.Pages()
+	if err != nil {
+		return nil, err
+	}
+	status := &airscan.ScannerStatus{
+		State:    "Idle",
+		ADFState: "ScannerAdfEmpty",
+	}
+	if len(pages) > 0 {
+		status.ADFState = "ScannerAdfLoaded"
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire()
+	for idx := len(s.jobs) - 1; idx >= 0; idx-- {
+		j := s.jobs[idx]
+		if j.state == "Processing" {
+			status.State = "Processing"
+		}
+		status.Jobs = append(status.Jobs, airscan.JobInfo{
+			JobURI:           "/eSCL/ScanJobs/" + j.id,
+			JobUUID:          j.id,
+			ImagesCompleted:  j.next,
+			ImagesToTransfer: len(j.pages) - j.next,
+			JobState:         j.state,
+		})
+	}
+	return status, nil
+}
+
+func (s *Scanner) serveStatus(w http.ResponseWriter, r *http.Request) {
+	status, err := s.status()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var buf bytes.Buffer
+	if err := statusTmpl.Execute(&buf, status); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/xml")
+	w.Write(buf.Bytes())
+}
+
+func (s *Scanner) createJob(w http.ResponseWriter, r *http.Request) {
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	settings, err := airscan.UnmarshalScanSettings(b)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := settings.Validate(s.caps); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	pages, err := s.Pages()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(pages) == 0 {
+		http.Error(w, "no documents loaded", http.StatusConflict)
+		return
+	}
+	if settings.InputSource == "Platen" {
+		pages = pages[:1]
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire()
+	for _, j := range s.jobs {
+		if j.state == "Processing" {
+			http.Error(w, "scanner busy", http.StatusServiceUnavailable)
+			return
+		}
+	}
+	s.lastID++
+	j := &job{
+		id:         strconv.Itoa(s.lastID),
+		settings:   settings,
+		pages:      pages,
+		state:      "Processing",
+		lastActive: time.Now(),
+	}
+	s.jobs = append(s.jobs, j)
+	if len(s.jobs) > maxJobs {
+		s.jobs = s.jobs[len(s.jobs)-maxJobs:]
+	}
+	w.Header().Set("Location", "/eSCL/ScanJobs/"+j.id)
+	w.WriteHeader(http.StatusCreated)
+}
+
+// expire aborts the scan jobs which exceeded the JobTimeout. s.mu must be held.
+func (s *Scanner) expire() {
+	for _, j := range s.jobs {
+		if j.state == "Processing" && time.Since(j.lastActive) > s.JobTimeout {
+			if s.Debug {
+				log.Printf("aborting scan job %s after %v without activity", j.id, s.JobTimeout)
+			}
+			j.state = "Aborted"
+		}
+	}
+}
+
+// job returns the job with the specified id, or nil. s.mu must be held.
+func (s *Scanner) job(id string) *job {
+	s.expire()
+	for _, j := range s.jobs {
+		if j.id == id {
+			return j
+		}
+	}
+	return nil
+}
+
+func (s *Scanner) nextDocument(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	j := s.job(r.PathValue("job"))
+	if j == nil || j.state != "Processing" {
+		s.mu.Unlock()
+		http.NotFound(w, r)
+		return
+	}
+	if j.next >= len(j.pages) {
+		j.state = "Completed"
+		s.mu.Unlock()
+		http.NotFound(w, r)
+		return
+	}
+	page := j.pages[j.next]
+	j.next++
+	j.lastActive = time.Now()
+	s.mu.Unlock()
+
+	b, contentType, err := s.render(page, j.settings)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Write(b)
+}
+
+func (s *Scanner) deleteJob(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j := s.job(r.PathValue("job"))
+	if j == nil || j.state != "Processing" {
+		http.NotFound(w, r)
+		return
+	}
+	j.state = "Canceled"
+}
+
+func xmlEscape(s string) string {
+	var sb strings.Builder
+	xml.EscapeText(&sb, []byte(s))
+	return sb.String()
+}
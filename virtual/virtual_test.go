@@ -0,0 +1,373 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package virtual_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/stapelberg/airscan"
+	"github.com/stapelberg/airscan/internal/pdf"
+	"github.com/stapelberg/airscan/preset"
+	"github.com/stapelberg/airscan/virtual"
+)
+
+// uniform returns a page of 85x110 pixels (8.5x11 inches at 10 dpi) in color c.
+func uniform(c color.Color) virtual.Page {
+	return func() (image.Image, error) {
+		img := image.NewRGBA(image.Rect(0, 0, 85, 110))
+		for y := 0; y < 110; y++ {
+			for x := 0; x < 85; x++ {
+				img.Set(x, y, c)
+			}
+		}
+		return img, nil
+	}
+}
+
+func newScanner(t *testing.T, pages ...virtual.Page) *airscan.Client {
+	t.Helper()
+	s := &virtual.Scanner{
+		MakeAndModel: "test scanner",
+		Resolution:   10,
+		Pages:        func() ([]virtual.Page, error) { return pages, nil },
+	}
+	srv := httptest.NewServer(s)
+	t.Cleanup(srv.Close)
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return airscan.NewClient(u.Host)
+}
+
+func scanAll(t *testing.T, cl *airscan.Client, settings *airscan.ScanSettings) [][]byte {
+	t.Helper()
+	scan, err := cl.Scan(settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer scan.Close()
+	var pages [][]byte
+	for page, err := range scan.Pages(context.Background()) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages = append(pages, page.Data)
+	}
+	return pages
+}
+
+func TestScanFeeder(t *testing.T) {
+	red := color.RGBA{0xff, 0, 0, 0xff}
+	cl := newScanner(t, uniform(red), uniform(color.White), uniform(color.Black))
+
+	caps, err := cl.ScannerCapabilities()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := caps.MakeAndModel, "test scanner"; got != want {
+		t.Errorf("unexpected MakeAndModel: got %q, want %q", got, want)
+	}
+	settings := preset.GrayscaleA4ADF()
+	if err := settings.Validate(caps); err != nil {
+		t.Fatalf("default preset not supported: %v", err)
+	}
+	settings.XResolution = 75
+	settings.YResolution = 75
+
+	pages := scanAll(t, cl, settings)
+	if got, want := len(pages), 3; got != want {
+		t.Fatalf("unexpected number of pages: got %d, want %d", got, want)
+	}
+	for idx, want := range []uint8{0x4c, 0xff, 0x00} {
+		img, err := jpeg.Decode(bytes.NewReader(pages[idx]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// A4 at 75 dpi:
+		if got, want := img.Bounds(), image.Rect(0, 0, 620, 877); got != want {
+			t.Errorf("page %d: unexpected bounds: got %v, want %v", idx+1, got, want)
+		}
+		g, ok := img.(*image.Gray)
+		if !ok {
+			t.Fatalf("page %d: unexpected image type %T, want *image.Gray", idx+1, img)
+		}
+		if got := g.GrayAt(10, 10).Y; diff(got, want) > 2 {
+			t.Errorf("page %d: unexpected gray value: got %#x, want %#x", idx+1, got, want)
+		}
+		// A4 is taller than letter: the remainder is white.
+		if got := g.GrayAt(10, 870).Y; diff(got, 0xff) > 2 {
+			t.Errorf("page %d: unexpected gray value outside of the page: got %#x, want 0xff", idx+1, got)
+		}
+	}
+
+	status, err := cl.ScannerStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := status.State, "Idle"; got != want {
+		t.Errorf("unexpected state: got %q, want %q", got, want)
+	}
+	if len(status.Jobs) != 1 || status.Jobs[0].JobState != "Completed" {
+		t.Errorf("unexpected jobs: got %+v, want 1 Completed job", status.Jobs)
+	}
+}
+
+func diff(a, b uint8) uint8 {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
+
+func TestScanPlatenRegion(t *testing.T) {
+	// The left half of the page is black, the right half white:
+	page := func() (image.Image, error) {
+		img := image.NewGray(image.Rect(0, 0, 85, 110))
+		for y := 0; y < 110; y++ {
+			for x := 43; x < 85; x++ {
+				img.SetGray(x, y, color.Gray{0xff})
+			}
+		}
+		return img, nil
+	}
+	cl := newScanner(t, page, page)
+
+	settings := preset.GrayscaleA4ADF()
+	settings.InputSource = "Platen"
+	settings.Duplex = false
+	settings.DocumentFormat = "image/png"
+	settings.ColorMode = "RGB24"
+	settings.XResolution = 100
+	settings.YResolution = 100
+	settings.ScanRegions.Regions = []*airscan.ScanRegion{{
+		ContentRegionUnits: airscan.ThreeHundredthsOfInches,
+		XOffset:            airscan.InchesToUnits(4),
+		YOffset:            airscan.InchesToUnits(1),
+		Width:              airscan.InchesToUnits(1),
+		Height:             airscan.InchesToUnits(2),
+	}}
+	pages := scanAll(t, cl, settings)
+	if got, want := len(pages), 1; got != want {
+		t.Fatalf("unexpected number of pages: got %d, want %d", got, want)
+	}
+	img, err := png.Decode(bytes.NewReader(pages[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := img.Bounds(), image.Rect(0, 0, 100, 200); got != want {
+		t.Errorf("unexpected bounds: got %v, want %v", got, want)
+	}
+	if _, ok := img.(*image.RGBA); !ok {
+		t.Errorf("unexpected image type %T, want *image.RGBA", img)
+	}
+	for _, tt := range []struct {
+		x    int
+		want color.RGBA
+	}{
+		{10, color.RGBA{0, 0, 0, 0xff}},
+		{90, color.RGBA{0xff, 0xff, 0xff, 0xff}},
+	} {
+		if got := color.RGBAModel.Convert(img.At(tt.x, 100)); got != tt.want {
+			t.Errorf("pixel at x=%d: got %v, want %v", tt.x, got, tt.want)
+		}
+	}
+}
+
+func TestScanPDF(t *testing.T) {
+	cl := newScanner(t, uniform(color.White))
+	settings := preset.GrayscaleA4ADF()
+	settings.DocumentFormat = "application/pdf"
+	settings.ColorMode = "BlackAndWhite1"
+	pages := scanAll(t, cl, settings)
+	if got, want := len(pages), 1; got != want {
+		t.Fatalf("unexpected number of pages: got %d, want %d", got, want)
+	}
+	images, err := pdf.JPEGs(pages[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(images), 1; got != want {
+		t.Fatalf("unexpected number of images in PDF: got %d, want %d", got, want)
+	}
+}
+
+func TestScanUnsupported(t *testing.T) {
+	cl := newScanner(t, uniform(color.White))
+	settings := preset.GrayscaleA4ADF()
+	settings.XResolution = 1200
+	settings.YResolution = 1200
+	_, err := cl.Scan(settings)
+	var se *airscan.StatusError
+	if !errors.As(err, &se) {
+		t.Fatalf("Scan: got %v, want *airscan.StatusError", err)
+	}
+	if got, want := se.StatusCode, http.StatusConflict; got != want {
+		t.Errorf("unexpected status code: got %d, want %d", got, want)
+	}
+}
+
+func TestEmptyFeeder(t *testing.T) {
+	cl := newScanner(t)
+	status, err := cl.ScannerStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := status.ADFState, "ScannerAdfEmpty"; got != want {
+		t.Errorf("unexpected ADF state: got %q, want %q", got, want)
+	}
+	if _, err := cl.Scan(preset.GrayscaleA4ADF()); err == nil {
+		t.Errorf("Scan unexpectedly succeeded with an empty feeder")
+	}
+}
+
+func TestCancel(t *testing.T) {
+	cl := newScanner(t, uniform(color.White), uniform(color.White))
+	scan, err := cl.Scan(preset.GrayscaleA4ADF())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !scan.ScanPage() {
+		t.Fatal(scan.Err())
+	}
+	if err := scan.Cancel(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if scan.ScanPage() {
+		t.Errorf("ScanPage unexpectedly returned true after Cancel")
+	}
+	if err := scan.Close(); err != nil {
+		t.Fatal(err)
+	}
+	status, err := cl.ScannerStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []airscan.JobInfo{{
+		JobURI:           "/eSCL/ScanJobs/" + scan.JobID(),
+		JobUUID:          scan.JobID(),
+		ImagesCompleted:  1,
+		ImagesToTransfer: 1,
+		JobState:         "Canceled",
+	}}
+	if diff := cmp.Diff(want, status.Jobs); diff != "" {
+		t.Errorf("unexpected jobs: diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestJobTimeout(t *testing.T) {
+	srv := httptest.NewServer(&virtual.Scanner{
+		Resolution: 10,
+		Pages: func() ([]virtual.Page, error) {
+			return []virtual.Page{uniform(color.White)}, nil
+		},
+		JobTimeout: 100 * time.Millisecond,
+	})
+	t.Cleanup(srv.Close)
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cl := airscan.NewClient(u.Host)
+
+	// The client abandons the scan job without requesting any pages:
+	abandoned, err := cl.Scan(preset.GrayscaleA4ADF())
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	status, err := cl.ScannerStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := status.State, "Idle"; got != want {
+		t.Errorf("unexpected state: got %q, want %q", got, want)
+	}
+	if job := status.Job(abandoned.JobID()); job == nil || job.JobState != "Aborted" {
+		t.Errorf("abandoned job not aborted: %+v", job)
+	}
+	// The scanner is no longer busy:
+	if got, want := len(scanAll(t, cl, preset.GrayscaleA4ADF())), 1; got != want {
+		t.Errorf("unexpected number of pages: got %d, want %d", got, want)
+	}
+}
+
+func TestDir(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, b []byte) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, name), b, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	encode := func(img image.Image, fn func(io.Writer, image.Image) error) []byte {
+		t.Helper()
+		var buf bytes.Buffer
+		if err := fn(&buf, img); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	encodeJPEG := func(w io.Writer, img image.Image) error { return jpeg.Encode(w, img, nil) }
+	small := image.NewGray(image.Rect(0, 0, 10, 20))
+	write("2.png", encode(small, png.Encode))
+	var doc bytes.Buffer
+	if err := pdf.Write(&doc, []pdf.Page{
+		{JPEG: encode(image.NewGray(image.Rect(0, 0, 30, 40)), encodeJPEG)},
+		{JPEG: encode(image.NewGray(image.Rect(0, 0, 50, 60)), encodeJPEG)},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	write("3.pdf", doc.Bytes())
+	write("1.jpg", encode(image.NewGray(image.Rect(0, 0, 70, 80)), encodeJPEG))
+	write("README.txt", []byte("ignored"))
+
+	pages, err := virtual.Dir(dir)()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []image.Rectangle
+	for _, page := range pages {
+		img, err := page()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, img.Bounds())
+	}
+	want := []image.Rectangle{
+		image.Rect(0, 0, 70, 80),
+		image.Rect(0, 0, 10, 20),
+		image.Rect(0, 0, 30, 40),
+		image.Rect(0, 0, 50, 60),
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected pages: diff (-want +got):\n%s", diff)
+	}
+}
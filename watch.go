@@ -0,0 +1,259 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package airscan
+
+import (
+	"context"
+	"fmt"
+	"iter"
+	"log"
+	"strings"
+	"time"
+)
+
+// QuietHours is a daily time range (in local time) during which a Watcher
+// does not start scan jobs, e.g. 22:00-07:00.
+type QuietHours struct {
+	// Start and End are the durations since midnight. If End is before
+	// Start, the range extends past midnight.
+	Start time.Duration
+	End   time.Duration
+}
+
+// ParseQuietHours parses a time range like 22:00-07:00.
+func ParseQuietHours(s string) (*QuietHours, error) {
+	start, end, ok := strings.Cut(s, "-")
+	if !ok {
+		return nil, fmt.Errorf("invalid quiet hours %q: want e.g. 22:00-07:00", s)
+	}
+	var q QuietHours
+	for _, v := range []struct {
+		s string
+		d *time.Duration
+	}{
+		{start, &q.Start},
+		{end, &q.End},
+	} {
+		t, err := time.Parse("15:04", strings.TrimSpace(v.s))
+		if err != nil {
+			return nil, fmt.Errorf("invalid quiet hours %q: %v", s, err)
+		}
+		*v.d = time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+	}
+	return &q, nil
+}
+
+// Contains returns whether t is within the quiet hours. A nil *QuietHours
+// contains no time.
+func (q *QuietHours) Contains(t time.Time) bool {
+	if q == nil {
+		return false
+	}
+	h, m, s := t.Clock()
+	d := time.Duration(h)*time.Hour + time.Duration(m)*time.Minute + time.Duration(s)*time.Second
+	if q.Start <= q.End {
+		return q.Start <= d && d < q.End
+	}
+	return d >= q.Start || d < q.End
+}
+
+func (q *QuietHours) String() string {
+	format := func(d time.Duration) string {
+		return fmt.Sprintf("%02d:%02d", int(d.Hours()), int(d.Minutes())%60)
+	}
+	return format(q.Start) + "-" + format(q.End)
+}
+
+// Defaults for the corresponding Watcher fields.
+const (
+	DefaultWatchInterval = 2 * time.Second
+	DefaultMaxFailures   = 5
+)
+
+const (
+	// statusTimeout limits how long a Watcher waits for the scanner status,
+	// so that a hung device counts as a failure instead of blocking Run.
+	statusTimeout = 30 * time.Second
+
+	// cancelTimeout limits how long a Watcher waits for the device to cancel
+	// the scan job in progress once Run's context is done.
+	cancelTimeout = 30 * time.Second
+)
+
+// A Watcher polls the status of a scanner and starts a scan job whenever a
+// stack of documents is inserted into its Automatic Document Feeder (ADF),
+// i.e. when its ADFState changes to ScannerAdfLoaded. Once the scan job is
+// done, the Watcher waits for the next stack.
+//
+// It is safe to update its struct fields before calling Run.
+type Watcher struct {
+	// Scanner is the device to watch.
+	Scanner Scanner
+
+	// Settings are used for each scan job. InputSource must be Feeder.
+	Settings *ScanSettings
+
+	// Job is called with the pages of each scan job, e.g. to write them to
+	// files. Job must iterate over the pages and return the first error.
+	Job func(ctx context.Context, pages iter.Seq2[*Page, error]) error
+
+	// Interval is how often the scanner status is polled. The default is
+	// DefaultWatchInterval.
+	Interval time.Duration
+
+	// Debounce is how long the ADF must be loaded before the scan job
+	// starts, so that users can finish inserting the stack.
+	Debounce time.Duration
+
+	// QuietHours, if non-nil, is a daily time range during which no scan
+	// jobs are started. Stacks inserted during quiet hours are scanned once
+	// the quiet hours end.
+	QuietHours *QuietHours
+
+	// MaxFailures is the number of consecutive failures (of status polls or
+	// of scan jobs) after which Run gives up. The default is
+	// DefaultMaxFailures.
+	MaxFailures int
+
+	// Now returns the current time. The default is time.Now.
+	Now func() time.Time
+
+	// Debug enables logging of state changes.
+	Debug bool
+}
+
+// Run watches the scanner until ctx is done (in which case Run cancels the
+// scan job in progress, if any, and returns ctx.Err()) or until too many
+// consecutive failures occurred.
+//
+// A stack which is already inserted when Run starts is scanned, too. After a
+// failed scan job, the documents need to be re-inserted to retry.
+//
+// Run returns an error if the device does not report its ADFState (e.g.
+// WS-Scan devices), as inserted documents cannot be detected then.
+func (w *Watcher) Run(ctx context.Context) error {
+	if w.Scanner == nil || w.Job == nil || w.Settings == nil {
+		return fmt.Errorf("Watcher: Scanner, Settings and Job must be set")
+	}
+	if got, want := w.Settings.InputSource, "Feeder"; got != want {
+		return fmt.Errorf("Watcher: unexpected InputSource: got %q, want %q", got, want)
+	}
+	interval := w.Interval
+	if interval == 0 {
+		interval = DefaultWatchInterval
+	}
+	maxFailures := w.MaxFailures
+	if maxFailures == 0 {
+		maxFailures = DefaultMaxFailures
+	}
+	now := w.Now
+	if now == nil {
+		now = time.Now
+	}
+	logf := func(format string, v ...any) {
+		if w.Debug {
+			log.Printf("watch: "+format, v...)
+		}
+	}
+
+	var (
+		adfState string // as of the previous status poll
+		polled   bool   // whether a status poll succeeded
+
+		// loadedSince is when the ADFState changed to ScannerAdfLoaded, or
+		// the zero time if no stack is waiting to be scanned.
+		loadedSince time.Time
+
+		statusFailures int
+		jobFailures    int
+		quiet          bool
+	)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		pollCtx, canc := context.WithTimeout(ctx, statusTimeout)
+		status, err := w.Scanner.ScannerStatusContext(pollCtx)
+		canc()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			statusFailures++
+			logf("querying scanner status (failure %d/%d): %v", statusFailures, maxFailures, err)
+			if statusFailures >= maxFailures {
+				return fmt.Errorf("giving up after %d consecutive failures: %v", statusFailures, err)
+			}
+		} else {
+			statusFailures = 0
+			if !polled && status.ADFState == "" {
+				return fmt.Errorf("Watcher: device does not report its ADF state, cannot detect inserted documents")
+			}
+			polled = true
+			if status.ADFState != adfState {
+				logf("ADF state changed from %q to %q", adfState, status.ADFState)
+				adfState = status.ADFState
+				loadedSince = time.Time{}
+				if adfState == "ScannerAdfLoaded" {
+					loadedSince = now()
+				}
+			}
+		}
+
+		if err == nil && !loadedSince.IsZero() {
+			t := now()
+			wasQuiet := quiet
+			quiet = w.QuietHours.Contains(t)
+			if quiet && !wasQuiet {
+				logf("quiet hours (%v): postponing scan job", w.QuietHours)
+			}
+			switch {
+			case t.Sub(loadedSince) < w.Debounce:
+				logf("ADF loaded, waiting %v before scanning", w.Debounce)
+			case quiet:
+			case status.State != "" && status.State != "Idle":
+				logf("scanner busy (state %q), postponing scan job", status.State)
+			default:
+				// Require a change of the ADFState before the next scan
+				// job, even if documents remain in the ADF:
+				loadedSince = time.Time{}
+				logf("starting scan job")
+				err := w.Job(ctx, w.Scanner.ScanPages(ctx, w.Settings))
+				if ctx.Err() != nil {
+					cctx, canc := context.WithTimeout(context.Background(), cancelTimeout)
+					defer canc()
+					if err := w.Scanner.Cancel(cctx); err != nil {
+						logf("canceling scan job: %v", err)
+					}
+					return ctx.Err()
+				}
+				if err != nil {
+					jobFailures++
+					logf("scan job failed (failure %d/%d): %v", jobFailures, maxFailures, err)
+					if jobFailures >= maxFailures {
+						return fmt.Errorf("giving up after %d consecutive failed scan jobs: %v", jobFailures, err)
+					}
+				} else {
+					jobFailures = 0
+				}
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
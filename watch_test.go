@@ -0,0 +1,304 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package airscan_test
+
+import (
+	"context"
+	"errors"
+	"iter"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/stapelberg/airscan"
+	"github.com/stapelberg/airscan/preset"
+)
+
+// toggler is a mock scanner whose ADF state follows a script: each status poll
+// returns the next state. Once the script is exhausted, the context is
+// canceled.
+type toggler struct {
+	states []string
+	cancel context.CancelFunc
+
+	polls int
+	jobs  []int // poll number at which each scan job started
+}
+
+func (tg *toggler) scanner(pages int) *airscan.ScannerFuncs {
+	return &airscan.ScannerFuncs{
+		StatusFunc: func(context.Context) (*airscan.ScannerStatus, error) {
+			if tg.polls == len(tg.states) {
+				tg.cancel()
+				return &airscan.ScannerStatus{State: "Idle"}, nil
+			}
+			state := tg.states[tg.polls]
+			tg.polls++
+			return &airscan.ScannerStatus{
+				State:    "Idle",
+				ADFState: state,
+			}, nil
+		},
+		ScanPagesFunc: func(ctx context.Context, settings *airscan.ScanSettings) iter.Seq2[*airscan.Page, error] {
+			return func(yield func(*airscan.Page, error) bool) {
+				tg.jobs = append(tg.jobs, tg.polls)
+				for i := 1; i <= pages; i++ {
+					if !yield(&airscan.Page{Number: i}, nil) {
+						return
+					}
+				}
+			}
+		},
+	}
+}
+
+const (
+	loaded = "ScannerAdfLoaded"
+	empty  = "ScannerAdfEmpty"
+)
+
+// countPages is a Watcher.Job which counts the received pages.
+func countPages(n *int) func(context.Context, iter.Seq2[*airscan.Page, error]) error {
+	return func(ctx context.Context, pages iter.Seq2[*airscan.Page, error]) error {
+		for _, err := range pages {
+			if err != nil {
+				return err
+			}
+			*n++
+		}
+		return nil
+	}
+}
+
+func TestWatcher(t *testing.T) {
+	ctx, canc := context.WithCancel(context.Background())
+	defer canc()
+	tg := &toggler{
+		states: []string{empty, loaded, loaded, empty, empty, loaded, loaded},
+		cancel: canc,
+	}
+	var pages int
+	w := &airscan.Watcher{
+		Scanner:  tg.scanner(2),
+		Settings: preset.GrayscaleA4ADF(),
+		Job:      countPages(&pages),
+		Interval: time.Millisecond,
+	}
+	if err := w.Run(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Run: got err %v, want %v", err, context.Canceled)
+	}
+	// One scan job per stack, started at the first poll which reported the
+	// loaded ADF:
+	if diff := cmp.Diff([]int{2, 6}, tg.jobs); diff != "" {
+		t.Fatalf("unexpected scan jobs: diff (-want +got):\n%s", diff)
+	}
+	if got, want := pages, 4; got != want {
+		t.Fatalf("unexpected number of pages: got %d, want %d", got, want)
+	}
+}
+
+// clock is a fake clock which advances by one second whenever it is read.
+type clock struct {
+	t time.Time
+}
+
+func (c *clock) now() time.Time {
+	c.t = c.t.Add(time.Second)
+	return c.t
+}
+
+func TestWatcherDebounce(t *testing.T) {
+	ctx, canc := context.WithCancel(context.Background())
+	defer canc()
+	tg := &toggler{
+		// The stack is inserted, briefly removed, then inserted for good:
+		states: []string{empty, loaded, empty, loaded, loaded, loaded, loaded},
+		cancel: canc,
+	}
+	var pages int
+	c := &clock{t: time.Date(2020, 1, 1, 12, 0, 0, 0, time.Local)}
+	w := &airscan.Watcher{
+		Scanner:  tg.scanner(1),
+		Settings: preset.GrayscaleA4ADF(),
+		Job:      countPages(&pages),
+		Interval: time.Millisecond,
+		Debounce: 3 * time.Second,
+		Now:      c.now,
+	}
+	if err := w.Run(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Run: got err %v, want %v", err, context.Canceled)
+	}
+	// The clock advances whenever it is read, so the stack inserted at poll 4
+	// was loaded for 3s at poll 6:
+	if diff := cmp.Diff([]int{6}, tg.jobs); diff != "" {
+		t.Fatalf("unexpected scan jobs: diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestWatcherQuietHours(t *testing.T) {
+	ctx, canc := context.WithCancel(context.Background())
+	defer canc()
+	tg := &toggler{
+		states: []string{loaded, loaded, loaded, loaded},
+		cancel: canc,
+	}
+	quiet, err := airscan.ParseQuietHours("22:00-07:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pages int
+	// Quiet hours end after the clock was read four times:
+	c := &clock{t: time.Date(2020, 1, 1, 6, 59, 56, 0, time.Local)}
+	w := &airscan.Watcher{
+		Scanner:    tg.scanner(1),
+		Settings:   preset.GrayscaleA4ADF(),
+		Job:        countPages(&pages),
+		Interval:   time.Millisecond,
+		QuietHours: quiet,
+		Now:        c.now,
+	}
+	if err := w.Run(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Run: got err %v, want %v", err, context.Canceled)
+	}
+	if diff := cmp.Diff([]int{3}, tg.jobs); diff != "" {
+		t.Fatalf("unexpected scan jobs: diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestWatcherHungStatus(t *testing.T) {
+	ctx, canc := context.WithCancel(context.Background())
+	defer canc()
+	w := &airscan.Watcher{
+		Scanner: &airscan.ScannerFuncs{
+			StatusFunc: func(ctx context.Context) (*airscan.ScannerStatus, error) {
+				// The device never responds:
+				canc()
+				<-ctx.Done()
+				return nil, ctx.Err()
+			},
+		},
+		Settings: preset.GrayscaleA4ADF(),
+		Job:      countPages(new(int)),
+		Interval: time.Millisecond,
+	}
+	if err := w.Run(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Run: got err %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestWatcherNoADFState(t *testing.T) {
+	var polls int
+	w := &airscan.Watcher{
+		Scanner: &airscan.ScannerFuncs{
+			StatusFunc: func(context.Context) (*airscan.ScannerStatus, error) {
+				polls++
+				return &airscan.ScannerStatus{State: "Idle"}, nil
+			},
+		},
+		Settings: preset.GrayscaleA4ADF(),
+		Job:      countPages(new(int)),
+		Interval: time.Millisecond,
+	}
+	err := w.Run(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "ADF state") {
+		t.Fatalf("Run: got err %v, want an error about the ADF state", err)
+	}
+	if got, want := polls, 1; got != want {
+		t.Errorf("unexpected number of status polls: got %d, want %d", got, want)
+	}
+}
+
+func TestWatcherMaxFailures(t *testing.T) {
+	ctx, canc := context.WithCancel(context.Background())
+	defer canc()
+	tg := &toggler{
+		states: []string{loaded, empty, loaded, empty, loaded, empty, loaded, empty},
+		cancel: canc,
+	}
+	errJammed := errors.New("paper jam")
+	w := &airscan.Watcher{
+		Scanner:  tg.scanner(1),
+		Settings: preset.GrayscaleA4ADF(),
+		Job: func(ctx context.Context, pages iter.Seq2[*airscan.Page, error]) error {
+			for range pages {
+			}
+			return errJammed
+		},
+		Interval:    time.Millisecond,
+		MaxFailures: 3,
+	}
+	err := w.Run(ctx)
+	if err == nil || !strings.Contains(err.Error(), "3 consecutive") || !strings.Contains(err.Error(), errJammed.Error()) {
+		t.Fatalf("Run: got err %v, want giving up after 3 consecutive failures", err)
+	}
+	if got, want := len(tg.jobs), 3; got != want {
+		t.Fatalf("unexpected number of scan jobs: got %d, want %d", got, want)
+	}
+
+	// Failing status polls count, too:
+	w.Scanner = &airscan.ScannerFuncs{}
+	if err := w.Run(context.Background()); err == nil || !strings.Contains(err.Error(), "3 consecutive") {
+		t.Fatalf("Run: got err %v, want giving up after 3 consecutive failures", err)
+	}
+}
+
+func TestParseQuietHours(t *testing.T) {
+	at := func(hour, min int) time.Time {
+		return time.Date(2020, 1, 1, hour, min, 0, 0, time.Local)
+	}
+	for _, tt := range []struct {
+		quiet   string
+		inside  []time.Time
+		outside []time.Time
+	}{
+		{
+			quiet:   "22:00-07:00",
+			inside:  []time.Time{at(22, 0), at(23, 59), at(0, 0), at(6, 59)},
+			outside: []time.Time{at(7, 0), at(12, 0), at(21, 59)},
+		},
+		{
+			quiet:   "12:30-13:30",
+			inside:  []time.Time{at(12, 30), at(13, 29)},
+			outside: []time.Time{at(12, 29), at(13, 30), at(0, 0)},
+		},
+	} {
+		t.Run(tt.quiet, func(t *testing.T) {
+			q, err := airscan.ParseQuietHours(tt.quiet)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := q.String(); got != tt.quiet {
+				t.Errorf("String: got %q, want %q", got, tt.quiet)
+			}
+			for _, tm := range tt.inside {
+				if !q.Contains(tm) {
+					t.Errorf("Contains(%v) = false, want true", tm.Format("15:04"))
+				}
+			}
+			for _, tm := range tt.outside {
+				if q.Contains(tm) {
+					t.Errorf("Contains(%v) = true, want false", tm.Format("15:04"))
+				}
+			}
+		})
+	}
+
+	for _, invalid := range []string{"", "22:00", "25:00-07:00", "22:00-7"} {
+		if _, err := airscan.ParseQuietHours(invalid); err == nil {
+			t.Errorf("ParseQuietHours(%q) unexpectedly succeeded", invalid)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wsscan
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// MulticastAddr is the address to which WS-Discovery probes are sent.
+const MulticastAddr = "239.255.255.250:3702"
+
+// A Device is a WS-Scan device which responded to a WS-Discovery probe.
+type Device struct {
+	// Address identifies the device (its WS-Addressing endpoint reference),
+	// typically a urn:uuid: URN.
+	Address string
+
+	// Types are the qualified names of the types the device implements,
+	// e.g. wscn:ScanDeviceType.
+	Types []string
+
+	// XAddrs are the transport addresses (HTTP URLs) of the device. Use
+	// ScanService to find the address of its scan service.
+	XAddrs []string
+}
+
+type probe struct {
+	XMLName xml.Name `xml:"wsd:Probe"`
+	Types   string   `xml:"wsd:Types"`
+}
+
+type probeMatches struct {
+	Matches []struct {
+		Address string `xml:"EndpointReference>Address"`
+		Types   string `xml:"Types"`
+		XAddrs  string `xml:"XAddrs"`
+	} `xml:"ProbeMatches>ProbeMatch"`
+}
+
+// Probe sends a WS-Discovery probe for scan devices to addr (MulticastAddr if
+// empty) and returns all devices which responded until ctx is done. Use
+// context.WithTimeout to limit how long Probe waits for responses, e.g. to a
+// few seconds.
+func Probe(ctx context.Context, addr string) ([]*Device, error) {
+	if addr == "" {
+		addr = MulticastAddr
+	}
+	raddr, err := net.ResolveUDPAddr("udp4", addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetReadDeadline(time.Now())
+	})
+	defer stop()
+
+	messageID := newMessageID()
+	msg, err := marshalEnvelope(header{
+		To:        "urn:schemas-xmlsoap-org:ws:2005:04:discovery",
+		Action:    nsDiscovery + "/Probe",
+		MessageID: messageID,
+	}, &probe{Types: "wscn:ScanDeviceType"})
+	if err != nil {
+		return nil, err
+	}
+	// UDP messages might get lost, so send the probe twice (as recommended
+	// by the WS-Discovery specification). Devices reply to both.
+	for i := 0; i < 2; i++ {
+		if _, err := conn.WriteToUDP(msg, raddr); err != nil {
+			return nil, err
+		}
+	}
+
+	var (
+		devices []*Device
+		seen    = make(map[string]bool)
+		buf     = make([]byte, 65536)
+	)
+	for {
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return devices, nil
+			}
+			return devices, err
+		}
+		var matches probeMatches
+		resp, err := unmarshalResponse(buf[:n], &matches)
+		if err != nil || strings.TrimSpace(resp.Header.RelatesTo) != messageID {
+			continue // not a response to our probe
+		}
+		for _, m := range matches.Matches {
+			address := strings.TrimSpace(m.Address)
+			if seen[address] {
+				continue
+			}
+			seen[address] = true
+			devices = append(devices, &Device{
+				Address: address,
+				Types:   strings.Fields(m.Types),
+				XAddrs:  strings.Fields(m.XAddrs),
+			})
+		}
+	}
+}
+
+type metadata struct {
+	Sections []struct {
+		Hosted []struct {
+			Address string `xml:"EndpointReference>Address"`
+			Types   string `xml:"Types"`
+		} `xml:"Relationship>Hosted"`
+	} `xml:"Metadata>MetadataSection"`
+}
+
+// ScanService retrieves the metadata of the device (using WS-Transfer Get) and
+// returns the address of its scan service, which is the endpoint to use with
+// NewClient. The device’s transport addresses are tried in order.
+func (d *Device) ScanService(ctx context.Context) (string, error) {
+	if len(d.XAddrs) == 0 {
+		return "", fmt.Errorf("device %s has no transport addresses", d.Address)
+	}
+	var errs []error
+	for _, xaddr := range d.XAddrs {
+		addr, err := d.scanService(ctx, xaddr)
+		if err == nil {
+			return addr, nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %v", xaddr, err))
+	}
+	return "", errors.Join(errs...)
+}
+
+func (d *Device) scanService(ctx context.Context, xaddr string) (string, error) {
+	msg, err := marshalEnvelope(header{
+		To:        d.Address,
+		Action:    nsTransfer + "/Get",
+		MessageID: newMessageID(),
+		ReplyTo:   anonymous,
+	}, nil)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", xaddr, bytes.NewReader(msg))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", soapContentType)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	var md metadata
+	if _, err := unmarshalResponse(b, &md); err != nil {
+		return "", err
+	}
+	for _, section := range md.Sections {
+		for _, hosted := range section.Hosted {
+			for _, typ := range strings.Fields(hosted.Types) {
+				if localName(typ) == "ScannerServiceType" {
+					return strings.TrimSpace(hosted.Address), nil
+				}
+			}
+		}
+	}
+	return "", fmt.Errorf("device metadata does not list a scan service")
+}
@@ -0,0 +1,437 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wsscan
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"math"
+	"sync/atomic"
+	"text/template"
+
+	"github.com/stapelberg/airscan"
+)
+
+var _ airscan.Scanner = (*Client)(nil)
+
+// ScannerStatusContext queries the device for its status, translated to eSCL.
+//
+// WS-Scan devices do not report whether documents are inserted into the ADF,
+// only error conditions (like InputTrayEmpty). ADFState is therefore empty
+// (i.e. unknown) unless an ADF condition is active. Hence, airscan.Watcher,
+// which starts scan jobs when documents are inserted into the ADF, does not
+// work with WS-Scan devices.
+func (c *Client) ScannerStatusContext(ctx context.Context) (*airscan.ScannerStatus, error) {
+	elems, err := c.GetScannerElements(ctx,
+		ElementScannerConfiguration,
+		ElementScannerStatus)
+	if err != nil {
+		return nil, err
+	}
+	if elems.Status == nil {
+		return nil, fmt.Errorf("device did not return %s", ElementScannerStatus)
+	}
+	status := &airscan.ScannerStatus{
+		State: elems.Status.State,
+	}
+	if cfg := elems.Configuration; cfg != nil && cfg.ADF != nil {
+		for _, cond := range elems.Status.Conditions {
+			if cond.Component != "" && cond.Component != "ADF" {
+				continue
+			}
+			switch cond.Name {
+			case "InputTrayEmpty":
+				status.ADFState = "ScannerAdfEmpty"
+			case "MediaJam":
+				status.ADFState = "ScannerAdfJam"
+			case "CoverOpen":
+				status.ADFState = "ScannerAdfHatchOpen"
+			}
+		}
+	}
+	return status, nil
+}
+
+// ScannerCapabilitiesContext queries the device for its description and
+// configuration, translated to eSCL capabilities.
+func (c *Client) ScannerCapabilitiesContext(ctx context.Context) (*airscan.ScannerCapabilities, error) {
+	elems, err := c.GetScannerElements(ctx,
+		ElementScannerDescription,
+		ElementScannerConfiguration)
+	if err != nil {
+		return nil, err
+	}
+	if elems.Configuration == nil {
+		return nil, fmt.Errorf("device did not return %s", ElementScannerConfiguration)
+	}
+	return capabilities(elems.Description, elems.Configuration)
+}
+
+// formats maps eSCL document formats to WS-Scan formats.
+var formats = []struct {
+	escl   string
+	wsscan []string
+}{
+	{"image/jpeg", []string{"jfif", "exif"}},
+	{"image/png", []string{"png"}},
+	{"application/pdf", []string{"pdf-a", "pdf"}},
+	{"image/tiff", []string{"tiff-single-uncompressed", "tiff-single-g4", "tiff-single-jpeg-tn2"}},
+}
+
+// contentTypes maps eSCL content types to WS-Scan content types.
+var contentTypes = []struct {
+	escl   string
+	wsscan string
+}{
+	{"Text", "Text"},
+	{"Photo", "Photo"},
+	{"TextAndPhoto", "Mixed"},
+	{"Halftone", "Halftone"},
+}
+
+// capsData is the input of capabilitiesTmpl, with all sizes converted to
+// eSCL units.
+type capsData struct {
+	MakeAndModel string
+	Formats      []string
+	ContentTypes []string
+	Platen       *InputSource
+	ADFSimplex   *InputSource
+	ADFDuplex    *InputSource
+}
+
+// capabilities translates the WS-Scan configuration into eSCL capabilities, by
+// rendering and decoding an eSCL ScannerCapabilities document. All values are
+// XML-escaped, as they might contain e.g. & (“AT&T”).
+func capabilities(desc *ScannerDescription, cfg *ScannerConfiguration) (*airscan.ScannerCapabilities, error) {
+	data := capsData{
+		Platen: cfg.Platen,
+	}
+	if desc != nil {
+		data.MakeAndModel = desc.Name
+	}
+	for _, f := range formats {
+		if intersects(f.wsscan, cfg.Formats) {
+			data.Formats = append(data.Formats, f.escl)
+		}
+	}
+	for _, ct := range contentTypes {
+		if contains(cfg.ContentTypes, ct.wsscan) {
+			data.ContentTypes = append(data.ContentTypes, ct.escl)
+		}
+	}
+	if adf := cfg.ADF; adf != nil && adf.Front != nil {
+		data.ADFSimplex = adf.Front
+		if adf.SupportsDuplex {
+			data.ADFDuplex = adf.Front
+			if adf.Back != nil {
+				data.ADFDuplex = adf.Back
+			}
+		}
+	}
+	var buf bytes.Buffer
+	if err := capabilitiesTmpl.Execute(&buf, &data); err != nil {
+		return nil, err
+	}
+	caps := new(airscan.ScannerCapabilities)
+	if err := xml.Unmarshal(buf.Bytes(), caps); err != nil {
+		return nil, err
+	}
+	return caps, nil
+}
+
+func contains(values []string, v string) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
+
+func intersects(a, b []string) bool {
+	for _, v := range a {
+		if contains(b, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// toUnits converts thousandths of an inch to escl:ThreeHundredthsOfInches.
+func toUnits(v int) int {
+	return airscan.InchesToUnits(float64(v) / 1000)
+}
+
+// fromUnits converts escl:ThreeHundredthsOfInches to thousandths of an inch.
+func fromUnits(v int) int {
+	return int(math.Round(airscan.UnitsToInches(v) * 1000))
+}
+
+// resolutions pairs the X and Y resolutions of s.
+func resolutions(s *InputSource) []Size {
+	res := make([]Size, 0, len(s.XResolutions))
+	for idx, x := range s.XResolutions {
+		y := x
+		if idx < len(s.YResolutions) {
+			y = s.YResolutions[idx]
+		}
+		res = append(res, Size{Width: x, Height: y})
+	}
+	return res
+}
+
+// xmlEscape returns the XML-escaped text representation of v.
+func xmlEscape(v any) (string, error) {
+	var buf bytes.Buffer
+	if err := xml.EscapeText(&buf, []byte(fmt.Sprint(v))); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+var capabilitiesTmpl = template.Must(template.New("caps").Funcs(template.FuncMap{
+	"xml":         xmlEscape,
+	"units":       toUnits,
+	"resolutions": resolutions,
+	"args": func(caps *capsData, src *InputSource) any {
+		return struct {
+			Caps   *capsData
+			Source *InputSource
+		}{caps, src}
+	},
+}).Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<scan:ScannerCapabilities xmlns:pwg="http://www.pwg.org/schemas/2010/12/sm" xmlns:scan="http://schemas.hp.com/imaging/escl/2011/05/03">
+  <pwg:MakeAndModel>{{ .MakeAndModel | xml }}</pwg:MakeAndModel>
+{{- with .Platen }}
+  <scan:Platen>
+    <scan:PlatenInputCaps>{{ template "inputcaps" (args $ .) }}</scan:PlatenInputCaps>
+  </scan:Platen>
+{{- end }}
+{{- with .ADFSimplex }}
+  <scan:Adf>
+    <scan:AdfSimplexInputCaps>{{ template "inputcaps" (args $ .) }}</scan:AdfSimplexInputCaps>
+{{- with $.ADFDuplex }}
+    <scan:AdfDuplexInputCaps>{{ template "inputcaps" (args $ .) }}</scan:AdfDuplexInputCaps>
+{{- end }}
+  </scan:Adf>
+{{- end }}
+</scan:ScannerCapabilities>
+{{ define "inputcaps" }}
+      <scan:MinWidth>{{ units .Source.MinimumSize.Width | xml }}</scan:MinWidth>
+      <scan:MaxWidth>{{ units .Source.MaximumSize.Width | xml }}</scan:MaxWidth>
+      <scan:MinHeight>{{ units .Source.MinimumSize.Height | xml }}</scan:MinHeight>
+      <scan:MaxHeight>{{ units .Source.MaximumSize.Height | xml }}</scan:MaxHeight>
+      <scan:MaxScanRegions>1</scan:MaxScanRegions>
+      <scan:MaxOpticalXResolution>{{ .Source.OpticalResolution.Width | xml }}</scan:MaxOpticalXResolution>
+      <scan:MaxOpticalYResolution>{{ .Source.OpticalResolution.Height | xml }}</scan:MaxOpticalYResolution>
+      <scan:MaxPhysicalWidth>{{ units .Source.MaximumSize.Width | xml }}</scan:MaxPhysicalWidth>
+      <scan:MaxPhysicalHeight>{{ units .Source.MaximumSize.Height | xml }}</scan:MaxPhysicalHeight>
+      <scan:SettingProfiles>
+        <scan:SettingProfile>
+          <scan:ColorModes>
+{{- range .Source.ColorModes }}
+            <scan:ColorMode>{{ . | xml }}</scan:ColorMode>
+{{- end }}
+          </scan:ColorModes>
+          <scan:ContentTypes>
+{{- range .Caps.ContentTypes }}
+            <pwg:ContentType>{{ . | xml }}</pwg:ContentType>
+{{- end }}
+          </scan:ContentTypes>
+          <scan:DocumentFormats>
+{{- range .Caps.Formats }}
+            <pwg:DocumentFormat>{{ . | xml }}</pwg:DocumentFormat>
+{{- end }}
+          </scan:DocumentFormats>
+          <scan:SupportedResolutions>
+            <scan:DiscreteResolutions>
+{{- range resolutions .Source }}
+              <scan:DiscreteResolution>
+                <scan:XResolution>{{ .Width | xml }}</scan:XResolution>
+                <scan:YResolution>{{ .Height | xml }}</scan:YResolution>
+              </scan:DiscreteResolution>
+{{- end }}
+            </scan:DiscreteResolutions>
+          </scan:SupportedResolutions>
+        </scan:SettingProfile>
+      </scan:SettingProfiles>
+{{ end }}`))
+
+// ticket translates eSCL scan settings into a WS-Scan scan ticket.
+func ticket(settings *airscan.ScanSettings) (*ScanTicket, error) {
+	t := &ScanTicket{
+		JobName: "airscan",
+		Front: MediaSide{
+			ColorProcessing: settings.ColorMode,
+			Resolution: Size{
+				Width:  settings.XResolution,
+				Height: settings.YResolution,
+			},
+		},
+	}
+
+	switch settings.InputSource {
+	case "Platen":
+		t.InputSource = "Platen"
+		t.ImagesToTransfer = 1
+	case "Feeder":
+		t.InputSource = "ADF"
+		if settings.Duplex {
+			t.InputSource = "ADFDuplex"
+		}
+	default:
+		return nil, fmt.Errorf("unsupported input source %q", settings.InputSource)
+	}
+
+	format := settings.DocumentFormatExt
+	if format == "" {
+		format = settings.DocumentFormat
+	}
+	for _, f := range formats {
+		if f.escl == format {
+			t.Format = f.wsscan[0]
+		}
+	}
+	if t.Format == "" {
+		return nil, fmt.Errorf("unsupported document format %q", format)
+	}
+
+	if settings.ContentType != "" {
+		for _, ct := range contentTypes {
+			if ct.escl == settings.ContentType {
+				t.ContentType = ct.wsscan
+			}
+		}
+		if t.ContentType == "" {
+			return nil, fmt.Errorf("unsupported content type %q", settings.ContentType)
+		}
+	}
+
+	switch regions := settings.ScanRegions.Regions; len(regions) {
+	case 0:
+	case 1:
+		r := regions[0]
+		t.Front.Region = &Region{
+			XOffset: fromUnits(r.XOffset),
+			YOffset: fromUnits(r.YOffset),
+			Width:   fromUnits(r.Width),
+			Height:  fromUnits(r.Height),
+		}
+	default:
+		return nil, fmt.Errorf("WS-Scan supports only one scan region, got %d", len(regions))
+	}
+	return t, nil
+}
+
+// activeJob is a scan job started by ScanPages, see Cancel.
+type activeJob struct {
+	job      *ScanJob
+	canceled atomic.Bool
+}
+
+func (c *Client) track(j *activeJob) {
+	c.jobsMu.Lock()
+	defer c.jobsMu.Unlock()
+	if c.jobs == nil {
+		c.jobs = make(map[*activeJob]bool)
+	}
+	c.jobs[j] = true
+}
+
+func (c *Client) untrack(j *activeJob) {
+	c.jobsMu.Lock()
+	defer c.jobsMu.Unlock()
+	delete(c.jobs, j)
+}
+
+// ScanPages creates a scan job using the specified settings (translated to a
+// WS-Scan scan ticket) and returns an iterator over its pages, see
+// airscan.ScanState.Pages. When the loop is exited early, the scan job is
+// canceled.
+func (c *Client) ScanPages(ctx context.Context, settings *airscan.ScanSettings) iter.Seq2[*airscan.Page, error] {
+	return func(yield func(*airscan.Page, error) bool) {
+		t, err := ticket(settings)
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		job, err := c.CreateScanJob(ctx, t)
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		active := &activeJob{job: job}
+		c.track(active)
+		defer c.untrack(active)
+		cancel := func() {
+			if !active.canceled.Load() {
+				c.CancelJob(context.Background(), job)
+			}
+		}
+		for number := 1; !active.canceled.Load(); number++ {
+			rc, err := c.RetrieveImage(ctx, job)
+			if err == io.EOF {
+				return // all pages received
+			}
+			if active.canceled.Load() {
+				if rc != nil {
+					rc.Close()
+				}
+				return // canceled while retrieving the image
+			}
+			if err != nil {
+				cancel()
+				yield(nil, err)
+				return
+			}
+			b, err := io.ReadAll(rc)
+			rc.Close()
+			if err != nil {
+				cancel()
+				yield(nil, err)
+				return
+			}
+			if !yield(&airscan.Page{Number: number, Data: b}, nil) {
+				cancel()
+				return
+			}
+		}
+	}
+}
+
+// Cancel aborts the scan jobs which were started by ScanPages and are in
+// progress. Iterations over their pages end without an error.
+func (c *Client) Cancel(ctx context.Context) error {
+	c.jobsMu.Lock()
+	jobs := make([]*activeJob, 0, len(c.jobs))
+	for j := range c.jobs {
+		jobs = append(jobs, j)
+	}
+	c.jobsMu.Unlock()
+	var errs []error
+	for _, j := range jobs {
+		j.canceled.Store(true)
+		if err := c.CancelJob(ctx, j.job); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
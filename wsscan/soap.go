@@ -0,0 +1,174 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wsscan
+
+import (
+	"crypto/rand"
+	"encoding/xml"
+	"fmt"
+	"strings"
+)
+
+// XML namespaces of the protocols which WS-Scan is built on.
+const (
+	nsSOAP       = "http://www.w3.org/2003/05/soap-envelope"
+	nsAddressing = "http://schemas.xmlsoap.org/ws/2004/08/addressing"
+	nsDiscovery  = "http://schemas.xmlsoap.org/ws/2005/04/discovery"
+	nsTransfer   = "http://schemas.xmlsoap.org/ws/2004/09/transfer"
+	nsScan       = "http://schemas.microsoft.com/windows/2006/08/wdp/scan"
+)
+
+// anonymous is the WS-Addressing endpoint for replies on the same connection.
+var anonymous = &endpointReference{Address: nsAddressing + "/role/anonymous"}
+
+// envelope is an outgoing SOAP 1.2 message.
+type envelope struct {
+	XMLName   xml.Name `xml:"soap:Envelope"`
+	XmlnsSOAP string   `xml:"xmlns:soap,attr"`
+	XmlnsWSA  string   `xml:"xmlns:wsa,attr"`
+	XmlnsWSD  string   `xml:"xmlns:wsd,attr"`
+	XmlnsWSCN string   `xml:"xmlns:wscn,attr"`
+	Header    header   `xml:"soap:Header"`
+	Body      struct {
+		Content any
+	} `xml:"soap:Body"`
+}
+
+type header struct {
+	To        string             `xml:"wsa:To"`
+	Action    string             `xml:"wsa:Action"`
+	MessageID string             `xml:"wsa:MessageID"`
+	ReplyTo   *endpointReference `xml:"wsa:ReplyTo,omitempty"`
+}
+
+type endpointReference struct {
+	Address string `xml:"wsa:Address"`
+}
+
+// marshalEnvelope returns a SOAP message with the specified header and body
+// content (nil for an empty body).
+func marshalEnvelope(h header, content any) ([]byte, error) {
+	env := envelope{
+		XmlnsSOAP: nsSOAP,
+		XmlnsWSA:  nsAddressing,
+		XmlnsWSD:  nsDiscovery,
+		XmlnsWSCN: nsScan,
+		Header:    h,
+	}
+	env.Body.Content = content
+	b, err := xml.MarshalIndent(&env, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), b...), nil
+}
+
+// response is an incoming SOAP message. As encoding/xml matches elements by
+// their local name, the namespace prefixes used by the device do not matter.
+type response struct {
+	Header struct {
+		Action    string `xml:"Action"`
+		RelatesTo string `xml:"RelatesTo"`
+	} `xml:"Header"`
+	Body struct {
+		Fault   *faultXML `xml:"Fault"`
+		Content []byte    `xml:",innerxml"`
+	} `xml:"Body"`
+}
+
+// unmarshalResponse decodes the SOAP message b. If the body contains a SOAP
+// fault, a *Fault is returned. Otherwise, the body content is decoded into v
+// (unless v is nil).
+func unmarshalResponse(b []byte, v any) (*response, error) {
+	var resp response
+	if err := xml.Unmarshal(b, &resp); err != nil {
+		return nil, fmt.Errorf("decoding SOAP message: %v", err)
+	}
+	if f := resp.Body.Fault; f != nil {
+		return nil, &Fault{
+			Code:    localName(f.Code.Value),
+			Subcode: localName(f.Code.Subcode.Value),
+			Reason:  strings.TrimSpace(f.Reason.Text),
+		}
+	}
+	if v == nil {
+		return &resp, nil
+	}
+	// Wrap the content in an element, so that v can be a struct describing
+	// the children of the body:
+	content := append(append([]byte("<Body>"), resp.Body.Content...), "</Body>"...)
+	if err := xml.Unmarshal(content, v); err != nil {
+		return nil, fmt.Errorf("decoding SOAP body: %v", err)
+	}
+	return &resp, nil
+}
+
+type faultXML struct {
+	Code struct {
+		Value   string `xml:"Value"`
+		Subcode struct {
+			Value string `xml:"Value"`
+		} `xml:"Subcode"`
+	} `xml:"Code"`
+	Reason struct {
+		Text string `xml:"Text"`
+	} `xml:"Reason"`
+}
+
+// A Fault is a SOAP fault returned by the device.
+type Fault struct {
+	// Code is the local name of the fault code, e.g. Sender or Receiver.
+	Code string
+
+	// Subcode is the local name of the fault subcode, e.g.
+	// ServerErrorNotAcceptingJobs. See the WS-Scan specification for all
+	// possible values.
+	Subcode string
+
+	// Reason is the human-readable explanation, if any.
+	Reason string
+}
+
+func (f *Fault) Error() string {
+	msg := "SOAP fault: " + f.Code
+	if f.Subcode != "" {
+		msg += "/" + f.Subcode
+	}
+	if f.Reason != "" {
+		msg += ": " + f.Reason
+	}
+	return msg
+}
+
+// localName strips the namespace prefix (if any) from the qualified name.
+func localName(qname string) string {
+	qname = strings.TrimSpace(qname)
+	if idx := strings.LastIndexByte(qname, ':'); idx > -1 {
+		return qname[idx+1:]
+	}
+	return qname
+}
+
+// newMessageID returns a random (version 4) UUID URN, as used to identify
+// messages.
+func newMessageID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("urn:uuid:%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
@@ -0,0 +1,565 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package wsscan can be used to scan paper documents from a scanner via the
+// network, using the Microsoft WS-Scan protocol (Web Services on Devices, also
+// known as WSD), which some older devices speak instead of eSCL.
+//
+// Devices are discovered using WS-Discovery (see Probe). A Client implements
+// the WS-Scan operations GetScannerElements, CreateScanJob, RetrieveImage and
+// CancelJob, and also implements airscan.Scanner, so that programs can treat
+// eSCL and WS-Scan devices alike.
+package wsscan
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/stapelberg/airscan"
+)
+
+// soapContentType is the media type of SOAP 1.2 messages.
+const soapContentType = "application/soap+xml; charset=utf-8"
+
+// A Client allows scanning documents via WS-Scan.
+type Client struct {
+	// HTTPClient is used for all requests made by this Client and can be
+	// overridden for testing or to integrate custom behavior. The default
+	// amounts to http.DefaultClient.
+	HTTPClient interface {
+		Do(*http.Request) (*http.Response, error)
+	}
+
+	endpoint string
+	debug    bool
+
+	// jobs are the scan jobs in progress, see Cancel.
+	jobsMu sync.Mutex
+	jobs   map[*activeJob]bool
+}
+
+// NewClient returns a ready-to-use Client for the scan service at endpoint
+// (an HTTP URL, see Device.ScanService). It is safe to update its struct
+// fields before first using the returned Client.
+func NewClient(endpoint string) *Client {
+	return &Client{
+		endpoint: endpoint,
+		HTTPClient: &http.Client{
+			Transport: http.DefaultTransport.(*http.Transport).Clone(),
+		},
+	}
+}
+
+func (c *Client) SetDebug(debug bool) {
+	c.debug = debug
+}
+
+// call sends a request for the WS-Scan operation op and returns the response
+// body, which is a SOAP message or (for RetrieveImage) a multipart message.
+// Faults sent by the device are returned as *Fault.
+func (c *Client) call(ctx context.Context, op string, content any) (*http.Response, error) {
+	msg, err := marshalEnvelope(header{
+		To:        c.endpoint,
+		Action:    nsScan + "/" + op,
+		MessageID: newMessageID(),
+		ReplyTo:   anonymous,
+	}, content)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", c.endpoint, bytes.NewReader(msg))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", soapContentType)
+	req.Header.Set("User-Agent", "https://github.com/stapelberg/airscan")
+	if c.debug {
+		log.Printf("POST %s (%s)", c.endpoint, op)
+	}
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusOK {
+		return resp, nil
+	}
+	// SOAP 1.2 faults are sent with status 400 (Sender faults) or 500
+	// (Receiver faults):
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(resp.Body)
+	if _, err := unmarshalResponse(b, nil); err != nil {
+		var fault *Fault
+		if errors.As(err, &fault) {
+			if c.debug {
+				log.Printf("-> %v", fault)
+			}
+			return nil, fault
+		}
+	}
+	return nil, &airscan.StatusError{
+		URL:        c.endpoint,
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+		Message:    strings.TrimSpace(string(b)),
+		Want:       []int{http.StatusOK},
+	}
+}
+
+// roundTrip calls op and decodes the SOAP response body into v.
+func (c *Client) roundTrip(ctx context.Context, op string, content, v any) error {
+	resp, err := c.call(ctx, op, content)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if _, err := unmarshalResponse(b, v); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+// Names of the elements which GetScannerElements can request.
+const (
+	ElementScannerDescription   = "wscn:ScannerDescription"
+	ElementScannerConfiguration = "wscn:ScannerConfiguration"
+	ElementScannerStatus        = "wscn:ScannerStatus"
+)
+
+type getScannerElementsRequest struct {
+	XMLName xml.Name `xml:"wscn:GetScannerElementsRequest"`
+	Names   []string `xml:"wscn:RequestedElements>wscn:Name"`
+}
+
+// ScannerElements are the elements which the device returned. Elements which
+// were not requested (or which the device did not return) are nil.
+type ScannerElements struct {
+	Description   *ScannerDescription
+	Configuration *ScannerConfiguration
+	Status        *ScannerStatus
+}
+
+// ScannerDescription describes the device in human-readable terms.
+type ScannerDescription struct {
+	Name     string `xml:"ScannerName"`
+	Info     string `xml:"ScannerInfo"`
+	Location string `xml:"ScannerLocation"`
+}
+
+// ScannerConfiguration describes the capabilities of the device. Sizes are
+// expressed in thousandths of an inch.
+type ScannerConfiguration struct {
+	// Formats are the supported document formats, e.g. jfif or png.
+	Formats []string `xml:"DeviceSettings>FormatsSupported>FormatValue"`
+
+	// ContentTypes are the supported content types, e.g. Text or Photo.
+	ContentTypes []string `xml:"DeviceSettings>ContentTypesSupported>ContentTypeValue"`
+
+	Platen *InputSource `xml:"Platen"`
+	ADF    *ADF         `xml:"ADF"`
+}
+
+// ADF describes the Automatic Document Feeder of the device.
+type ADF struct {
+	SupportsDuplex bool         `xml:"ADFSupportsDuplex"`
+	Front          *InputSource `xml:"ADFFront"`
+	Back           *InputSource `xml:"ADFBack"`
+}
+
+// Size is a width and height, in thousandths of an inch or (for resolutions)
+// in dpi.
+type Size struct {
+	Width  int `xml:"Width"`
+	Height int `xml:"Height"`
+}
+
+// InputSource describes the capabilities of the platen or one side of the
+// ADF.
+type InputSource struct {
+	// ColorModes are the supported color processing modes, which use the
+	// same names as eSCL color modes, e.g. Grayscale8 or RGB24.
+	ColorModes []string
+
+	MinimumSize       Size
+	MaximumSize       Size
+	OpticalResolution Size
+
+	// XResolutions and YResolutions are the supported resolutions, in dpi.
+	XResolutions []int
+	YResolutions []int
+}
+
+// UnmarshalXML decodes the platen or ADF side elements, whose children are
+// prefixed with the input source, e.g. PlatenColor or ADFColor.
+func (s *InputSource) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return err
+		}
+		switch tok := tok.(type) {
+		case xml.EndElement:
+			return nil
+
+		case xml.StartElement:
+			name := strings.TrimPrefix(strings.TrimPrefix(tok.Name.Local, "Platen"), "ADF")
+			var err error
+			switch name {
+			case "Color":
+				var v struct {
+					Entries []string `xml:"ColorEntry"`
+				}
+				err = d.DecodeElement(&v, &tok)
+				s.ColorModes = v.Entries
+			case "MinimumSize":
+				err = d.DecodeElement(&s.MinimumSize, &tok)
+			case "MaximumSize":
+				err = d.DecodeElement(&s.MaximumSize, &tok)
+			case "OpticalResolution":
+				err = d.DecodeElement(&s.OpticalResolution, &tok)
+			case "Resolutions":
+				var v struct {
+					Widths  []int `xml:"Widths>Width"`
+					Heights []int `xml:"Heights>Height"`
+				}
+				err = d.DecodeElement(&v, &tok)
+				s.XResolutions = v.Widths
+				s.YResolutions = v.Heights
+			default:
+				err = d.Skip()
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// ScannerStatus describes the state of the device.
+type ScannerStatus struct {
+	// State is one of Idle, Processing or Stopped.
+	State string `xml:"ScannerState"`
+
+	Conditions []DeviceCondition `xml:"ActiveConditions>DeviceCondition"`
+}
+
+// A DeviceCondition is a condition (typically an error) of the device.
+type DeviceCondition struct {
+	// Name is e.g. InputTrayEmpty, MediaJam or CoverOpen.
+	Name string `xml:"Name"`
+
+	// Component is e.g. Platen or ADF.
+	Component string `xml:"Component"`
+
+	// Severity is one of Informational, Warning or Critical.
+	Severity string `xml:"Severity"`
+}
+
+type getScannerElementsResponse struct {
+	Elements []struct {
+		Name          string                `xml:"Name,attr"`
+		Valid         bool                  `xml:"Valid,attr"`
+		Description   *ScannerDescription   `xml:"ScannerDescription"`
+		Configuration *ScannerConfiguration `xml:"ScannerConfiguration"`
+		Status        *ScannerStatus        `xml:"ScannerStatus"`
+	} `xml:"GetScannerElementsResponse>ScannerElements>ElementData"`
+}
+
+// GetScannerElements requests the specified elements (see the Element
+// constants) from the device.
+func (c *Client) GetScannerElements(ctx context.Context, names ...string) (*ScannerElements, error) {
+	var resp getScannerElementsResponse
+	req := &getScannerElementsRequest{Names: names}
+	if err := c.roundTrip(ctx, "GetScannerElements", req, &resp); err != nil {
+		return nil, err
+	}
+	var elems ScannerElements
+	for _, elem := range resp.Elements {
+		if !elem.Valid {
+			continue
+		}
+		if elem.Description != nil {
+			elems.Description = elem.Description
+		}
+		if elem.Configuration != nil {
+			elems.Configuration = elem.Configuration
+		}
+		if elem.Status != nil {
+			elems.Status = elem.Status
+		}
+	}
+	return &elems, nil
+}
+
+// A ScanTicket describes a scan job. Sizes and offsets are expressed in
+// thousandths of an inch.
+type ScanTicket struct {
+	// JobName is displayed by the device, if at all.
+	JobName string
+
+	// Format is the document format, e.g. jfif or png.
+	Format string
+
+	// ImagesToTransfer is the number of images to scan, or 0 to scan all
+	// documents in the ADF.
+	ImagesToTransfer int
+
+	// InputSource is one of Platen, ADF or ADFDuplex.
+	InputSource string
+
+	// ContentType is e.g. Auto, Text, Photo or Mixed (optional).
+	ContentType string
+
+	// Front configures the front side (and, unless Back is set, the back
+	// side) of the documents.
+	Front MediaSide
+
+	// Back optionally configures the back side when scanning with
+	// InputSource ADFDuplex.
+	Back *MediaSide
+}
+
+// MediaSide configures how one side of the documents is scanned.
+type MediaSide struct {
+	// ColorProcessing is the color mode, e.g. Grayscale8 or RGB24.
+	ColorProcessing string
+
+	// Resolution is the resolution in dpi.
+	Resolution Size
+
+	// Region is the area to scan. If nil, the device scans its default
+	// area.
+	Region *Region
+}
+
+// A Region is the area to scan, in thousandths of an inch.
+type Region struct {
+	XOffset int
+	YOffset int
+	Width   int
+	Height  int
+}
+
+type regionXML struct {
+	XOffset int `xml:"wscn:ScanRegionXOffset"`
+	YOffset int `xml:"wscn:ScanRegionYOffset"`
+	Width   int `xml:"wscn:ScanRegionWidth"`
+	Height  int `xml:"wscn:ScanRegionHeight"`
+}
+
+type mediaSideXML struct {
+	Region          *regionXML `xml:"wscn:ScanRegion,omitempty"`
+	ColorProcessing string     `xml:"wscn:ColorProcessing"`
+	XResolution     int        `xml:"wscn:Resolution>wscn:Width"`
+	YResolution     int        `xml:"wscn:Resolution>wscn:Height"`
+}
+
+func (s *MediaSide) marshal() *mediaSideXML {
+	m := &mediaSideXML{
+		ColorProcessing: s.ColorProcessing,
+		XResolution:     s.Resolution.Width,
+		YResolution:     s.Resolution.Height,
+	}
+	if r := s.Region; r != nil {
+		m.Region = &regionXML{
+			XOffset: r.XOffset,
+			YOffset: r.YOffset,
+			Width:   r.Width,
+			Height:  r.Height,
+		}
+	}
+	return m
+}
+
+type createScanJobRequest struct {
+	XMLName          xml.Name      `xml:"wscn:CreateScanJobRequest"`
+	JobName          string        `xml:"wscn:ScanTicket>wscn:JobDescription>wscn:JobName"`
+	UserName         string        `xml:"wscn:ScanTicket>wscn:JobDescription>wscn:JobOriginatingUserName"`
+	Format           string        `xml:"wscn:ScanTicket>wscn:DocumentParameters>wscn:Format"`
+	ImagesToTransfer int           `xml:"wscn:ScanTicket>wscn:DocumentParameters>wscn:ImagesToTransfer"`
+	InputSource      string        `xml:"wscn:ScanTicket>wscn:DocumentParameters>wscn:InputSource"`
+	ContentType      string        `xml:"wscn:ScanTicket>wscn:DocumentParameters>wscn:ContentType,omitempty"`
+	Front            *mediaSideXML `xml:"wscn:ScanTicket>wscn:DocumentParameters>wscn:MediaSides>wscn:MediaFront"`
+	Back             *mediaSideXML `xml:"wscn:ScanTicket>wscn:DocumentParameters>wscn:MediaSides>wscn:MediaBack,omitempty"`
+}
+
+// A ScanJob is a scan job which the device created.
+type ScanJob struct {
+	ID    int    `xml:"JobId"`
+	Token string `xml:"JobToken"`
+}
+
+type createScanJobResponse struct {
+	Job ScanJob `xml:"CreateScanJobResponse"`
+}
+
+// CreateScanJob creates a scan job. Use RetrieveImage to receive its images.
+func (c *Client) CreateScanJob(ctx context.Context, ticket *ScanTicket) (*ScanJob, error) {
+	req := &createScanJobRequest{
+		JobName:          ticket.JobName,
+		UserName:         "airscan",
+		Format:           ticket.Format,
+		ImagesToTransfer: ticket.ImagesToTransfer,
+		InputSource:      ticket.InputSource,
+		ContentType:      ticket.ContentType,
+		Front:            ticket.Front.marshal(),
+	}
+	if ticket.Back != nil {
+		req.Back = ticket.Back.marshal()
+	}
+	var resp createScanJobResponse
+	if err := c.roundTrip(ctx, "CreateScanJob", req, &resp); err != nil {
+		return nil, err
+	}
+	if c.debug {
+		log.Printf("ScanJob created: %+v", resp.Job)
+	}
+	return &resp.Job, nil
+}
+
+type retrieveImageRequest struct {
+	XMLName      xml.Name `xml:"wscn:RetrieveImageRequest"`
+	JobID        int      `xml:"wscn:JobId"`
+	JobToken     string   `xml:"wscn:JobToken"`
+	DocumentName string   `xml:"wscn:DocumentDescription>wscn:DocumentName"`
+}
+
+type retrieveImageResponse struct {
+	Include struct {
+		Href string `xml:"href,attr"`
+	} `xml:"RetrieveImageResponse>ScanData>Include"`
+}
+
+// FaultNoImagesAvailable is the fault subcode with which devices signal that
+// all images of a scan job were retrieved.
+const FaultNoImagesAvailable = "ClientErrorNoImagesAvailable"
+
+// RetrieveImage requests the next image of the scan job and returns its data,
+// which the caller must close. Once all images were retrieved, RetrieveImage
+// returns io.EOF.
+//
+// Note: package wsscan never interprets scan data, the package only provides
+// the data as-is.
+func (c *Client) RetrieveImage(ctx context.Context, job *ScanJob) (io.ReadCloser, error) {
+	resp, err := c.call(ctx, "RetrieveImage", &retrieveImageRequest{
+		JobID:        job.ID,
+		JobToken:     job.Token,
+		DocumentName: "airscan",
+	})
+	if err != nil {
+		var fault *Fault
+		if errors.As(err, &fault) && fault.Subcode == FaultNoImagesAvailable {
+			return nil, io.EOF
+		}
+		return nil, err
+	}
+	rc, err := c.image(resp)
+	if err != nil {
+		drainAndClose(resp.Body)
+		var fault *Fault
+		if errors.As(err, &fault) && fault.Subcode == FaultNoImagesAvailable {
+			return nil, io.EOF
+		}
+		return nil, err
+	}
+	return rc, nil
+}
+
+// image returns the image data of the RetrieveImage response, which is an
+// MTOM (multipart/related) message: the root part is the SOAP message, which
+// references the part containing the image data.
+func (c *Client) image(resp *http.Response) (io.ReadCloser, error) {
+	mediaType, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(mediaType, "multipart/") {
+		// Not an image, but possibly a fault:
+		b, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := unmarshalResponse(b, nil); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("RetrieveImage: unexpected Content-Type %q", mediaType)
+	}
+	mr := multipart.NewReader(resp.Body, params["boundary"])
+	root, err := mr.NextPart()
+	if err != nil {
+		return nil, fmt.Errorf("RetrieveImage: %v", err)
+	}
+	b, err := io.ReadAll(root)
+	if err != nil {
+		return nil, err
+	}
+	var ri retrieveImageResponse
+	if _, err := unmarshalResponse(b, &ri); err != nil {
+		return nil, err
+	}
+	cid := strings.TrimPrefix(ri.Include.Href, "cid:")
+	for {
+		part, err := mr.NextPart()
+		if err != nil {
+			return nil, fmt.Errorf("RetrieveImage: image part %q: %v", cid, err)
+		}
+		if id := strings.Trim(part.Header.Get("Content-Id"), "<>"); cid == "" || id == cid {
+			return &imageReader{Reader: part, body: resp.Body}, nil
+		}
+	}
+}
+
+// imageReader reads the image part of a RetrieveImage response.
+type imageReader struct {
+	io.Reader
+	body io.ReadCloser
+}
+
+func (r *imageReader) Close() error {
+	drainAndClose(r.body)
+	return nil
+}
+
+type cancelJobRequest struct {
+	XMLName xml.Name `xml:"wscn:CancelJobRequest"`
+	JobID   int      `xml:"wscn:JobId"`
+}
+
+// CancelJob aborts the scan job on the device.
+func (c *Client) CancelJob(ctx context.Context, job *ScanJob) error {
+	if c.debug {
+		log.Printf("Canceling ScanJob %d", job.ID)
+	}
+	return c.roundTrip(ctx, "CancelJob", &cancelJobRequest{JobID: job.ID}, nil)
+}
+
+// drainAndClose reads the remainder of body (up to a limit) before closing it,
+// so that the underlying connection can be re-used for subsequent requests.
+func drainAndClose(body io.ReadCloser) {
+	io.Copy(io.Discard, io.LimitReader(body, 1<<20))
+	body.Close()
+}
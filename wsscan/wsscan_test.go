@@ -0,0 +1,617 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wsscan_test
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/stapelberg/airscan"
+	"github.com/stapelberg/airscan/preset"
+	"github.com/stapelberg/airscan/wsscan"
+)
+
+// The stand-in uses a different namespace prefix than package wsscan (as do
+// many devices) to verify that only local names are matched.
+const envelopeTmpl = `<?xml version="1.0" encoding="UTF-8"?>
+<SOAP-ENV:Envelope xmlns:SOAP-ENV="http://www.w3.org/2003/05/soap-envelope" xmlns:wsa="http://schemas.xmlsoap.org/ws/2004/08/addressing" xmlns:wsd="http://schemas.xmlsoap.org/ws/2005/04/discovery" xmlns:sca="http://schemas.microsoft.com/windows/2006/08/wdp/scan" xmlns:mex="http://schemas.xmlsoap.org/ws/2004/09/mex" xmlns:wsdp="http://schemas.xmlsoap.org/ws/2006/02/devprof">
+  <SOAP-ENV:Header>
+    <wsa:Action>%s</wsa:Action>
+    <wsa:RelatesTo>%s</wsa:RelatesTo>
+  </SOAP-ENV:Header>
+  <SOAP-ENV:Body>%s</SOAP-ENV:Body>
+</SOAP-ENV:Envelope>`
+
+const scannerElements = `
+<sca:GetScannerElementsResponse>
+  <sca:ScannerElements>
+    <sca:ElementData Name="sca:ScannerDescription" Valid="true">
+      <sca:ScannerDescription>
+        <sca:ScannerName>Brother MFC-J5330DW</sca:ScannerName>
+        <sca:ScannerInfo>Office</sca:ScannerInfo>
+      </sca:ScannerDescription>
+    </sca:ElementData>
+    <sca:ElementData Name="sca:ScannerConfiguration" Valid="true">
+      <sca:ScannerConfiguration>
+        <sca:DeviceSettings>
+          <sca:FormatsSupported>
+            <sca:FormatValue>jfif</sca:FormatValue>
+            <sca:FormatValue>png</sca:FormatValue>
+          </sca:FormatsSupported>
+          <sca:ContentTypesSupported>
+            <sca:ContentTypeValue>Auto</sca:ContentTypeValue>
+            <sca:ContentTypeValue>Text</sca:ContentTypeValue>
+            <sca:ContentTypeValue>Photo</sca:ContentTypeValue>
+            <sca:ContentTypeValue>Mixed</sca:ContentTypeValue>
+          </sca:ContentTypesSupported>
+        </sca:DeviceSettings>
+        <sca:Platen>
+          <sca:PlatenColor>
+            <sca:ColorEntry>Grayscale8</sca:ColorEntry>
+            <sca:ColorEntry>RGB24</sca:ColorEntry>
+          </sca:PlatenColor>
+          <sca:PlatenMinimumSize><sca:Width>100</sca:Width><sca:Height>100</sca:Height></sca:PlatenMinimumSize>
+          <sca:PlatenMaximumSize><sca:Width>8500</sca:Width><sca:Height>11690</sca:Height></sca:PlatenMaximumSize>
+          <sca:PlatenOpticalResolution><sca:Width>1200</sca:Width><sca:Height>1200</sca:Height></sca:PlatenOpticalResolution>
+          <sca:PlatenResolutions>
+            <sca:Widths><sca:Width>100</sca:Width><sca:Width>300</sca:Width></sca:Widths>
+            <sca:Heights><sca:Height>100</sca:Height><sca:Height>300</sca:Height></sca:Heights>
+          </sca:PlatenResolutions>
+        </sca:Platen>
+        <sca:ADF>
+          <sca:ADFSupportsDuplex>1</sca:ADFSupportsDuplex>
+          <sca:ADFFront>
+            <sca:ADFColor><sca:ColorEntry>Grayscale8</sca:ColorEntry></sca:ADFColor>
+            <sca:ADFMinimumSize><sca:Width>2000</sca:Width><sca:Height>2000</sca:Height></sca:ADFMinimumSize>
+            <sca:ADFMaximumSize><sca:Width>8500</sca:Width><sca:Height>14000</sca:Height></sca:ADFMaximumSize>
+            <sca:ADFOpticalResolution><sca:Width>600</sca:Width><sca:Height>600</sca:Height></sca:ADFOpticalResolution>
+            <sca:ADFResolutions>
+              <sca:Widths><sca:Width>300</sca:Width></sca:Widths>
+              <sca:Heights><sca:Height>300</sca:Height></sca:Heights>
+            </sca:ADFResolutions>
+          </sca:ADFFront>
+        </sca:ADF>
+      </sca:ScannerConfiguration>
+    </sca:ElementData>
+    <sca:ElementData Name="sca:ScannerStatus" Valid="true">
+      <sca:ScannerStatus>
+        <sca:ScannerState>Idle</sca:ScannerState>
+        <sca:ActiveConditions>
+          <sca:DeviceCondition>
+            <sca:Name>InputTrayEmpty</sca:Name>
+            <sca:Component>ADF</sca:Component>
+            <sca:Severity>Informational</sca:Severity>
+          </sca:DeviceCondition>
+        </sca:ActiveConditions>
+      </sca:ScannerStatus>
+    </sca:ElementData>
+  </sca:ScannerElements>
+</sca:GetScannerElementsResponse>`
+
+const fault = `
+<SOAP-ENV:Fault>
+  <SOAP-ENV:Code>
+    <SOAP-ENV:Value>SOAP-ENV:%s</SOAP-ENV:Value>
+    <SOAP-ENV:Subcode><SOAP-ENV:Value>sca:%s</SOAP-ENV:Value></SOAP-ENV:Subcode>
+  </SOAP-ENV:Code>
+  <SOAP-ENV:Reason><SOAP-ENV:Text xml:lang="en">%s</SOAP-ENV:Text></SOAP-ENV:Reason>
+</SOAP-ENV:Fault>`
+
+// request is a SOAP request as received by the stand-in.
+type request struct {
+	Action    string `xml:"Header>Action"`
+	MessageID string `xml:"Header>MessageID"`
+	Body      struct {
+		Content string `xml:",innerxml"`
+	} `xml:"Body"`
+}
+
+// ticket is the part of CreateScanJobRequest which the tests verify.
+type ticket struct {
+	Format           string `xml:"ScanTicket>DocumentParameters>Format"`
+	ImagesToTransfer int    `xml:"ScanTicket>DocumentParameters>ImagesToTransfer"`
+	InputSource      string `xml:"ScanTicket>DocumentParameters>InputSource"`
+	ContentType      string `xml:"ScanTicket>DocumentParameters>ContentType"`
+	ColorProcessing  string `xml:"ScanTicket>DocumentParameters>MediaSides>MediaFront>ColorProcessing"`
+	XResolution      int    `xml:"ScanTicket>DocumentParameters>MediaSides>MediaFront>Resolution>Width"`
+	YResolution      int    `xml:"ScanTicket>DocumentParameters>MediaSides>MediaFront>Resolution>Height"`
+	Width            int    `xml:"ScanTicket>DocumentParameters>MediaSides>MediaFront>ScanRegion>ScanRegionWidth"`
+	Height           int    `xml:"ScanTicket>DocumentParameters>MediaSides>MediaFront>ScanRegion>ScanRegionHeight"`
+}
+
+// standIn is a WS-Scan scan service, which serves pages for each scan job.
+type standIn struct {
+	t     *testing.T
+	pages [][]byte
+
+	// elements, if non-empty, replaces the GetScannerElements response.
+	elements string
+
+	// createFault, if non-empty, is the subcode of the fault with which
+	// CreateScanJob fails.
+	createFault string
+
+	mu        sync.Mutex
+	tickets   []ticket
+	retrieved int
+	canceled  []int
+}
+
+func (s *standIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req request
+	if err := xml.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	reply := func(status int, body string) {
+		w.Header().Set("Content-Type", "application/soap+xml; charset=utf-8")
+		w.WriteHeader(status)
+		fmt.Fprintf(w, envelopeTmpl, req.Action+"Response", req.MessageID, body)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	const ns = "http://schemas.microsoft.com/windows/2006/08/wdp/scan/"
+	switch strings.TrimPrefix(req.Action, ns) {
+	case "GetScannerElements":
+		if s.elements != "" {
+			reply(http.StatusOK, s.elements)
+			return
+		}
+		reply(http.StatusOK, scannerElements)
+
+	case "CreateScanJob":
+		if s.createFault != "" {
+			reply(http.StatusBadRequest, fmt.Sprintf(fault, "Sender", s.createFault, "busy"))
+			return
+		}
+		var t ticket
+		if err := xml.Unmarshal([]byte(req.Body.Content), &t); err != nil {
+			s.t.Errorf("decoding ticket: %v", err)
+		}
+		s.tickets = append(s.tickets, t)
+		s.retrieved = 0
+		reply(http.StatusOK, `<sca:CreateScanJobResponse><sca:JobId>42</sca:JobId><sca:JobToken>token42</sca:JobToken></sca:CreateScanJobResponse>`)
+
+	case "RetrieveImage":
+		if !strings.Contains(req.Body.Content, "token42") {
+			reply(http.StatusBadRequest, fmt.Sprintf(fault, "Sender", "ClientErrorInvalidJobToken", ""))
+			return
+		}
+		if s.retrieved == len(s.pages) {
+			reply(http.StatusBadRequest, fmt.Sprintf(fault, "Sender", "ClientErrorNoImagesAvailable", "no more images"))
+			return
+		}
+		page := s.pages[s.retrieved]
+		s.retrieved++
+		mw := multipart.NewWriter(w)
+		w.Header().Set("Content-Type", `multipart/related; type="application/xop+xml"; boundary=`+mw.Boundary())
+		root, _ := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type": {`application/xop+xml; type="application/soap+xml"`},
+			"Content-Id":   {"<root>"},
+		})
+		fmt.Fprintf(root, envelopeTmpl, req.Action+"Response", req.MessageID,
+			`<sca:RetrieveImageResponse><sca:ScanData><xop:Include xmlns:xop="http://www.w3.org/2004/08/xop/include" href="cid:image"/></sca:ScanData></sca:RetrieveImageResponse>`)
+		part, _ := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type": {"application/binary"},
+			"Content-Id":   {"<image>"},
+		})
+		part.Write(page)
+		mw.Close()
+
+	case "CancelJob":
+		s.canceled = append(s.canceled, 42)
+		reply(http.StatusOK, `<sca:CancelJobResponse/>`)
+
+	default:
+		reply(http.StatusBadRequest, fmt.Sprintf(fault, "Sender", "InvalidArgs", "unknown action"))
+	}
+}
+
+func clientFor(t *testing.T, h http.Handler) *wsscan.Client {
+	t.Helper()
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	cl := wsscan.NewClient(srv.URL + "/wsd/scan")
+	cl.HTTPClient = srv.Client()
+	return cl
+}
+
+func TestGetScannerElements(t *testing.T) {
+	cl := clientFor(t, &standIn{t: t})
+	elems, err := cl.GetScannerElements(context.Background(),
+		wsscan.ElementScannerDescription,
+		wsscan.ElementScannerConfiguration,
+		wsscan.ElementScannerStatus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &wsscan.ScannerElements{
+		Description: &wsscan.ScannerDescription{
+			Name: "Brother MFC-J5330DW",
+			Info: "Office",
+		},
+		Configuration: &wsscan.ScannerConfiguration{
+			Formats:      []string{"jfif", "png"},
+			ContentTypes: []string{"Auto", "Text", "Photo", "Mixed"},
+			Platen: &wsscan.InputSource{
+				ColorModes:        []string{"Grayscale8", "RGB24"},
+				MinimumSize:       wsscan.Size{Width: 100, Height: 100},
+				MaximumSize:       wsscan.Size{Width: 8500, Height: 11690},
+				OpticalResolution: wsscan.Size{Width: 1200, Height: 1200},
+				XResolutions:      []int{100, 300},
+				YResolutions:      []int{100, 300},
+			},
+			ADF: &wsscan.ADF{
+				SupportsDuplex: true,
+				Front: &wsscan.InputSource{
+					ColorModes:        []string{"Grayscale8"},
+					MinimumSize:       wsscan.Size{Width: 2000, Height: 2000},
+					MaximumSize:       wsscan.Size{Width: 8500, Height: 14000},
+					OpticalResolution: wsscan.Size{Width: 600, Height: 600},
+					XResolutions:      []int{300},
+					YResolutions:      []int{300},
+				},
+			},
+		},
+		Status: &wsscan.ScannerStatus{
+			State: "Idle",
+			Conditions: []wsscan.DeviceCondition{
+				{Name: "InputTrayEmpty", Component: "ADF", Severity: "Informational"},
+			},
+		},
+	}
+	if diff := cmp.Diff(want, elems); diff != "" {
+		t.Fatalf("GetScannerElements: unexpected elements: diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestScannerStatus(t *testing.T) {
+	var scanner airscan.Scanner = clientFor(t, &standIn{t: t})
+	status, err := scanner.ScannerStatusContext(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &airscan.ScannerStatus{
+		State:    "Idle",
+		ADFState: "ScannerAdfEmpty",
+	}
+	if diff := cmp.Diff(want, status); diff != "" {
+		t.Fatalf("ScannerStatus: unexpected status: diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestScannerStatusNoCondition(t *testing.T) {
+	// Without an active ADF condition, it is unknown whether documents are
+	// inserted into the ADF:
+	start := strings.Index(scannerElements, "<sca:ActiveConditions>")
+	end := strings.Index(scannerElements, "</sca:ActiveConditions>") + len("</sca:ActiveConditions>")
+	si := &standIn{t: t, elements: scannerElements[:start] + scannerElements[end:]}
+	status, err := clientFor(t, si).ScannerStatusContext(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &airscan.ScannerStatus{State: "Idle"}
+	if diff := cmp.Diff(want, status); diff != "" {
+		t.Fatalf("ScannerStatus: unexpected status: diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestScannerCapabilities(t *testing.T) {
+	var scanner airscan.Scanner = clientFor(t, &standIn{t: t})
+	caps, err := scanner.ScannerCapabilitiesContext(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := caps.MakeAndModel, "Brother MFC-J5330DW"; got != want {
+		t.Errorf("MakeAndModel: got %q, want %q", got, want)
+	}
+
+	type inputCaps struct {
+		MaxWidth, MaxHeight int
+		ColorModes          []string
+		DocumentFormats     []string
+		ContentTypes        []string
+		Resolutions         []string
+	}
+	summarize := func(ic *airscan.InputCaps) *inputCaps {
+		if ic == nil {
+			return nil
+		}
+		return &inputCaps{
+			MaxWidth:        ic.MaxWidth,
+			MaxHeight:       ic.MaxHeight,
+			ColorModes:      ic.ColorModes(),
+			DocumentFormats: ic.DocumentFormats(),
+			ContentTypes:    ic.ContentTypes(),
+			Resolutions:     ic.Resolutions(),
+		}
+	}
+	for _, tt := range []struct {
+		inputSource string
+		duplex      bool
+		want        *inputCaps
+	}{
+		{
+			inputSource: "Platen",
+			want: &inputCaps{
+				MaxWidth:        2550,
+				MaxHeight:       3507,
+				ColorModes:      []string{"Grayscale8", "RGB24"},
+				DocumentFormats: []string{"image/jpeg", "image/png"},
+				ContentTypes:    []string{"Text", "Photo", "TextAndPhoto"},
+				Resolutions:     []string{"100x100", "300x300"},
+			},
+		},
+		{
+			inputSource: "Feeder",
+			duplex:      true,
+			want: &inputCaps{
+				MaxWidth:        2550,
+				MaxHeight:       4200,
+				ColorModes:      []string{"Grayscale8"},
+				DocumentFormats: []string{"image/jpeg", "image/png"},
+				ContentTypes:    []string{"Text", "Photo", "TextAndPhoto"},
+				Resolutions:     []string{"300x300"},
+			},
+		},
+	} {
+		got := summarize(caps.InputCaps(tt.inputSource, tt.duplex))
+		if diff := cmp.Diff(tt.want, got); diff != "" {
+			t.Errorf("InputCaps(%q, %v): diff (-want +got):\n%s", tt.inputSource, tt.duplex, diff)
+		}
+	}
+}
+
+func TestScannerCapabilitiesEscaping(t *testing.T) {
+	elements := strings.Replace(scannerElements,
+		"<sca:ScannerName>Brother MFC-J5330DW</sca:ScannerName>",
+		"<sca:ScannerName>AT&amp;T &lt;Scanner&gt;</sca:ScannerName>", 1)
+	elements = strings.Replace(elements,
+		"<sca:ColorEntry>RGB24</sca:ColorEntry>",
+		"<sca:ColorEntry>R&amp;B</sca:ColorEntry>", 1)
+	caps, err := clientFor(t, &standIn{t: t, elements: elements}).ScannerCapabilitiesContext(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := caps.MakeAndModel, "AT&T <Scanner>"; got != want {
+		t.Errorf("MakeAndModel: got %q, want %q", got, want)
+	}
+	if diff := cmp.Diff([]string{"Grayscale8", "R&B"}, caps.InputCaps("Platen", false).ColorModes()); diff != "" {
+		t.Errorf("ColorModes: diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestScanPages(t *testing.T) {
+	pages := [][]byte{[]byte("page 1"), []byte("page 2"), []byte("page 3")}
+	si := &standIn{t: t, pages: pages}
+	var scanner airscan.Scanner = clientFor(t, si)
+	settings := preset.GrayscaleA4ADF()
+	settings.DocumentFormat = "image/png"
+	settings.Duplex = true
+	settings.ContentType = "TextAndPhoto"
+	var got [][]byte
+	var numbers []int
+	for page, err := range scanner.ScanPages(context.Background(), settings) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, page.Data)
+		numbers = append(numbers, page.Number)
+	}
+	if diff := cmp.Diff(pages, got); diff != "" {
+		t.Fatalf("unexpected scan data: diff (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]int{1, 2, 3}, numbers); diff != "" {
+		t.Fatalf("unexpected page numbers: diff (-want +got):\n%s", diff)
+	}
+
+	region := settings.ScanRegions.Regions[0]
+	want := []ticket{
+		{
+			Format:          "png",
+			InputSource:     "ADFDuplex",
+			ContentType:     "Mixed",
+			ColorProcessing: "Grayscale8",
+			XResolution:     settings.XResolution,
+			YResolution:     settings.YResolution,
+			Width:           int(math.Round(airscan.UnitsToInches(region.Width) * 1000)),
+			Height:          int(math.Round(airscan.UnitsToInches(region.Height) * 1000)),
+		},
+	}
+	if diff := cmp.Diff(want, si.tickets); diff != "" {
+		t.Fatalf("unexpected scan ticket: diff (-want +got):\n%s", diff)
+	}
+	if len(si.canceled) > 0 {
+		t.Fatalf("completed scan job unexpectedly canceled")
+	}
+}
+
+func TestScanPagesBreak(t *testing.T) {
+	si := &standIn{t: t, pages: [][]byte{[]byte("page 1"), []byte("page 2")}}
+	cl := clientFor(t, si)
+	settings := preset.GrayscaleA4ADF()
+	settings.InputSource = "Platen"
+	for _, err := range cl.ScanPages(context.Background(), settings) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		break
+	}
+	if diff := cmp.Diff([]int{42}, si.canceled); diff != "" {
+		t.Fatalf("unexpected canceled jobs: diff (-want +got):\n%s", diff)
+	}
+	if got, want := si.tickets[0].ImagesToTransfer, 1; got != want {
+		t.Fatalf("unexpected ImagesToTransfer for platen: got %d, want %d", got, want)
+	}
+}
+
+func TestCancel(t *testing.T) {
+	si := &standIn{t: t, pages: [][]byte{[]byte("page 1"), []byte("page 2")}}
+	var scanner airscan.Scanner = clientFor(t, si)
+	pages := 0
+	for _, err := range scanner.ScanPages(context.Background(), preset.GrayscaleA4ADF()) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages++
+		if err := scanner.Cancel(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, want := pages, 1; got != want {
+		t.Errorf("unexpected number of pages: got %d, want %d", got, want)
+	}
+	if diff := cmp.Diff([]int{42}, si.canceled); diff != "" {
+		t.Fatalf("unexpected canceled jobs: diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestFault(t *testing.T) {
+	cl := clientFor(t, &standIn{t: t, createFault: "ServerErrorNotAcceptingJobs"})
+	_, err := cl.CreateScanJob(context.Background(), &wsscan.ScanTicket{
+		Format:      "jfif",
+		InputSource: "Platen",
+	})
+	var fault *wsscan.Fault
+	if !errors.As(err, &fault) {
+		t.Fatalf("CreateScanJob: got err %v, want *wsscan.Fault", err)
+	}
+	want := &wsscan.Fault{
+		Code:    "Sender",
+		Subcode: "ServerErrorNotAcceptingJobs",
+		Reason:  "busy",
+	}
+	if diff := cmp.Diff(want, fault); diff != "" {
+		t.Fatalf("unexpected fault: diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestProbe(t *testing.T) {
+	// The device’s HTTP server, which answers WS-Transfer Get requests with
+	// its metadata:
+	const metadata = `
+<mex:Metadata>
+  <mex:MetadataSection Dialect="http://schemas.xmlsoap.org/ws/2006/02/devprof/Relationship">
+    <wsdp:Relationship Type="http://schemas.xmlsoap.org/ws/2006/02/devprof/host">
+      <wsdp:Host>
+        <wsa:EndpointReference><wsa:Address>urn:uuid:device</wsa:Address></wsa:EndpointReference>
+      </wsdp:Host>
+      <wsdp:Hosted>
+        <wsa:EndpointReference><wsa:Address>http://192.0.2.1/print</wsa:Address></wsa:EndpointReference>
+        <wsdp:Types>wprt:PrinterServiceType</wsdp:Types>
+      </wsdp:Hosted>
+      <wsdp:Hosted>
+        <wsa:EndpointReference><wsa:Address>http://192.0.2.1/scan</wsa:Address></wsa:EndpointReference>
+        <wsdp:Types>sca:ScannerServiceType</wsdp:Types>
+      </wsdp:Hosted>
+    </wsdp:Relationship>
+  </mex:MetadataSection>
+</mex:Metadata>`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req request
+		if err := xml.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		fmt.Fprintf(w, envelopeTmpl, req.Action+"Response", req.MessageID, metadata)
+	}))
+	defer srv.Close()
+
+	// The device’s WS-Discovery responder:
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	go func() {
+		buf := make([]byte, 65536)
+		for {
+			n, raddr, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			var req request
+			if err := xml.Unmarshal(buf[:n], &req); err != nil {
+				t.Errorf("decoding probe: %v", err)
+				return
+			}
+			if !strings.Contains(req.Body.Content, "ScanDeviceType") {
+				t.Errorf("probe does not ask for ScanDeviceType: %s", req.Body.Content)
+			}
+			// A stray response to a different probe, which must be
+			// ignored:
+			conn.WriteToUDP([]byte(fmt.Sprintf(envelopeTmpl, "ProbeMatches", "urn:uuid:other", "")), raddr)
+			match := `<wsd:ProbeMatches><wsd:ProbeMatch>
+  <wsa:EndpointReference><wsa:Address>urn:uuid:device</wsa:Address></wsa:EndpointReference>
+  <wsd:Types>wsdp:Device sca:ScanDeviceType</wsd:Types>
+  <wsd:XAddrs>` + srv.URL + `/wsd</wsd:XAddrs>
+</wsd:ProbeMatch></wsd:ProbeMatches>`
+			conn.WriteToUDP([]byte(fmt.Sprintf(envelopeTmpl, "ProbeMatches", req.MessageID, match)), raddr)
+		}
+	}()
+
+	ctx, canc := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer canc()
+	devices, err := wsscan.Probe(ctx, conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*wsscan.Device{
+		{
+			Address: "urn:uuid:device",
+			Types:   []string{"wsdp:Device", "sca:ScanDeviceType"},
+			XAddrs:  []string{srv.URL + "/wsd"},
+		},
+	}
+	if diff := cmp.Diff(want, devices); diff != "" {
+		t.Fatalf("Probe: unexpected devices: diff (-want +got):\n%s", diff)
+	}
+
+	endpoint, err := devices[0].ScanService(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := endpoint, "http://192.0.2.1/scan"; got != want {
+		t.Fatalf("ScanService: got %q, want %q", got, want)
+	}
+}
+
+func TestRetrieveImageEOF(t *testing.T) {
+	cl := clientFor(t, &standIn{t: t})
+	job, err := cl.CreateScanJob(context.Background(), &wsscan.ScanTicket{
+		Format:      "jfif",
+		InputSource: "ADF",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cl.RetrieveImage(context.Background(), job); err != io.EOF {
+		t.Fatalf("RetrieveImage: got err %v, want io.EOF", err)
+	}
+}